@@ -0,0 +1,104 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CacheWarmupSpec defines the desired state of CacheWarmup
+type CacheWarmupSpec struct {
+	// Name of the ContentDeliveryNetwork, in the same namespace, whose edges are warmed
+	CDNRef string `json:"cdnRef"`
+	// URLs to fetch, absolute or relative to the CDN domain
+	URLs []string `json:"urls,omitempty"`
+	// Sitemap (or sitemap index) listing URLs to fetch
+	SitemapURL string `json:"sitemapURL,omitempty"`
+	// Plain text manifest listing one URL to fetch per line
+	ManifestURL string `json:"manifestURL,omitempty"`
+	// Edge pods fetched from. Every pod caches on its own, so all of them
+	// are warmed up.
+	// +kubebuilder:validation:Enum=AllPods
+	// +kubebuilder:default=AllPods
+	// +optional
+	Target string `json:"target,omitempty"`
+	// Maximum number of fetches in flight
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=4
+	// +optional
+	Concurrency int `json:"concurrency,omitempty"`
+	// Cron schedule repeating the warm-up, it runs once if empty
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// CacheWarmupStatus defines the observed state of CacheWarmup
+type CacheWarmupStatus struct {
+	// Warm-up state: Pending, Running, Completed or Failed
+	State string `json:"state,omitempty"`
+	// Fetches planned in the current or last run
+	Total int `json:"total"`
+	// Fetches which succeeded
+	Succeeded int `json:"succeeded"`
+	// Fetches which failed
+	Failed int `json:"failed"`
+	// First failures of the current or last run
+	Failures []WarmupFailure `json:"failures,omitempty"`
+	// Start of the current or last run
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// End of the last run
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Generation of the spec the status refers to
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Error preventing the run, such as an unreachable sitemap
+	Message string `json:"message,omitempty"`
+}
+
+// WarmupFailure records a URL an edge pod failed to fetch
+type WarmupFailure struct {
+	URL   string `json:"url"`
+	Pod   string `json:"pod"`
+	Error string `json:"error"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Succeeded",type=integer,JSONPath=`.status.succeeded`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+//+kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.total`
+
+// CacheWarmup is the Schema for the cachewarmups API
+type CacheWarmup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CacheWarmupSpec   `json:"spec,omitempty"`
+	Status CacheWarmupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// CacheWarmupList contains a list of CacheWarmup
+type CacheWarmupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CacheWarmup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CacheWarmup{}, &CacheWarmupList{})
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package v3
 
 import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -26,10 +29,13 @@ import (
 
 // ContentDeliveryNetworkSpec defines the desired state of ContentDeliveryNetwork
 type (
+	// +kubebuilder:validation:XValidation:rule="!has(self.storage) || !has(self.storage.mode) || self.storage.mode != 'Shared' || self.maxReplicas <= 1 || !has(self.storage.accessMode) || self.storage.accessMode == 'ReadWriteMany'",message="the Shared storage mode needs the ReadWriteMany access mode past one replica"
 	ContentDeliveryNetworkSpec struct {
 		// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 		// Important: Run "make" to regenerate code after modifying this file
 
+		// DNS settings of the CDN domain. Regions set here replace those of the
+		// DomainNameSystem serving it.
 		DNS      DomainNameSystem             `json:"dns"`
 		CDNNodes []ContentDeliveryNetworkNode `json:"cdnNodes"`
 
@@ -43,53 +49,524 @@ type (
 		CacheRules []CacheRule `json:"cacheRules,omitempty"`
 		// SSL/TLS configuration
 		SSLConfig *SSLConfig `json:"sslConfig,omitempty"`
+		// Per route edge behavior
+		Routes []Route `json:"routes,omitempty"`
+		// Redirects answered at the edge, the first matching rule applies
+		Redirects []RedirectRule `json:"redirects,omitempty"`
+		// Internal rewrites of the origin path, the first matching rule applies.
+		// Routes and cache rules match the rewritten path.
+		Rewrites []RewriteRule `json:"rewrites,omitempty"`
+		// HLS/DASH aware caching
+		Streaming *Streaming `json:"streaming,omitempty"`
+		// CORS policy enforced at the edge
+		CORS *CORSPolicy `json:"cors,omitempty"`
+		// Country based access control and origin selection
+		GeoRestrictions *GeoRestrictions `json:"geoRestrictions,omitempty"`
+		// Volumes holding the disk cache of the edges
+		Storage *CDNStorage `json:"storage,omitempty"`
+		// How clients reach the edges, through an Ingress if unset
+		// +optional
+		Exposure *CDNExposure `json:"exposure,omitempty"`
+		// CIDRs of the proxies in front of the edges, such as the Ingress
+		// controller pods, whose X-Forwarded-For header is trusted to carry
+		// the client address. Forwarded addresses are ignored if unset.
+		// +optional
+		TrustedProxies []string `json:"trustedProxies,omitempty"`
 		// Image pull policy
 		ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy"`
+		// Compute resources of the edge pods, 100m CPU and 128Mi of memory
+		// requested if unset. Scaling on CPU needs a CPU request.
+		// +optional
+		Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
 		// Replicas
 		MinReplicas int `json:"minReplicas"`
 		MaxReplicas int `json:"maxReplicas"`
+		// How the edge pods scale between MinReplicas and MaxReplicas
+		// +optional
+		Autoscaling *CDNAutoscaling `json:"autoscaling,omitempty"`
+	}
+
+	// CDNAutoscaling sets the per pod targets the edges scale on, the replica
+	// count following the metric furthest above its target. The edge metrics
+	// reach a HorizontalPodAutoscaler through a custom metrics adapter, such
+	// as prometheus-adapter with config/prometheus/edge_adapter_rules.yaml,
+	// as cdn_requests_per_second, cdn_bandwidth_bytes_per_second and
+	// cdn_cache_fill_ratio.
+	CDNAutoscaling struct {
+		// Average CPU utilization, in percent of the request
+		// +kubebuilder:validation:Minimum=1
+		// +kubebuilder:default=70
+		// +optional
+		TargetCPUUtilization *int32 `json:"targetCPUUtilization,omitempty"`
+		// Requests served per second
+		// +optional
+		TargetRequestsPerSecond *resource.Quantity `json:"targetRequestsPerSecond,omitempty"`
+		// Bytes sent to clients per second
+		// +optional
+		TargetBandwidth *resource.Quantity `json:"targetBandwidth,omitempty"`
+		// Disk cache in use, in percent of its capacity
+		// +kubebuilder:validation:Minimum=1
+		// +kubebuilder:validation:Maximum=100
+		// +optional
+		TargetCacheFill *int32 `json:"targetCacheFill,omitempty"`
+		// Scale up and down policies, the HorizontalPodAutoscaler defaults if
+		// unset
+		// +optional
+		Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+		// Scale through a KEDA ScaledObject querying Prometheus instead, so no
+		// metrics adapter is needed. Requires KEDA.
+		// +optional
+		KEDA *KEDAScaling `json:"keda,omitempty"`
+	}
+
+	// KEDAScaling points KEDA to the Prometheus server scraping the edges
+	KEDAScaling struct {
+		// e.g. http://prometheus.monitoring:9090
+		ServerAddress string `json:"serverAddress"`
+	}
+
+	// CDNStorage defines the volumes the edges keep their disk cache on. The
+	// disk cache fills 90% of them.
+	// +kubebuilder:validation:XValidation:rule="!has(self.dataVolumeSource) || self.mode == 'Shared'",message="dataVolumeSource requires the Shared mode"
+	CDNStorage struct {
+		// PerReplica claims a volume per edge pod, run by a StatefulSet.
+		// Shared mounts a single claim into every pod of a Deployment, which
+		// needs the ReadWriteMany access mode past one replica, and splits it
+		// between MaxReplicas pods.
+		// +kubebuilder:validation:Enum=PerReplica;Shared
+		// +kubebuilder:default=PerReplica
+		// +optional
+		Mode CDNStorageMode `json:"mode,omitempty"`
+		// Storage class of the claims, the cluster default if unset
+		// +optional
+		StorageClassName *string `json:"storageClassName,omitempty"`
+		// Size of each claim
+		// +kubebuilder:default="10Gi"
+		// +optional
+		Size resource.Quantity `json:"size,omitempty"`
+		// Access mode of the claims, ReadWriteMany in the Shared mode and
+		// ReadWriteOnce in the PerReplica one if unset
+		// +kubebuilder:validation:Enum=ReadWriteOnce;ReadWriteMany;ReadWriteOncePod
+		// +optional
+		AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+		// Populates the shared claim through a KubeVirt CDI DataVolume, e.g.
+		// to start from a pre-warmed cache. Requires CDI to be installed.
+		// +optional
+		DataVolumeSource *cdiv1.DataVolumeSource `json:"dataVolumeSource,omitempty"`
+	}
+
+	// CDNStorageMode is how the edge volumes are claimed
+	CDNStorageMode string
+
+	// CDNExposure sets what routes client traffic for the domain to the
+	// edge Service <name>-service, which is a LoadBalancer only in the
+	// LoadBalancer mode.
+	// +kubebuilder:validation:XValidation:rule="self.mode != 'Gateway' || has(self.gateway)",message="gateway is required in the Gateway mode"
+	CDNExposure struct {
+		// Ingress routes through the Ingress <name>-ingress. Gateway
+		// attaches the Gateway API HTTPRoute <name>-route to a Gateway,
+		// which terminates TLS with its own listener certificates.
+		// LoadBalancer exposes the edge Service alone, the edges
+		// terminating TLS.
+		// +kubebuilder:validation:Enum=Ingress;Gateway;LoadBalancer
+		// +kubebuilder:default=Ingress
+		// +optional
+		Mode CDNExposureMode `json:"mode,omitempty"`
+		// Class of the Ingress, the cluster default if unset
+		// +optional
+		IngressClassName *string `json:"ingressClassName,omitempty"`
+		// Gateway the HTTPRoute attaches to. Requires the Gateway API CRDs.
+		// +optional
+		Gateway *GatewayReference `json:"gateway,omitempty"`
+		// Annotations of the Ingress, HTTPRoute or LoadBalancer Service, for
+		// settings specific to their controller
+		// +optional
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	// CDNExposureMode is what routes client traffic to the edges
+	CDNExposureMode string
+
+	// GatewayReference refers to a Gateway API Gateway
+	GatewayReference struct {
+		// +kubebuilder:validation:MinLength=1
+		Name string `json:"name"`
+		// Namespace of the Gateway, the one of the CDN if unset. Its
+		// listeners must allow routes from the namespace of the CDN.
+		// +optional
+		Namespace string `json:"namespace,omitempty"`
+		// Listener to attach to, every listener accepting the domain if
+		// unset
+		// +optional
+		SectionName string `json:"sectionName,omitempty"`
 	}
 
 	// CacheRule defines a specific caching rule
 	CacheRule struct {
 		PathPattern string `json:"pathPattern"`
 		TTL         int    `json:"ttl"` // in seconds
+		// Cache a separate variant per client country
+		VaryByCountry bool `json:"varyByCountry,omitempty"`
+		// Cache objects as independent slices of this many bytes, fetching
+		// only the slices a range request needs. Requires origin range
+		// support and strong ETags, 0 caches objects whole.
+		// +kubebuilder:validation:Minimum=0
+		// +kubebuilder:validation:Maximum=16777216
+		// +optional
+		SliceSize int64 `json:"sliceSize,omitempty"`
+		// Cache responses marked private or setting cookies, and answer
+		// requests carrying cookies or credentials from the cache. Only
+		// for content that is the same for every user, the cookies set
+		// are never stored.
+		// +optional
+		CachePrivate bool `json:"cachePrivate,omitempty"`
+	}
+
+	// Route customizes how the edge handles requests matching a path pattern.
+	// The first matching route applies.
+	Route struct {
+		// Path pattern, a trailing "*" matches any suffix
+		PathPattern string `json:"pathPattern"`
+		// Header changes on requests forwarded to the origin
+		RequestHeaders *HeaderRules `json:"requestHeaders,omitempty"`
+		// Header changes on responses sent to the client
+		ResponseHeaders *HeaderRules `json:"responseHeaders,omitempty"`
+		// On-the-fly image transforms
+		ImageOptimization *ImageOptimization `json:"imageOptimization,omitempty"`
+	}
+
+	// ImageOptimization enables image transforms requested through the w, h,
+	// fit (contain, cover, fill), q, fmt (jpeg, png, auto) and preset query
+	// parameters. Path driven transforms can be built with rewrite rules.
+	// Without fmt the output format follows the Accept header. JPEG, PNG, GIF
+	// and WebP sources are decoded, but only JPEG and PNG can be produced.
+	ImageOptimization struct {
+		// Largest output width in pixels, 2048 if unset
+		MaxWidth int `json:"maxWidth,omitempty"`
+		// Largest output height in pixels, 2048 if unset
+		MaxHeight int `json:"maxHeight,omitempty"`
+		// Largest source image transformed in bytes, 20MiB if unset
+		MaxSourceSize int64 `json:"maxSourceSize,omitempty"`
+		// Default JPEG quality, 80 if unset
+		// +kubebuilder:validation:Minimum=1
+		// +kubebuilder:validation:Maximum=100
+		// +optional
+		Quality int `json:"quality,omitempty"`
+		// Named transforms, selected with the preset query parameter
+		Presets []ImagePreset `json:"presets,omitempty"`
+		// Reject transforms which are not presets
+		PresetsOnly bool `json:"presetsOnly,omitempty"`
+	}
+
+	// ImagePreset is a named image transform
+	ImagePreset struct {
+		Name   string `json:"name"`
+		Width  int    `json:"width,omitempty"`
+		Height int    `json:"height,omitempty"`
+		// +kubebuilder:validation:Enum=contain;cover;fill
+		// +optional
+		Fit string `json:"fit,omitempty"`
+		// +kubebuilder:validation:Minimum=1
+		// +kubebuilder:validation:Maximum=100
+		// +optional
+		Quality int `json:"quality,omitempty"`
+		// +kubebuilder:validation:Enum=jpeg;png
+		// +optional
+		Format string `json:"format,omitempty"`
+	}
+
+	// HeaderRules add, set or remove headers, applied in that order: remove,
+	// set, add. Values may reference ${client_ip}, ${country}, ${cache_status},
+	// ${pod_name}, ${request_id}, ${host}, ${method} and ${path}.
+	HeaderRules struct {
+		// Headers replaced with the given value
+		Set []HeaderValue `json:"set,omitempty"`
+		// Headers appended to existing values
+		Add []HeaderValue `json:"add,omitempty"`
+		// Headers removed
+		Remove []string `json:"remove,omitempty"`
+	}
+
+	// HeaderValue is a header name and value template
+	HeaderValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	// Streaming makes the edge cache HLS (.m3u8, .ts, .m4s) and DASH (.mpd)
+	// content by kind: manifests of live streams briefly, segments and VOD
+	// manifests for long. Fetching a manifest from the origin prefetches the
+	// segments players are about to request.
+	Streaming struct {
+		// Cache lifetime of live manifests in seconds
+		// +kubebuilder:default=2
+		// +kubebuilder:validation:Minimum=1
+		// +optional
+		LiveManifestTTL int `json:"liveManifestTTL,omitempty"`
+		// Cache lifetime of segments and VOD manifests in seconds
+		// +kubebuilder:default=86400
+		// +kubebuilder:validation:Minimum=1
+		// +optional
+		SegmentTTL int `json:"segmentTTL,omitempty"`
+		// Number of upcoming segments prefetched per manifest, 0 disables prefetching
+		// +kubebuilder:default=3
+		// +kubebuilder:validation:Minimum=0
+		// +optional
+		PrefetchSegments int `json:"prefetchSegments"`
+	}
+
+	// RedirectRule redirects requests whose path matches a regular expression
+	RedirectRule struct {
+		// Regular expression (RE2) matched against the request path
+		Match string `json:"match"`
+		// Redirect location, may reference capture groups ($1, ${name}) and ${host}
+		Target string `json:"target"`
+		// +kubebuilder:validation:Enum=301;302;307;308
+		// +optional
+		StatusCode int `json:"statusCode,omitempty"`
+		// Only redirect requests received over this scheme
+		// +kubebuilder:validation:Enum=http;https
+		// +optional
+		Scheme string `json:"scheme,omitempty"`
+		// Drop the query string instead of appending it to the target
+		DropQuery bool `json:"dropQuery,omitempty"`
+	}
+
+	// RewriteRule maps request paths matching a regular expression to a
+	// different origin path, without the client noticing
+	RewriteRule struct {
+		// Regular expression (RE2) matched against the request path
+		Match string `json:"match"`
+		// Origin path, may reference capture groups ($1, ${name})
+		Replacement string `json:"replacement"`
+		// Origin serving the rewritten path, the CDN origin if empty
+		Origin string `json:"origin,omitempty"`
+	}
+
+	// CORSPolicy defines which cross-origin requests browsers may make. The edge
+	// answers preflight requests itself and overrides the origin's CORS headers.
+	// +kubebuilder:validation:XValidation:rule="!(has(self.allowCredentials) && self.allowCredentials) || !self.allowedOrigins.exists(o, o == '*')",message="allowCredentials cannot be combined with the * origin"
+	CORSPolicy struct {
+		// Allowed origins, "*" or patterns such as https://*.example.com. "*"
+		// cannot be combined with AllowCredentials.
+		AllowedOrigins []string `json:"allowedOrigins"`
+		// Allowed methods, GET and HEAD if empty
+		AllowedMethods []string `json:"allowedMethods,omitempty"`
+		// Allowed request headers, "*" allows any
+		AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+		// Response headers exposed to scripts
+		ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+		// Allow cookies and HTTP authentication
+		AllowCredentials bool `json:"allowCredentials,omitempty"`
+		// How long preflight results may be cached, in seconds
+		MaxAge int `json:"maxAge,omitempty"`
+	}
+
+	// GeoRestrictions defines country based access control, using ISO 3166-1
+	// alpha-2 country codes resolved from an offline GeoIP database
+	GeoRestrictions struct {
+		// Countries allowed to access the CDN, empty allows all
+		AllowCountries []string `json:"allowCountries,omitempty"`
+		// Countries denied access to the CDN
+		DenyCountries []string `json:"denyCountries,omitempty"`
+		// Where denied clients are redirected, they get a 403 if empty
+		RedirectURL string `json:"redirectURL,omitempty"`
+		// Region specific origins, overriding Origin for matching countries
+		Origins []GeoOrigin `json:"origins,omitempty"`
+		// MaxMind (MMDB) database files
+		Database GeoIPDatabase `json:"database"`
+	}
+
+	// GeoOrigin routes clients from the given countries to a dedicated origin
+	GeoOrigin struct {
+		Countries []string `json:"countries"`
+		Origin    string   `json:"origin"`
+	}
+
+	// GeoIPDatabase locates the MMDB files, mounted from a ConfigMap or a PVC
+	GeoIPDatabase struct {
+		ConfigMapName string `json:"configMapName,omitempty"`
+		ClaimName     string `json:"claimName,omitempty"`
+		// Country database file name, e.g. GeoLite2-Country.mmdb
+		CountryFile string `json:"countryFile,omitempty"`
+		// ASN database file name, e.g. GeoLite2-ASN.mmdb
+		ASNFile string `json:"asnFile,omitempty"`
 	}
 
-	// SSLConfig defines the SSL/TLS configuration for the CDN
+	// SSLConfig defines the SSL/TLS configuration for the CDN. The certificate
+	// for the domain is read from a kubernetes.io/tls Secret, provided or
+	// issued by cert-manager.
+	// +kubebuilder:validation:XValidation:rule="!(has(self.cert) || has(self.key)) || !(has(self.secretName) || has(self.certManager) || has(self.acme))",message="cert and key are exclusive with secretName, certManager and acme"
+	// +kubebuilder:validation:XValidation:rule="!(has(self.certManager) && has(self.acme))",message="certManager and acme are exclusive"
 	SSLConfig struct {
-		Enabled bool   `json:"enabled"`
-		Cert    string `json:"cert,omitempty"`
-		Key     string `json:"key,omitempty"`
+		Enabled bool `json:"enabled"`
+		// Secret holding the certificate, in the namespace of the CDN. With
+		// certManager, the Secret cert-manager stores it in, <name>-tls by
+		// default. The edges terminating TLS staple the DER OCSP response in
+		// its tls.ocsp key, if any.
+		// +optional
+		SecretName string `json:"secretName,omitempty"`
+		// Further TLS Secrets the edges serve by SNI, for names the
+		// certificate doesn't cover
+		// +optional
+		AdditionalSecretNames []string `json:"additionalSecretNames,omitempty"`
+		// Lowest TLS version the edges accept
+		// +kubebuilder:validation:Enum="1.2";"1.3"
+		// +kubebuilder:default="1.2"
+		// +optional
+		MinVersion string `json:"minVersion,omitempty"`
+		// TLS 1.2 cipher suites the edges offer, by their Go name such as
+		// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's defaults when empty, TLS
+		// 1.3 suites are not configurable.
+		// +optional
+		CipherSuites []string `json:"cipherSuites,omitempty"`
+		// Request the certificate from cert-manager. Requires cert-manager.
+		// +optional
+		CertManager *CertManagerConfig `json:"certManager,omitempty"`
+		// Order the certificate from an ACME CA, the edges answering its
+		// HTTP-01 challenges, so no ingress controller solver is needed
+		// +optional
+		ACME *ACMEConfig `json:"acme,omitempty"`
+		// Deprecated: anyone reading the CDN reads the key, use secretName.
+		// Inline PEM certificate and key, base64 encoded or not, which the
+		// controller copies into the Secret <name>-tls.
+		// +optional
+		Cert string `json:"cert,omitempty"`
+		// +optional
+		Key string `json:"key,omitempty"`
+	}
+
+	// CertManagerConfig sets how cert-manager issues the certificate of the CDN
+	CertManagerConfig struct {
+		// Issuer or ClusterIssuer to request the certificate from
+		IssuerRef CertManagerIssuerRef `json:"issuerRef"`
+		// Names the certificate covers besides the domain name
+		// +optional
+		DNSNames []string `json:"dnsNames,omitempty"`
+	}
+
+	// ACMEConfig sets the ACME CA the controller orders the certificate of
+	// the CDN from. The certificate is stored in the Secret named by
+	// secretName, <name>-tls by default, and renewed 30 days before it
+	// expires.
+	ACMEConfig struct {
+		// Directory URL of the CA
+		// +kubebuilder:default="https://acme-v02.api.letsencrypt.org/directory"
+		// +optional
+		Server string `json:"server,omitempty"`
+		// Contact address of the account, whose key is kept in the Secret
+		// <name>-acme-account
+		// +optional
+		Email string `json:"email,omitempty"`
+		// Names the certificate covers besides the domain name
+		// +optional
+		DNSNames []string `json:"dnsNames,omitempty"`
+		// PEM CA certificates the directory is served with, when not
+		// publicly trusted, as for a Pebble test server
+		// +optional
+		CABundle []byte `json:"caBundle,omitempty"`
+	}
+
+	// CertManagerIssuerRef refers to a cert-manager issuer
+	CertManagerIssuerRef struct {
+		// +kubebuilder:validation:MinLength=1
+		Name string `json:"name"`
+		// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+		// +kubebuilder:default=Issuer
+		// +optional
+		Kind string `json:"kind,omitempty"`
+		// +kubebuilder:default=cert-manager.io
+		// +optional
+		Group string `json:"group,omitempty"`
 	}
 )
 
+const (
+	CDNStorageModePerReplica CDNStorageMode = "PerReplica"
+	CDNStorageModeShared     CDNStorageMode = "Shared"
+
+	CDNExposureIngress      CDNExposureMode = "Ingress"
+	CDNExposureGateway      CDNExposureMode = "Gateway"
+	CDNExposureLoadBalancer CDNExposureMode = "LoadBalancer"
+)
+
 // ContentDeliveryNetworkStatus defines the observed state of ContentDeliveryNetwork
 type (
 	ContentDeliveryNetworkStatus struct {
 		// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 		// Important: Run "make" to regenerate code after modifying this file
 
-		// CDN distribution status
+		// CDN distribution status: Pending, Ready or Degraded
 		State string `json:"state"`
-		// List of IP addresses of the CDN nodes
+		// Generation of the spec the status was computed for
+		ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+		// Ready, OriginReachable, CertificateReady, StorageBound, DNSPublished
+		// and Degraded
+		// +listType=map
+		// +listMapKey=type
+		// +optional
+		Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+		// IP addresses of the edge pods serving the CDN
 		Nodes []string `json:"nodes,omitempty"`
 		// Last updated time
 		LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
-		// Metrics for monitoring
+		// Metrics of the edges since the previous reconcile
 		Metrics CDNMetrics `json:"metrics,omitempty"`
+		// Certificate served for the domain, when TLS is enabled
+		// +optional
+		Certificate *CDNCertificateStatus `json:"certificate,omitempty"`
+	}
+
+	// CDNCertificateStatus describes the certificate served for the domain
+	CDNCertificateStatus struct {
+		// Secret the certificate is read from
+		SecretName string `json:"secretName"`
+		// Names the certificate covers
+		DNSNames []string `json:"dnsNames,omitempty"`
+		// Time the certificate expires
+		NotAfter metav1.Time `json:"notAfter"`
 	}
 
-	// CDNMetrics contains monitoring metrics for the CDN
+	// CDNMetrics aggregates the metrics scraped from the edge pods of the CDN.
+	// Rates, ratios and latencies cover the time since the previous scrape,
+	// or since an edge started when it wasn't scraped before.
 	CDNMetrics struct {
-		RequestsPerSecond string `json:"requestsPerSecond"`
-		CacheHitRate      string `json:"cacheHitRate"`
-		AverageLatency    string `json:"averageLatency"` // in milliseconds
+		// Edge pods the metrics were scraped from
+		Edges int32 `json:"edges"`
+		// Requests served per second
+		RequestRate resource.Quantity `json:"requestRate"`
+		// Response bytes sent per second
+		EgressRate resource.Quantity `json:"egressRate"`
+		// Share of the requests going through the cache which were served from
+		// it, from 0 to 1
+		CacheHitRatio resource.Quantity `json:"cacheHitRatio"`
+		// Share of the bytes going through the cache which were served from
+		// it, from 0 to 1
+		CacheByteHitRatio resource.Quantity `json:"cacheByteHitRatio"`
+		// Response latency percentiles, estimated from the latency buckets of
+		// the edges
+		LatencyP50 metav1.Duration `json:"latencyP50"`
+		LatencyP95 metav1.Duration `json:"latencyP95"`
+		LatencyP99 metav1.Duration `json:"latencyP99"`
+		// Share of the origin fetches which failed or got a server error, from
+		// 0 to 1
+		OriginErrorRatio resource.Quantity `json:"originErrorRatio"`
+		// Bytes of the disk caches in use, and their capacity
+		CacheUsed     resource.Quantity `json:"cacheUsed"`
+		CacheCapacity resource.Quantity `json:"cacheCapacity"`
 	}
 )
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=cdn
+//+kubebuilder:printcolumn:name="Domain",type=string,JSONPath=`.spec.domainName`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Cert Expiry",type=date,JSONPath=`.status.certificate.notAfter`,priority=1
+//+kubebuilder:printcolumn:name="RPS",type=string,JSONPath=`.status.metrics.requestRate`,priority=1
+//+kubebuilder:printcolumn:name="Hit Ratio",type=string,JSONPath=`.status.metrics.cacheHitRatio`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ContentDeliveryNetwork is the Schema for the contentdeliverynetworks API
 type ContentDeliveryNetwork struct {
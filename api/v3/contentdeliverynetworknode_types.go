@@ -28,7 +28,12 @@ type ContentDeliveryNetworkNodeSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Size for caching
+	// ContentDeliveryNetwork the node serves, in the same namespace. Set by
+	// the CDN controller for the nodes listed in its spec.
+	// +optional
+	CDNRef string `json:"cdnRef,omitempty"`
+	// Size of the disk cache volume in GiB
+	// +kubebuilder:validation:Minimum=1
 	CacheSize int `json:"cacheSize"`
 }
 
@@ -37,6 +42,8 @@ type ContentDeliveryNetworkNodeStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// Whether the pod of the node is ready and not failing the edge health
+	// checks
 	Available bool `json:"available"`
 }
 
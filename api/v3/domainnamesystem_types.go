@@ -17,6 +17,7 @@ limitations under the License.
 package v3
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,9 +29,150 @@ type DomainNameSystemSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// Where the zones are published. Server runs the authoritative DNS
+	// server, DNSEndpoint emits ExternalDNS DNSEndpoint objects, Annotations
+	// sets ExternalDNS hostname and TTL annotations on the edge Service and
+	// Ingress, and ZoneFile renders RFC 1035 zone files into a ConfigMap.
+	// +kubebuilder:default={Server}
+	// +optional
+	Modes []DNSMode `json:"modes,omitempty"`
+
 	// Replicas
 	MinReplicas int `json:"minReplicas"`
 	MaxReplicas int `json:"maxReplicas"`
+
+	// Zones served authoritatively. Every ContentDeliveryNetwork in the same
+	// namespace whose domain name falls inside a zone gets address records.
+	Zones []string `json:"zones,omitempty"`
+	// Nameserver names published for the zones, the first is the SOA primary.
+	// Names inside a zone are answered with the DNS Service addresses.
+	Nameservers []string `json:"nameservers,omitempty"`
+	// Responsible mailbox published in the SOA records, such as hostmaster.example.com
+	Hostmaster string `json:"hostmaster,omitempty"`
+	// TTL of answers in seconds
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=30
+	// +optional
+	TTL int `json:"ttl,omitempty"`
+	// Type of the Service exposing the DNS server
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=LoadBalancer
+	// +optional
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// Regions edge pods are grouped in. Clients get the edges of their region,
+	// picked by EDNS Client Subnet or resolver address, and those of the
+	// fallback regions when it has no healthy edge. Edge pods belong to the
+	// region in their cdn.benauro.gg/region label, or else in the
+	// topology.kubernetes.io/region label of their node.
+	Regions []DNSRegion `json:"regions,omitempty"`
+	// GeoIP country database placing clients in regions by country
+	GeoIPDatabase *GeoIPDatabase `json:"geoIPDatabase,omitempty"`
+	// Active health checks deciding which edge pods are answered with
+	// +optional
+	HealthCheck DNSHealthCheck `json:"healthCheck,omitempty"`
+	// Weighted record sets, shifting traffic gradually between edge pools.
+	// Each answer comes from one pool picked with a probability proportional
+	// to its weight. A hostname matching the domain of a CDN replaces its
+	// records.
+	WeightedRecords []DNSWeightedRecordSet `json:"weightedRecords,omitempty"`
+	// DNSSEC signing of the zones served by the DNS server, with keys kept in
+	// the <name>-dnssec Secret
+	DNSSEC *DNSSEC `json:"dnssec,omitempty"`
+}
+
+// DNSSEC signs the zones with ECDSA P-256 keys. The key signing key is kept
+// for good, its DS is to be registered with the parent zone, while the zone
+// signing key is rolled over by pre-publication.
+type DNSSEC struct {
+	// Denial of existence records, NSEC3 hashes the names denied
+	// +kubebuilder:validation:Enum=NSEC;NSEC3
+	// +kubebuilder:default=NSEC3
+	// +optional
+	Denial string `json:"denial,omitempty"`
+	// Days between zone signing key rollovers
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=30
+	// +optional
+	ZSKRolloverDays int `json:"zskRolloverDays,omitempty"`
+}
+
+// DNSWeightedRecordSet splits the answers for a hostname between pools
+type DNSWeightedRecordSet struct {
+	// Host name inside one of the zones
+	Hostname string `json:"hostname"`
+	// +kubebuilder:validation:MinItems=1
+	Pools []DNSPool `json:"pools"`
+}
+
+// DNSPool is a set of edges answered together, from a CDN of the namespace
+// or from static addresses such as the load balancer of another cluster
+type DNSPool struct {
+	Name string `json:"name"`
+	// Relative weight, 0 drains the pool
+	// +kubebuilder:validation:Minimum=0
+	Weight int `json:"weight"`
+	// ContentDeliveryNetwork whose healthy edge pods are in the pool
+	ContentDeliveryNetwork string `json:"contentDeliveryNetwork,omitempty"`
+	// Addresses in the pool
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// DNSMode is a way of publishing the zones
+// +kubebuilder:validation:Enum=Server;DNSEndpoint;Annotations;ZoneFile
+type DNSMode string
+
+const (
+	DNSModeServer      DNSMode = "Server"
+	DNSModeDNSEndpoint DNSMode = "DNSEndpoint"
+	DNSModeAnnotations DNSMode = "Annotations"
+	DNSModeZoneFile    DNSMode = "ZoneFile"
+)
+
+// DNSHealthCheck probes the edge pods over HTTP. Pods failing the check are
+// ejected from the answers, a short TTL makes clients notice sooner.
+type DNSHealthCheck struct {
+	// Path requested on each edge pod
+	// +kubebuilder:default="/healthz"
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Seconds between checks
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=10
+	// +optional
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// Seconds after which a check fails
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=2
+	// +optional
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Consecutive successes bringing an ejected pod back
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=2
+	// +optional
+	HealthyThreshold int `json:"healthyThreshold,omitempty"`
+	// Consecutive failures ejecting a pod
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	// +optional
+	UnhealthyThreshold int `json:"unhealthyThreshold,omitempty"`
+	// Seconds an ejected pod stays out at least, damping flapping pods
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=60
+	// +optional
+	HoldDownSeconds int `json:"holdDownSeconds"`
+}
+
+// DNSRegion maps clients to the edge pods of a region
+type DNSRegion struct {
+	// Region name, as found in the edge pod or node labels
+	Name string `json:"name"`
+	// Client subnets in the region, the most specific match wins
+	CIDRs []string `json:"cidrs,omitempty"`
+	// ISO 3166-1 alpha-2 codes of the client countries in the region, used
+	// when no subnet matches
+	Countries []string `json:"countries,omitempty"`
+	// Regions answered from, in order, when this one has no healthy edge
+	Fallback []string `json:"fallback,omitempty"`
 }
 
 // DomainNameSystemStatus defines the observed state of DomainNameSystem
@@ -40,6 +182,39 @@ type DomainNameSystemStatus struct {
 
 	// DNS status
 	State string `json:"state"`
+	// Addresses the DNS server is reachable at, to delegate the zones to
+	Addresses []string `json:"addresses,omitempty"`
+	// Weighted record sets as served, with the answers observed per pool
+	WeightedRecords []DNSWeightedRecordStatus `json:"weightedRecords,omitempty"`
+	// DNSSEC keys of the signed zones
+	DNSSEC []DNSSECZoneStatus `json:"dnssec,omitempty"`
+}
+
+// DNSSECZoneStatus is the DNSSEC state of a zone
+type DNSSECZoneStatus struct {
+	Zone string `json:"zone"`
+	// DS record of the key signing key, to register with the parent zone
+	DS        string `json:"ds"`
+	KSKKeyTag int    `json:"kskKeyTag"`
+	ZSKKeyTag int    `json:"zskKeyTag"`
+	// When the zone signing key in use was activated
+	ZSKActivated metav1.Time `json:"zskActivated,omitempty"`
+}
+
+// DNSWeightedRecordStatus is the observed state of a weighted record set
+type DNSWeightedRecordStatus struct {
+	Hostname string          `json:"hostname"`
+	Pools    []DNSPoolStatus `json:"pools"`
+}
+
+// DNSPoolStatus is the observed state of a pool
+type DNSPoolStatus struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	// Addresses currently answered from the pool
+	Endpoints int `json:"endpoints"`
+	// Answers given from the pool since the DNS server pods started
+	Answers int64 `json:"answers"`
 }
 
 //+kubebuilder:object:root=true
@@ -21,12 +21,149 @@ limitations under the License.
 package v3
 
 import (
+	"k8s.io/api/autoscaling/v2"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ACMEConfig) DeepCopyInto(out *ACMEConfig) {
+	*out = *in
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ACMEConfig.
+func (in *ACMEConfig) DeepCopy() *ACMEConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ACMEConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CDNAutoscaling) DeepCopyInto(out *CDNAutoscaling) {
+	*out = *in
+	if in.TargetCPUUtilization != nil {
+		in, out := &in.TargetCPUUtilization, &out.TargetCPUUtilization
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetRequestsPerSecond != nil {
+		in, out := &in.TargetRequestsPerSecond, &out.TargetRequestsPerSecond
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.TargetBandwidth != nil {
+		in, out := &in.TargetBandwidth, &out.TargetBandwidth
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.TargetCacheFill != nil {
+		in, out := &in.TargetCacheFill, &out.TargetCacheFill
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Behavior != nil {
+		in, out := &in.Behavior, &out.Behavior
+		*out = new(v2.HorizontalPodAutoscalerBehavior)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KEDA != nil {
+		in, out := &in.KEDA, &out.KEDA
+		*out = new(KEDAScaling)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CDNAutoscaling.
+func (in *CDNAutoscaling) DeepCopy() *CDNAutoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(CDNAutoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CDNCertificateStatus) DeepCopyInto(out *CDNCertificateStatus) {
+	*out = *in
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CDNCertificateStatus.
+func (in *CDNCertificateStatus) DeepCopy() *CDNCertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CDNCertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CDNExposure) DeepCopyInto(out *CDNExposure) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Gateway != nil {
+		in, out := &in.Gateway, &out.Gateway
+		*out = new(GatewayReference)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CDNExposure.
+func (in *CDNExposure) DeepCopy() *CDNExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(CDNExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CDNMetrics) DeepCopyInto(out *CDNMetrics) {
 	*out = *in
+	out.RequestRate = in.RequestRate.DeepCopy()
+	out.EgressRate = in.EgressRate.DeepCopy()
+	out.CacheHitRatio = in.CacheHitRatio.DeepCopy()
+	out.CacheByteHitRatio = in.CacheByteHitRatio.DeepCopy()
+	out.LatencyP50 = in.LatencyP50
+	out.LatencyP95 = in.LatencyP95
+	out.LatencyP99 = in.LatencyP99
+	out.OriginErrorRatio = in.OriginErrorRatio.DeepCopy()
+	out.CacheUsed = in.CacheUsed.DeepCopy()
+	out.CacheCapacity = in.CacheCapacity.DeepCopy()
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CDNMetrics.
@@ -39,6 +176,67 @@ func (in *CDNMetrics) DeepCopy() *CDNMetrics {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CDNStorage) DeepCopyInto(out *CDNStorage) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	out.Size = in.Size.DeepCopy()
+	if in.DataVolumeSource != nil {
+		in, out := &in.DataVolumeSource, &out.DataVolumeSource
+		*out = new(v1beta1.DataVolumeSource)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CDNStorage.
+func (in *CDNStorage) DeepCopy() *CDNStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(CDNStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CORSPolicy) DeepCopyInto(out *CORSPolicy) {
+	*out = *in
+	if in.AllowedOrigins != nil {
+		in, out := &in.AllowedOrigins, &out.AllowedOrigins
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedMethods != nil {
+		in, out := &in.AllowedMethods, &out.AllowedMethods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedHeaders != nil {
+		in, out := &in.AllowedHeaders, &out.AllowedHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExposedHeaders != nil {
+		in, out := &in.ExposedHeaders, &out.ExposedHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CORSPolicy.
+func (in *CORSPolicy) DeepCopy() *CORSPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CORSPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CacheRule) DeepCopyInto(out *CacheRule) {
 	*out = *in
@@ -54,6 +252,149 @@ func (in *CacheRule) DeepCopy() *CacheRule {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheWarmup) DeepCopyInto(out *CacheWarmup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheWarmup.
+func (in *CacheWarmup) DeepCopy() *CacheWarmup {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheWarmup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *CacheWarmup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheWarmupList) DeepCopyInto(out *CacheWarmupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]CacheWarmup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheWarmupList.
+func (in *CacheWarmupList) DeepCopy() *CacheWarmupList {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheWarmupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *CacheWarmupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheWarmupSpec) DeepCopyInto(out *CacheWarmupSpec) {
+	*out = *in
+	if in.URLs != nil {
+		in, out := &in.URLs, &out.URLs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheWarmupSpec.
+func (in *CacheWarmupSpec) DeepCopy() *CacheWarmupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheWarmupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheWarmupStatus) DeepCopyInto(out *CacheWarmupStatus) {
+	*out = *in
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]WarmupFailure, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheWarmupStatus.
+func (in *CacheWarmupStatus) DeepCopy() *CacheWarmupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheWarmupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerConfig) DeepCopyInto(out *CertManagerConfig) {
+	*out = *in
+	out.IssuerRef = in.IssuerRef
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerConfig.
+func (in *CertManagerConfig) DeepCopy() *CertManagerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerIssuerRef) DeepCopyInto(out *CertManagerIssuerRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerIssuerRef.
+func (in *CertManagerIssuerRef) DeepCopy() *CertManagerIssuerRef {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerIssuerRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContentDeliveryNetwork) DeepCopyInto(out *ContentDeliveryNetwork) {
 	*out = *in
@@ -221,8 +562,65 @@ func (in *ContentDeliveryNetworkSpec) DeepCopyInto(out *ContentDeliveryNetworkSp
 	if in.SSLConfig != nil {
 		in, out := &in.SSLConfig, &out.SSLConfig
 		*out = new(SSLConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]Route, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Redirects != nil {
+		in, out := &in.Redirects, &out.Redirects
+		*out = make([]RedirectRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rewrites != nil {
+		in, out := &in.Rewrites, &out.Rewrites
+		*out = make([]RewriteRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.Streaming != nil {
+		in, out := &in.Streaming, &out.Streaming
+		*out = new(Streaming)
 		**out = **in
 	}
+	if in.CORS != nil {
+		in, out := &in.CORS, &out.CORS
+		*out = new(CORSPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GeoRestrictions != nil {
+		in, out := &in.GeoRestrictions, &out.GeoRestrictions
+		*out = new(GeoRestrictions)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(CDNStorage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Exposure != nil {
+		in, out := &in.Exposure, &out.Exposure
+		*out = new(CDNExposure)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TrustedProxies != nil {
+		in, out := &in.TrustedProxies, &out.TrustedProxies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(CDNAutoscaling)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContentDeliveryNetworkSpec.
@@ -238,13 +636,25 @@ func (in *ContentDeliveryNetworkSpec) DeepCopy() *ContentDeliveryNetworkSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContentDeliveryNetworkStatus) DeepCopyInto(out *ContentDeliveryNetworkStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Nodes != nil {
 		in, out := &in.Nodes, &out.Nodes
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
 	in.LastUpdated.DeepCopyInto(&out.LastUpdated)
-	out.Metrics = in.Metrics
+	in.Metrics.DeepCopyInto(&out.Metrics)
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CDNCertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContentDeliveryNetworkStatus.
@@ -258,43 +668,196 @@ func (in *ContentDeliveryNetworkStatus) DeepCopy() *ContentDeliveryNetworkStatus
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *DomainNameSystem) DeepCopyInto(out *DomainNameSystem) {
+func (in *DNSHealthCheck) DeepCopyInto(out *DNSHealthCheck) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DomainNameSystem.
-func (in *DomainNameSystem) DeepCopy() *DomainNameSystem {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSHealthCheck.
+func (in *DNSHealthCheck) DeepCopy() *DNSHealthCheck {
 	if in == nil {
 		return nil
 	}
-	out := new(DomainNameSystem)
+	out := new(DNSHealthCheck)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *DomainNameSystem) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *DomainNameSystemList) DeepCopyInto(out *DomainNameSystemList) {
+func (in *DNSPool) DeepCopyInto(out *DNSPool) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]DomainNameSystem, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSPool.
+func (in *DNSPool) DeepCopy() *DNSPool {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSPoolStatus) DeepCopyInto(out *DNSPoolStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSPoolStatus.
+func (in *DNSPoolStatus) DeepCopy() *DNSPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSRegion) DeepCopyInto(out *DNSRegion) {
+	*out = *in
+	if in.CIDRs != nil {
+		in, out := &in.CIDRs, &out.CIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Countries != nil {
+		in, out := &in.Countries, &out.Countries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSRegion.
+func (in *DNSRegion) DeepCopy() *DNSRegion {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSRegion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSSEC) DeepCopyInto(out *DNSSEC) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSSEC.
+func (in *DNSSEC) DeepCopy() *DNSSEC {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSSEC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSSECZoneStatus) DeepCopyInto(out *DNSSECZoneStatus) {
+	*out = *in
+	in.ZSKActivated.DeepCopyInto(&out.ZSKActivated)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSSECZoneStatus.
+func (in *DNSSECZoneStatus) DeepCopy() *DNSSECZoneStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSSECZoneStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSWeightedRecordSet) DeepCopyInto(out *DNSWeightedRecordSet) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]DNSPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSWeightedRecordSet.
+func (in *DNSWeightedRecordSet) DeepCopy() *DNSWeightedRecordSet {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSWeightedRecordSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSWeightedRecordStatus) DeepCopyInto(out *DNSWeightedRecordStatus) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]DNSPoolStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSWeightedRecordStatus.
+func (in *DNSWeightedRecordStatus) DeepCopy() *DNSWeightedRecordStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSWeightedRecordStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DomainNameSystem) DeepCopyInto(out *DomainNameSystem) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DomainNameSystem.
+func (in *DomainNameSystem) DeepCopy() *DomainNameSystem {
+	if in == nil {
+		return nil
+	}
+	out := new(DomainNameSystem)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DomainNameSystem) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DomainNameSystemList) DeepCopyInto(out *DomainNameSystemList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DomainNameSystem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -319,6 +882,46 @@ func (in *DomainNameSystemList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DomainNameSystemSpec) DeepCopyInto(out *DomainNameSystemSpec) {
 	*out = *in
+	if in.Modes != nil {
+		in, out := &in.Modes, &out.Modes
+		*out = make([]DNSMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Nameservers != nil {
+		in, out := &in.Nameservers, &out.Nameservers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Regions != nil {
+		in, out := &in.Regions, &out.Regions
+		*out = make([]DNSRegion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.GeoIPDatabase != nil {
+		in, out := &in.GeoIPDatabase, &out.GeoIPDatabase
+		*out = new(GeoIPDatabase)
+		**out = **in
+	}
+	out.HealthCheck = in.HealthCheck
+	if in.WeightedRecords != nil {
+		in, out := &in.WeightedRecords, &out.WeightedRecords
+		*out = make([]DNSWeightedRecordSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DNSSEC != nil {
+		in, out := &in.DNSSEC, &out.DNSSEC
+		*out = new(DNSSEC)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DomainNameSystemSpec.
@@ -334,6 +937,25 @@ func (in *DomainNameSystemSpec) DeepCopy() *DomainNameSystemSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DomainNameSystemStatus) DeepCopyInto(out *DomainNameSystemStatus) {
 	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.WeightedRecords != nil {
+		in, out := &in.WeightedRecords, &out.WeightedRecords
+		*out = make([]DNSWeightedRecordStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DNSSEC != nil {
+		in, out := &in.DNSSEC, &out.DNSSEC
+		*out = make([]DNSSECZoneStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DomainNameSystemStatus.
@@ -346,9 +968,267 @@ func (in *DomainNameSystemStatus) DeepCopy() *DomainNameSystemStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayReference) DeepCopyInto(out *GatewayReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayReference.
+func (in *GatewayReference) DeepCopy() *GatewayReference {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeoIPDatabase) DeepCopyInto(out *GeoIPDatabase) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeoIPDatabase.
+func (in *GeoIPDatabase) DeepCopy() *GeoIPDatabase {
+	if in == nil {
+		return nil
+	}
+	out := new(GeoIPDatabase)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeoOrigin) DeepCopyInto(out *GeoOrigin) {
+	*out = *in
+	if in.Countries != nil {
+		in, out := &in.Countries, &out.Countries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeoOrigin.
+func (in *GeoOrigin) DeepCopy() *GeoOrigin {
+	if in == nil {
+		return nil
+	}
+	out := new(GeoOrigin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeoRestrictions) DeepCopyInto(out *GeoRestrictions) {
+	*out = *in
+	if in.AllowCountries != nil {
+		in, out := &in.AllowCountries, &out.AllowCountries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DenyCountries != nil {
+		in, out := &in.DenyCountries, &out.DenyCountries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Origins != nil {
+		in, out := &in.Origins, &out.Origins
+		*out = make([]GeoOrigin, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.Database = in.Database
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeoRestrictions.
+func (in *GeoRestrictions) DeepCopy() *GeoRestrictions {
+	if in == nil {
+		return nil
+	}
+	out := new(GeoRestrictions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HeaderRules) DeepCopyInto(out *HeaderRules) {
+	*out = *in
+	if in.Set != nil {
+		in, out := &in.Set, &out.Set
+		*out = make([]HeaderValue, len(*in))
+		copy(*out, *in)
+	}
+	if in.Add != nil {
+		in, out := &in.Add, &out.Add
+		*out = make([]HeaderValue, len(*in))
+		copy(*out, *in)
+	}
+	if in.Remove != nil {
+		in, out := &in.Remove, &out.Remove
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HeaderRules.
+func (in *HeaderRules) DeepCopy() *HeaderRules {
+	if in == nil {
+		return nil
+	}
+	out := new(HeaderRules)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HeaderValue) DeepCopyInto(out *HeaderValue) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HeaderValue.
+func (in *HeaderValue) DeepCopy() *HeaderValue {
+	if in == nil {
+		return nil
+	}
+	out := new(HeaderValue)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageOptimization) DeepCopyInto(out *ImageOptimization) {
+	*out = *in
+	if in.Presets != nil {
+		in, out := &in.Presets, &out.Presets
+		*out = make([]ImagePreset, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageOptimization.
+func (in *ImageOptimization) DeepCopy() *ImageOptimization {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageOptimization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePreset) DeepCopyInto(out *ImagePreset) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePreset.
+func (in *ImagePreset) DeepCopy() *ImagePreset {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KEDAScaling) DeepCopyInto(out *KEDAScaling) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KEDAScaling.
+func (in *KEDAScaling) DeepCopy() *KEDAScaling {
+	if in == nil {
+		return nil
+	}
+	out := new(KEDAScaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedirectRule) DeepCopyInto(out *RedirectRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedirectRule.
+func (in *RedirectRule) DeepCopy() *RedirectRule {
+	if in == nil {
+		return nil
+	}
+	out := new(RedirectRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RewriteRule) DeepCopyInto(out *RewriteRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RewriteRule.
+func (in *RewriteRule) DeepCopy() *RewriteRule {
+	if in == nil {
+		return nil
+	}
+	out := new(RewriteRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Route) DeepCopyInto(out *Route) {
+	*out = *in
+	if in.RequestHeaders != nil {
+		in, out := &in.RequestHeaders, &out.RequestHeaders
+		*out = new(HeaderRules)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResponseHeaders != nil {
+		in, out := &in.ResponseHeaders, &out.ResponseHeaders
+		*out = new(HeaderRules)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageOptimization != nil {
+		in, out := &in.ImageOptimization, &out.ImageOptimization
+		*out = new(ImageOptimization)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Route.
+func (in *Route) DeepCopy() *Route {
+	if in == nil {
+		return nil
+	}
+	out := new(Route)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SSLConfig) DeepCopyInto(out *SSLConfig) {
 	*out = *in
+	if in.AdditionalSecretNames != nil {
+		in, out := &in.AdditionalSecretNames, &out.AdditionalSecretNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CipherSuites != nil {
+		in, out := &in.CipherSuites, &out.CipherSuites
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ACME != nil {
+		in, out := &in.ACME, &out.ACME
+		*out = new(ACMEConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSLConfig.
@@ -360,3 +1240,33 @@ func (in *SSLConfig) DeepCopy() *SSLConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Streaming) DeepCopyInto(out *Streaming) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Streaming.
+func (in *Streaming) DeepCopy() *Streaming {
+	if in == nil {
+		return nil
+	}
+	out := new(Streaming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WarmupFailure) DeepCopyInto(out *WarmupFailure) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WarmupFailure.
+func (in *WarmupFailure) DeepCopy() *WarmupFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(WarmupFailure)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a cached origin response. An entry with Vary set only records the
+// request headers the response under its key varies on, the response itself
+// is stored under a variant key.
+type Entry struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Expires time.Time
+	Vary    []string
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+var (
+	mu        sync.Mutex
+	lru       = list.New()
+	index     = map[string]*list.Element{}
+	size      int64
+	capacity  = envBytes("CDN_CACHE_MEMORY_BYTES", 256<<20)
+	evictions int64
+)
+
+// TierStats describes the contents of a cache tier.
+type TierStats struct {
+	Objects   int64
+	Bytes     int64
+	Capacity  int64
+	Evictions int64
+}
+
+// MemoryStats returns the contents of the memory tier.
+func MemoryStats() TierStats {
+	mu.Lock()
+	defer mu.Unlock()
+	return TierStats{Objects: int64(lru.Len()), Bytes: size, Capacity: capacity, Evictions: evictions}
+}
+
+// MaxObjectSize is the largest body kept in memory, bigger objects are
+// passed through uncached.
+func MaxObjectSize() int64 {
+	return capacity / 8
+}
+
+// Key builds a cache key from the request host and URI plus any variants
+// (such as the client country) the cached response depends on.
+func Key(host, requestURI string, variants ...string) string {
+	return strings.Join(append([]string{host, requestURI}, variants...), "|")
+}
+
+// Lookup returns the entry cached under base for a request with headers h,
+// following the Vary fields of the cached response.
+func Lookup(base string, h http.Header) (*Entry, bool) {
+	entry, ok := Get(base)
+	if !ok || entry.Vary == nil {
+		return entry, ok
+	}
+	return Get(variantKey(base, entry.Vary, h))
+}
+
+// Store caches entry under base for a request with headers h, honoring the
+// Vary header of the response. Responses varying on "*" are not cached.
+func Store(base string, h http.Header, entry *Entry) {
+	fields := varyFields(entry.Header)
+	if len(fields) == 0 {
+		Set(base, entry)
+		return
+	}
+	for _, f := range fields {
+		if f == "*" {
+			return
+		}
+	}
+
+	Set(base, &Entry{Vary: fields, Expires: entry.Expires})
+	Set(variantKey(base, fields, h), entry)
+}
+
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, http.CanonicalHeaderKey(f))
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func variantKey(base string, fields []string, h http.Header) string {
+	variants := make([]string, 0, len(fields))
+	for _, f := range fields {
+		variants = append(variants, f+"="+strings.Join(h.Values(f), ","))
+	}
+	return Key(base, "vary", variants...)
+}
+
+// Get returns the live entry stored under key.
+func Get(key string) (*Entry, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	el, ok := index[key]
+	if !ok {
+		return nil, false
+	}
+	it := el.Value.(*item)
+	if time.Now().After(it.entry.Expires) {
+		remove(el)
+		return nil, false
+	}
+	lru.MoveToFront(el)
+	return it.entry, true
+}
+
+// Set stores entry under key, evicting the least recently used entries to
+// stay within capacity.
+func Set(key string, entry *Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if el, ok := index[key]; ok {
+		remove(el)
+	}
+	if int64(len(entry.Body)) > MaxObjectSize() {
+		return
+	}
+
+	index[key] = lru.PushFront(&item{key: key, entry: entry})
+	size += int64(len(entry.Body))
+	for size > capacity {
+		remove(lru.Back())
+		evictions++
+	}
+}
+
+// Delete drops the entry stored under key.
+func Delete(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if el, ok := index[key]; ok {
+		remove(el)
+	}
+}
+
+func remove(el *list.Element) {
+	it := lru.Remove(el).(*item)
+	delete(index, it.key)
+	size -= int64(len(it.entry.Body))
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The disk tier keeps object slices as one file each, named after the hash
+// of their key, and evicts them least recently used first.
+var (
+	diskMu       sync.Mutex
+	diskLRU      = list.New()
+	diskIndex    = map[string]*list.Element{}
+	diskSize     int64
+	diskDir      = env("CDN_CACHE_DIR", "/data/cache")
+	diskCapacity = envBytes("CDN_CACHE_DISK_BYTES", 10<<30)
+	diskInit     sync.Once
+	diskEvicted  int64
+)
+
+type diskItem struct {
+	name string
+	size int64
+}
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envBytes(key string, fallback int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// loadDisk indexes the slices left by a previous run, oldest first.
+func loadDisk() {
+	if err := os.MkdirAll(diskDir, 0o755); err != nil {
+		log.Printf("Failed to create cache dir %s: %v", diskDir, err)
+		return
+	}
+
+	entries, err := os.ReadDir(diskDir)
+	if err != nil {
+		log.Printf("Failed to read cache dir %s: %v", diskDir, err)
+		return
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			// Left over by a write interrupted by a restart
+			_ = os.Remove(filepath.Join(diskDir, e.Name()))
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	for _, info := range infos {
+		diskIndex[info.Name()] = diskLRU.PushFront(&diskItem{name: info.Name(), size: info.Size()})
+		diskSize += info.Size()
+	}
+	evictDisk()
+}
+
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetSlice returns the slice stored under key on disk.
+func GetSlice(key string) ([]byte, bool) {
+	diskInit.Do(loadDisk)
+	name := diskName(key)
+
+	diskMu.Lock()
+	el, ok := diskIndex[name]
+	if ok {
+		diskLRU.MoveToFront(el)
+	}
+	diskMu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(diskDir, name))
+	if err != nil {
+		diskMu.Lock()
+		if el, ok := diskIndex[name]; ok {
+			removeDisk(el)
+		}
+		diskMu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// HasSlice reports whether a slice is stored under key, without reading it.
+func HasSlice(key string) bool {
+	diskInit.Do(loadDisk)
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	_, ok := diskIndex[diskName(key)]
+	return ok
+}
+
+// SetSlice stores data under key on disk, evicting the least recently used
+// slices to stay within capacity.
+func SetSlice(key string, data []byte) {
+	diskInit.Do(loadDisk)
+	name := diskName(key)
+
+	// Write to a temporary file first so readers never see a partial slice
+	tmp, err := os.CreateTemp(diskDir, ".tmp-")
+	if err != nil {
+		log.Printf("Failed to store slice: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(diskDir, name))
+	}
+	if err != nil {
+		log.Printf("Failed to store slice: %v", err)
+		_ = os.Remove(tmp.Name())
+		return
+	}
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	if el, ok := diskIndex[name]; ok {
+		diskSize -= el.Value.(*diskItem).size
+		diskLRU.Remove(el)
+	}
+	diskIndex[name] = diskLRU.PushFront(&diskItem{name: name, size: int64(len(data))})
+	diskSize += int64(len(data))
+	evictDisk()
+}
+
+func evictDisk() {
+	for diskSize > diskCapacity && diskLRU.Len() > 0 {
+		removeDisk(diskLRU.Back())
+		diskEvicted++
+	}
+}
+
+func removeDisk(el *list.Element) {
+	it := diskLRU.Remove(el).(*diskItem)
+	delete(diskIndex, it.name)
+	diskSize -= it.size
+	if err := os.Remove(filepath.Join(diskDir, it.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to evict slice %s: %v", it.name, err)
+	}
+}
+
+// DiskUsage returns the bytes of the slices on disk and the capacity of the
+// disk tier.
+func DiskUsage() (used, capacity int64) {
+	stats := DiskStats()
+	return stats.Bytes, stats.Capacity
+}
+
+// DiskStats returns the contents of the disk tier.
+func DiskStats() TierStats {
+	diskInit.Do(loadDisk)
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	return TierStats{Objects: int64(diskLRU.Len()), Bytes: diskSize, Capacity: diskCapacity, Evictions: diskEvicted}
+}
@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+// Files of a kubernetes.io/tls Secret the edge reads. The OCSP response,
+// DER encoded, is optional and stapled as is, whoever provides it keeps it
+// fresh.
+const (
+	certFile   = "tls.crt"
+	keyFile    = "tls.key"
+	stapleFile = "tls.ocsp"
+)
+
+// store holds the certificates of the edge by the names they cover.
+type store struct {
+	byName map[string]*tls.Certificate
+	first  *tls.Certificate
+}
+
+var current atomic.Pointer[store]
+
+func init() {
+	current.Store(&store{})
+}
+
+// Load reads the certificates under dir, one Secret per directory, and makes
+// them the current ones. Secrets that are missing or broken are skipped, a
+// name covered by several certificates goes to the first one.
+func Load(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	s := &store{byName: map[string]*tls.Certificate{}}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		secret := filepath.Join(dir, e.Name())
+		cert, err := loadCertificate(secret)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to load certificate %s: %v", secret, err)
+			continue
+		}
+		if s.first == nil {
+			s.first = cert
+		}
+		for _, name := range cert.Leaf.DNSNames {
+			name = strings.ToLower(name)
+			if _, ok := s.byName[name]; !ok {
+				s.byName[name] = cert
+			}
+		}
+	}
+	current.Store(s)
+	return nil
+}
+
+func loadCertificate(dir string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if staple, err := os.ReadFile(filepath.Join(dir, stapleFile)); err == nil && len(staple) > 0 {
+		cert.OCSPStaple = staple
+	}
+	return &cert, nil
+}
+
+// Watch loads the certificates under dir and reloads them whenever one of
+// their files changes.
+func Watch(dir string, interval time.Duration) {
+	if err := Load(dir); err != nil {
+		log.Printf("Failed to load certificates %s: %v", dir, err)
+	}
+	last := fingerprint(dir)
+
+	go func() {
+		for range time.Tick(interval) {
+			if fp := fingerprint(dir); fp != last {
+				last = fp
+				if err := Load(dir); err != nil {
+					log.Printf("Failed to reload certificates %s: %v", dir, err)
+					continue
+				}
+				log.Printf("Reloaded certificates %s", dir)
+			}
+		}
+	}()
+}
+
+// fingerprint sums up the files of the certificates under dir. Secret
+// volumes are updated through a symlink swap, which is picked up here since
+// os.Stat follows the link.
+func fingerprint(dir string) string {
+	var b strings.Builder
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		for _, file := range []string{certFile, keyFile, stapleFile} {
+			p := filepath.Join(dir, e.Name(), file)
+			if fi, err := os.Stat(p); err == nil {
+				fmt.Fprintf(&b, "%s %d %d\n", p, fi.Size(), fi.ModTime().UnixNano())
+			}
+		}
+	}
+	return b.String()
+}
+
+// GetCertificate picks the certificate for the server name of a handshake,
+// falling back to the one of the domain of the CDN.
+func GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s := current.Load()
+	for _, name := range []string{hello.ServerName, config.Current().DomainName} {
+		if cert := s.lookup(name); cert != nil {
+			return cert, nil
+		}
+	}
+	if s.first == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return s.first, nil
+}
+
+func (s *store) lookup(name string) *tls.Certificate {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+	if cert, ok := s.byName[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return s.byName["*"+name[i:]]
+	}
+	return nil
+}
+
+// TLSConfig returns the TLS config of the edge. Certificates are picked by
+// SNI, the minimum version and cipher suites follow the current config on
+// every handshake.
+func TLSConfig() *tls.Config {
+	base := &tls.Config{
+		GetCertificate: GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		if ssl := config.Current().SSLConfig; ssl != nil {
+			if ssl.MinVersion == "1.3" {
+				cfg.MinVersion = tls.VersionTLS13
+			}
+			cfg.CipherSuites = cipherSuites(ssl.CipherSuites)
+		}
+		return cfg, nil
+	}
+	return base
+}
+
+// cipherSuites returns the IDs of the named suites, nil for Go's defaults
+// when none of them is known.
+func cipherSuites(names []string) []uint16 {
+	var ids []uint16
+	for _, name := range names {
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("Unknown cipher suite %s", name)
+		}
+	}
+	return ids
+}
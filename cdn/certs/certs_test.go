@@ -0,0 +1,121 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreLookup(t *testing.T) {
+	exact, wildcard := &tls.Certificate{}, &tls.Certificate{}
+	s := &store{byName: map[string]*tls.Certificate{
+		"cdn.example.com":   exact,
+		"*.cdn.example.com": wildcard,
+	}}
+
+	tests := []struct {
+		name string
+		want *tls.Certificate
+	}{
+		{"cdn.example.com", exact},
+		{"CDN.Example.com.", exact},
+		{"img.cdn.example.com", wildcard},
+		{"a.img.cdn.example.com", nil},
+		{"example.com", nil},
+		{"localhost", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.lookup(tt.name); got != tt.want {
+				t.Errorf("lookup(%q) = %p, want %p", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeSecret(t, dir, "a", "cdn.example.com", "*.cdn.example.com")
+	writeSecret(t, dir, "b", "CDN.example.com", "other.example.com")
+	// Secrets not mounted yet are skipped
+	if err := os.Mkdir(filepath.Join(dir, "c"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	s := current.Load()
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"cdn.example.com", "a"},
+		{"img.cdn.example.com", "a"},
+		{"other.example.com", "b"},
+		{"missing.example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if cert := s.lookup(tt.name); cert != nil {
+				got = cert.Leaf.Subject.CommonName
+			}
+			if got != tt.secret {
+				t.Errorf("lookup(%q) = certificate of %q, want %q", tt.name, got, tt.secret)
+			}
+		})
+	}
+	if got := s.first.Leaf.Subject.CommonName; got != "a" {
+		t.Errorf("first certificate = %q, want %q", got, "a")
+	}
+}
+
+// writeSecret writes a self-signed certificate for names under dir/secret,
+// its common name set to secret.
+func writeSecret(t *testing.T, dir, secret string, names ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: secret},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(dir, secret)
+	if err := os.Mkdir(p, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for name, block := range files {
+		if err := os.WriteFile(filepath.Join(p, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultPath is where the controller mounts the rendered ContentDeliveryNetwork spec
+	DefaultPath = "/etc/kube-cdn/config/spec.json"
+	// DefaultGeoIPDir is where the controller mounts the GeoIP database volume
+	DefaultGeoIPDir = "/etc/kube-cdn/geoip"
+	// DefaultTLSDir is where the controller mounts the TLS Secrets, one
+	// directory each
+	DefaultTLSDir = "/etc/kube-cdn/tls"
+	// DefaultACMEDir is where the controller mounts the pending ACME HTTP-01
+	// challenge responses, one file per token
+	DefaultACMEDir = "/etc/kube-cdn/acme"
+)
+
+// Spec mirrors the parts of the ContentDeliveryNetwork spec the edge acts on.
+// The controller renders the whole spec as JSON, unknown fields are ignored.
+type (
+	Spec struct {
+		Origin          string           `json:"origin"`
+		DomainName      string           `json:"domainName"`
+		CacheRules      []CacheRule      `json:"cacheRules,omitempty"`
+		Routes          []Route          `json:"routes,omitempty"`
+		CORS            *CORSPolicy      `json:"cors,omitempty"`
+		Redirects       []RedirectRule   `json:"redirects,omitempty"`
+		Rewrites        []RewriteRule    `json:"rewrites,omitempty"`
+		Streaming       *Streaming       `json:"streaming,omitempty"`
+		GeoRestrictions *GeoRestrictions `json:"geoRestrictions,omitempty"`
+		SSLConfig       *SSLConfig       `json:"sslConfig,omitempty"`
+	}
+
+	CacheRule struct {
+		PathPattern   string `json:"pathPattern"`
+		TTL           int    `json:"ttl"` // in seconds
+		VaryByCountry bool   `json:"varyByCountry,omitempty"`
+		SliceSize     int64  `json:"sliceSize,omitempty"`
+		CachePrivate  bool   `json:"cachePrivate,omitempty"`
+	}
+
+	Route struct {
+		PathPattern       string             `json:"pathPattern"`
+		RequestHeaders    *HeaderRules       `json:"requestHeaders,omitempty"`
+		ResponseHeaders   *HeaderRules       `json:"responseHeaders,omitempty"`
+		ImageOptimization *ImageOptimization `json:"imageOptimization,omitempty"`
+	}
+
+	ImageOptimization struct {
+		MaxWidth      int           `json:"maxWidth,omitempty"`
+		MaxHeight     int           `json:"maxHeight,omitempty"`
+		MaxSourceSize int64         `json:"maxSourceSize,omitempty"`
+		Quality       int           `json:"quality,omitempty"`
+		Presets       []ImagePreset `json:"presets,omitempty"`
+		PresetsOnly   bool          `json:"presetsOnly,omitempty"`
+	}
+
+	ImagePreset struct {
+		Name    string `json:"name"`
+		Width   int    `json:"width,omitempty"`
+		Height  int    `json:"height,omitempty"`
+		Fit     string `json:"fit,omitempty"`
+		Quality int    `json:"quality,omitempty"`
+		Format  string `json:"format,omitempty"`
+	}
+
+	HeaderRules struct {
+		Set    []HeaderValue `json:"set,omitempty"`
+		Add    []HeaderValue `json:"add,omitempty"`
+		Remove []string      `json:"remove,omitempty"`
+	}
+
+	HeaderValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	Streaming struct {
+		LiveManifestTTL  int `json:"liveManifestTTL,omitempty"`
+		SegmentTTL       int `json:"segmentTTL,omitempty"`
+		PrefetchSegments int `json:"prefetchSegments"`
+	}
+
+	RedirectRule struct {
+		Match      string `json:"match"`
+		Target     string `json:"target"`
+		StatusCode int    `json:"statusCode,omitempty"`
+		Scheme     string `json:"scheme,omitempty"`
+		DropQuery  bool   `json:"dropQuery,omitempty"`
+
+		pattern *regexp.Regexp
+	}
+
+	RewriteRule struct {
+		Match       string `json:"match"`
+		Replacement string `json:"replacement"`
+		Origin      string `json:"origin,omitempty"`
+
+		pattern *regexp.Regexp
+	}
+
+	CORSPolicy struct {
+		AllowedOrigins   []string `json:"allowedOrigins"`
+		AllowedMethods   []string `json:"allowedMethods,omitempty"`
+		AllowedHeaders   []string `json:"allowedHeaders,omitempty"`
+		ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
+		AllowCredentials bool     `json:"allowCredentials,omitempty"`
+		MaxAge           int      `json:"maxAge,omitempty"` // in seconds
+	}
+
+	GeoRestrictions struct {
+		AllowCountries []string      `json:"allowCountries,omitempty"`
+		DenyCountries  []string      `json:"denyCountries,omitempty"`
+		RedirectURL    string        `json:"redirectURL,omitempty"`
+		Origins        []GeoOrigin   `json:"origins,omitempty"`
+		Database       GeoIPDatabase `json:"database"`
+	}
+
+	GeoOrigin struct {
+		Countries []string `json:"countries"`
+		Origin    string   `json:"origin"`
+	}
+
+	GeoIPDatabase struct {
+		CountryFile string `json:"countryFile,omitempty"`
+		ASNFile     string `json:"asnFile,omitempty"`
+	}
+
+	// SSLConfig holds the TLS settings of the edge, the certificates are
+	// mounted apart
+	SSLConfig struct {
+		Enabled      bool     `json:"enabled"`
+		MinVersion   string   `json:"minVersion,omitempty"`
+		CipherSuites []string `json:"cipherSuites,omitempty"`
+	}
+)
+
+var current atomic.Pointer[Spec]
+
+func init() {
+	current.Store(&Spec{})
+}
+
+// Current returns the most recently loaded spec, never nil.
+func Current() *Spec {
+	return current.Load()
+}
+
+// Load reads the spec at p and makes it the current one.
+func Load(p string) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return err
+	}
+	if err := spec.compile(); err != nil {
+		return err
+	}
+	current.Store(spec)
+	return nil
+}
+
+// compile prepares the regular expressions of the spec, so a bad pattern
+// rejects the whole spec instead of failing requests.
+func (s *Spec) compile() error {
+	var err error
+	for i := range s.Redirects {
+		if s.Redirects[i].pattern, err = regexp.Compile(s.Redirects[i].Match); err != nil {
+			return fmt.Errorf("redirect %q: %w", s.Redirects[i].Match, err)
+		}
+	}
+	for i := range s.Rewrites {
+		if s.Rewrites[i].pattern, err = regexp.Compile(s.Rewrites[i].Match); err != nil {
+			return fmt.Errorf("rewrite %q: %w", s.Rewrites[i].Match, err)
+		}
+	}
+	return nil
+}
+
+// Path returns the spec location, overridable through CDN_CONFIG_PATH.
+func Path() string {
+	return env("CDN_CONFIG_PATH", DefaultPath)
+}
+
+// GeoIPDir returns the GeoIP database directory, overridable through CDN_GEOIP_DIR.
+func GeoIPDir() string {
+	return env("CDN_GEOIP_DIR", DefaultGeoIPDir)
+}
+
+// TLSDir returns the TLS Secret directory, overridable through CDN_TLS_DIR.
+func TLSDir() string {
+	return env("CDN_TLS_DIR", DefaultTLSDir)
+}
+
+// ACMEDir returns the ACME challenge directory, overridable through CDN_ACME_DIR.
+func ACMEDir() string {
+	return env("CDN_ACME_DIR", DefaultACMEDir)
+}
+
+// TrustedProxies returns the CIDRs of the proxies whose X-Forwarded-For and
+// X-Forwarded-Proto are trusted, from the comma separated CDN_TRUSTED_PROXIES.
+// It is nil if unset, so that the client address is always the peer address.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, cidr := range strings.Split(os.Getenv("CDN_TRUSTED_PROXIES"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			proxies = append(proxies, cidr)
+		}
+	}
+	return proxies
+}
+
+// IsTrustedProxy reports whether ip is within TrustedProxies, whose entries
+// are CIDRs or single addresses as gin accepts them.
+func IsTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range TrustedProxies() {
+		if _, n, err := net.ParseCIDR(proxy); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if net.ParseIP(proxy).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// WatchFile polls p and calls onChange whenever its modification time changes.
+// ConfigMap and Secret volumes are updated through a symlink swap, which is
+// picked up here since os.Stat follows the link.
+func WatchFile(p string, interval time.Duration, onChange func()) {
+	var last time.Time
+	if fi, err := os.Stat(p); err == nil {
+		last = fi.ModTime()
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			fi, err := os.Stat(p)
+			if err != nil || fi.ModTime().Equal(last) {
+				continue
+			}
+			last = fi.ModTime()
+			onChange()
+		}
+	}()
+}
+
+// Watch loads the spec at p and reloads it whenever the file changes. The
+// onLoad hooks run after every load, including the initial one.
+func Watch(p string, interval time.Duration, onLoad ...func()) {
+	if err := Load(p); err != nil {
+		log.Printf("Failed to load config %s: %v", p, err)
+	}
+	for _, fn := range onLoad {
+		fn()
+	}
+
+	WatchFile(p, interval, func() {
+		if err := Load(p); err != nil {
+			log.Printf("Failed to reload config %s: %v", p, err)
+			return
+		}
+		log.Printf("Reloaded config %s", p)
+		for _, fn := range onLoad {
+			fn()
+		}
+	})
+}
+
+// OriginFor returns the origin serving clients from country.
+func (s *Spec) OriginFor(country string) string {
+	if g := s.GeoRestrictions; g != nil && country != "" {
+		for _, o := range g.Origins {
+			if containsCountry(o.Countries, country) {
+				return o.Origin
+			}
+		}
+	}
+	return s.Origin
+}
+
+// CacheRuleFor returns the first cache rule matching urlPath, or nil.
+func (s *Spec) CacheRuleFor(urlPath string) *CacheRule {
+	for i := range s.CacheRules {
+		if MatchPath(s.CacheRules[i].PathPattern, urlPath) {
+			return &s.CacheRules[i]
+		}
+	}
+	return nil
+}
+
+// RouteFor returns the first route matching urlPath, or nil.
+func (s *Spec) RouteFor(urlPath string) *Route {
+	for i := range s.Routes {
+		if MatchPath(s.Routes[i].PathPattern, urlPath) {
+			return &s.Routes[i]
+		}
+	}
+	return nil
+}
+
+// MatchPath reports whether urlPath matches pattern. A trailing "*" matches
+// any suffix including slashes, otherwise path.Match semantics apply.
+func MatchPath(pattern, urlPath string) bool {
+	if strings.HasSuffix(pattern, "*") && !strings.ContainsAny(strings.TrimSuffix(pattern, "*"), "*?[") {
+		return strings.HasPrefix(urlPath, strings.TrimSuffix(pattern, "*"))
+	}
+	ok, _ := path.Match(pattern, urlPath)
+	return ok
+}
+
+// AllowsOrigin reports whether origin matches one of the allowed origins,
+// which may be "*" or contain path.Match wildcards such as
+// "https://*.example.com".
+func (p *CORSPolicy) AllowsOrigin(origin string) bool {
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAnyOrigin reports whether "*" is one of the allowed origins.
+func (p *CORSPolicy) AllowsAnyOrigin() bool {
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ManifestTTL returns how long a manifest may be cached, in seconds.
+func (s *Streaming) ManifestTTL(live bool) int {
+	if !live {
+		return s.EffectiveSegmentTTL()
+	}
+	if s.LiveManifestTTL > 0 {
+		return s.LiveManifestTTL
+	}
+	return 2
+}
+
+// EffectiveSegmentTTL returns how long a segment may be cached, in seconds.
+func (s *Streaming) EffectiveSegmentTTL() int {
+	if s.SegmentTTL > 0 {
+		return s.SegmentTTL
+	}
+	return 86400
+}
+
+// Redirect returns the target and status code of the first redirect rule
+// matching a request for urlPath received over scheme.
+func (s *Spec) Redirect(scheme, host, urlPath string) (string, int, *RedirectRule) {
+	for i := range s.Redirects {
+		rule := &s.Redirects[i]
+		if rule.Scheme != "" && !strings.EqualFold(rule.Scheme, scheme) {
+			continue
+		}
+		match := rule.pattern.FindStringSubmatchIndex(urlPath)
+		if match == nil {
+			continue
+		}
+
+		template := strings.ReplaceAll(rule.Target, "${host}", host)
+		target := rule.pattern.ExpandString(nil, template, urlPath, match)
+
+		status := rule.StatusCode
+		if status == 0 {
+			status = http.StatusMovedPermanently
+		}
+		return string(target), status, rule
+	}
+	return "", 0, nil
+}
+
+// Rewrite returns the origin path and origin of the first rewrite rule
+// matching urlPath. The origin is empty when the rule keeps the default one.
+func (s *Spec) Rewrite(urlPath string) (string, string, bool) {
+	for _, rule := range s.Rewrites {
+		match := rule.pattern.FindStringSubmatchIndex(urlPath)
+		if match == nil {
+			continue
+		}
+		rewritten := rule.pattern.ExpandString(nil, rule.Replacement, urlPath, match)
+		return string(rewritten), rule.Origin, true
+	}
+	return "", "", false
+}
+
+// Preset returns the preset called name, or nil.
+func (o *ImageOptimization) Preset(name string) *ImagePreset {
+	for i := range o.Presets {
+		if o.Presets[i].Name == name {
+			return &o.Presets[i]
+		}
+	}
+	return nil
+}
+
+// Methods returns the allowed methods, GET and HEAD unless configured.
+func (p *CORSPolicy) Methods() []string {
+	if len(p.AllowedMethods) == 0 {
+		return []string{http.MethodGet, http.MethodHead}
+	}
+	return p.AllowedMethods
+}
+
+// AllowsMethod reports whether method may be used cross-origin.
+func (p *CORSPolicy) AllowsMethod(method string) bool {
+	for _, m := range p.Methods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedRequestHeaders filters the comma separated requested headers of a
+// preflight down to the allowed ones. A "*" entry allows any header.
+func (p *CORSPolicy) AllowedRequestHeaders(requested string) string {
+	var allowed []string
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		for _, a := range p.AllowedHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				allowed = append(allowed, h)
+				break
+			}
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
+
+// Allowed reports whether clients from country may access the CDN. Clients
+// whose country is unknown are only rejected by a non-empty allow list.
+func (g *GeoRestrictions) Allowed(country string) bool {
+	if len(g.AllowCountries) > 0 && !containsCountry(g.AllowCountries, country) {
+		return false
+	}
+	return !containsCountry(g.DenyCountries, country)
+}
+
+func containsCountry(countries []string, country string) bool {
+	for _, c := range countries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
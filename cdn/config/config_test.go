@@ -0,0 +1,187 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCORSPolicyAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"any origin", []string{"*"}, "https://evil.example", true},
+		{"exact match", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"case insensitive", []string{"https://App.Example.com"}, "https://app.example.COM", true},
+		{"other origin", []string{"https://app.example.com"}, "https://api.example.com", false},
+		{"other scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"subdomain wildcard", []string{"https://*.example.com"}, "https://api.example.com", true},
+		{"wildcard needs a subdomain", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard stops at dots", []string{"https://*.example.com"}, "https://evil.com/.example.com", false},
+		{"suffix lookalike", []string{"https://*.example.com"}, "https://api.example.com.evil", false},
+		{"second pattern", []string{"https://a.example", "https://b.example"}, "https://b.example", true},
+		{"none allowed", nil, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &CORSPolicy{AllowedOrigins: tt.allowed}
+			if got := p.AllowsOrigin(tt.origin); got != tt.want {
+				t.Errorf("AllowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSPolicyAllowsMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		method  string
+		want    bool
+	}{
+		{"GET by default", nil, "GET", true},
+		{"HEAD by default", nil, "HEAD", true},
+		{"not POST by default", nil, "POST", false},
+		{"configured", []string{"GET", "POST"}, "post", true},
+		{"configured replaces defaults", []string{"POST"}, "HEAD", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &CORSPolicy{AllowedMethods: tt.allowed}
+			if got := p.AllowsMethod(tt.method); got != tt.want {
+				t.Errorf("AllowsMethod(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSPolicyAllowedRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		requested string
+		want      string
+	}{
+		{"none allowed", nil, "X-Token", ""},
+		{"filtered", []string{"Content-Type"}, "content-type, X-Token", "content-type"},
+		{"any", []string{"*"}, "X-Token,Content-Type", "X-Token, Content-Type"},
+		{"empty entries", []string{"*"}, " , X-Token,", "X-Token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &CORSPolicy{AllowedHeaders: tt.allowed}
+			if got := p.AllowedRequestHeaders(tt.requested); got != tt.want {
+				t.Errorf("AllowedRequestHeaders(%q) = %q, want %q", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecRedirect(t *testing.T) {
+	spec := &Spec{Redirects: []RedirectRule{
+		{Match: `^/old/(.*)$`, Target: "https://${host}/new/$1"},
+		{Match: `^/.*$`, Target: "https://${host}$0", Scheme: "http", StatusCode: 308},
+		{Match: `^/docs$`, Target: "/docs/", StatusCode: 302},
+	}}
+	if err := spec.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		scheme     string
+		urlPath    string
+		wantTarget string
+		wantStatus int
+	}{
+		{"capture group", "https", "/old/a/b.html", "https://cdn.example.com/new/a/b.html", 301},
+		{"first rule wins", "http", "/old/x", "https://cdn.example.com/new/x", 301},
+		{"scheme rule", "http", "/docs", "https://cdn.example.com/docs", 308},
+		{"scheme is case insensitive", "HTTP", "/index.html", "https://cdn.example.com/index.html", 308},
+		{"rule skipped for other scheme", "https", "/docs", "/docs/", 302},
+		{"no match", "https", "/index.html", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, status, rule := spec.Redirect(tt.scheme, "cdn.example.com", tt.urlPath)
+			if target != tt.wantTarget || status != tt.wantStatus {
+				t.Errorf("Redirect(%q, %q) = %q, %d, want %q, %d", tt.scheme, tt.urlPath, target, status, tt.wantTarget, tt.wantStatus)
+			}
+			if (rule != nil) != (tt.wantStatus != 0) {
+				t.Errorf("Redirect(%q, %q) rule = %v", tt.scheme, tt.urlPath, rule)
+			}
+		})
+	}
+}
+
+func TestSpecRewrite(t *testing.T) {
+	spec := &Spec{Rewrites: []RewriteRule{
+		{Match: `^/img/(\d+)/(.*)$`, Replacement: "/images/$2?w=$1"},
+		{Match: `^/api/(.*)$`, Replacement: "/v2/$1", Origin: "https://api.example.com"},
+		{Match: `^/api/legacy$`, Replacement: "/never"},
+	}}
+	if err := spec.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantPath   string
+		wantOrigin string
+		wantOK     bool
+	}{
+		{"capture groups", "/img/300/cat.png", "/images/cat.png?w=300", "", true},
+		{"origin", "/api/users", "/v2/users", "https://api.example.com", true},
+		{"first rule wins", "/api/legacy", "/v2/legacy", "https://api.example.com", true},
+		{"no match", "/index.html", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotOrigin, ok := spec.Rewrite(tt.urlPath)
+			if gotPath != tt.wantPath || gotOrigin != tt.wantOrigin || ok != tt.wantOK {
+				t.Errorf("Rewrite(%q) = %q, %q, %v, want %q, %q, %v", tt.urlPath, gotPath, gotOrigin, ok, tt.wantPath, tt.wantOrigin, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSpecCompileRejectsInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+	}{
+		{"redirect", Spec{Redirects: []RedirectRule{{Match: "(", Target: "/"}}}},
+		{"rewrite", Spec{Rewrites: []RewriteRule{{Match: "[", Replacement: "/"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.spec.compile(); err == nil {
+				t.Error("compile() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	t.Setenv("CDN_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"2001:db8::1", true},
+		{"203.0.113.1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsTrustedProxy(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("IsTrustedProxy(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package geoip
+
+import (
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+// Location is what the edge knows about where a client comes from
+type Location struct {
+	Country string
+	ASN     uint
+	ASOrg   string
+}
+
+type (
+	countryRecord struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	asnRecord struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+)
+
+var (
+	countryDB atomic.Pointer[maxminddb.Reader]
+	asnDB     atomic.Pointer[maxminddb.Reader]
+
+	// active maps each database slot to the file currently configured for it,
+	// watched records the files that already have a watcher running
+	watchedMu sync.Mutex
+	active    = map[*atomic.Pointer[maxminddb.Reader]]string{}
+	watched   = map[string]bool{}
+)
+
+// Sync opens the databases named in the current config and keeps them
+// reloaded when the files change. It is safe to call on every config reload.
+func Sync() {
+	var db config.GeoIPDatabase
+	if g := config.Current().GeoRestrictions; g != nil {
+		db = g.Database
+	}
+	watch(db.CountryFile, &countryDB)
+	watch(db.ASNFile, &asnDB)
+}
+
+func watch(name string, target *atomic.Pointer[maxminddb.Reader]) {
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+
+	if name == "" {
+		delete(active, target)
+		target.Store(nil)
+		return
+	}
+
+	p := filepath.Join(config.GeoIPDir(), name)
+	active[target] = p
+	if err := open(p, target); err != nil {
+		log.Printf("Failed to open GeoIP database %s: %v", p, err)
+	}
+
+	if watched[p] {
+		return
+	}
+	watched[p] = true
+	config.WatchFile(p, 30*time.Second, func() {
+		watchedMu.Lock()
+		defer watchedMu.Unlock()
+		if active[target] != p {
+			return
+		}
+		if err := open(p, target); err != nil {
+			log.Printf("Failed to reload GeoIP database %s: %v", p, err)
+			return
+		}
+		log.Printf("Reloaded GeoIP database %s", p)
+	})
+}
+
+// open reads the whole database into memory rather than mmapping it, so
+// in-flight lookups on a replaced reader stay valid until they return.
+func open(p string, target *atomic.Pointer[maxminddb.Reader]) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	target.Store(reader)
+	return nil
+}
+
+// Lookup resolves ip against the loaded databases. Fields stay empty when no
+// database is loaded or the address is unknown.
+func Lookup(ip net.IP) Location {
+	var loc Location
+	if ip == nil {
+		return loc
+	}
+
+	if db := countryDB.Load(); db != nil {
+		var rec countryRecord
+		if err := db.Lookup(ip, &rec); err == nil {
+			loc.Country = rec.Country.ISOCode
+		}
+	}
+
+	if db := asnDB.Load(); db != nil {
+		var rec asnRecord
+		if err := db.Lookup(ip, &rec); err == nil {
+			loc.ASN = rec.Number
+			loc.ASOrg = rec.Organization
+		}
+	}
+
+	return loc
+}
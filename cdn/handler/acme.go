@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+// ACME tokens are base64url, which also keeps them inside the directory
+var acmeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ACMEChallenge answers the ACME HTTP-01 challenges of the certificates the
+// controller orders for the domain, with the key authorizations it publishes
+// to the edges, one file per token.
+func ACMEChallenge(c *gin.Context) {
+	token := c.Param("token")
+	if !acmeToken.MatchString(token) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	keyAuth, err := os.ReadFile(filepath.Join(config.ACMEDir(), token))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain", keyAuth)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestACMEChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	files := map[string]string{
+		"Tok_en-1":    "Tok_en-1.thumbprint",
+		"key.pem":     "not a token",
+		".tok":        "hidden",
+		"../outside":  "outside the directory",
+		"../acme.key": "outside the directory",
+	}
+	acmeDir := filepath.Join(dir, "acme")
+	if err := os.Mkdir(acmeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(acmeDir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CDN_ACME_DIR", acmeDir)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"key authorization", "Tok_en-1", http.StatusOK, "Tok_en-1.thumbprint"},
+		{"unknown token", "Tok_en-2", http.StatusNotFound, ""},
+		{"dotted name", "key.pem", http.StatusNotFound, ""},
+		{"hidden file", ".tok", http.StatusNotFound, ""},
+		{"parent directory", "..", http.StatusNotFound, ""},
+		{"traversal", "../outside", http.StatusNotFound, ""},
+		{"dotted traversal", "../acme.key", http.StatusNotFound, ""},
+		{"empty", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/x", nil)
+			c.Params = gin.Params{{Key: "token", Value: tt.token}}
+			ACMEChallenge(c)
+			c.Writer.WriteHeaderNow()
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.token, w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusOK && w.Header().Get("Content-Type") != "text/plain" {
+				t.Errorf("Content-Type = %q, want text/plain", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
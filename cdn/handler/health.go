@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+var draining atomic.Bool
+
+// Drain fails the health checks from now on, so the edge is taken out of
+// rotation while it finishes serving.
+func Drain() {
+	draining.Store(true)
+}
+
+// Health answers the health checks of the DNS layer and the kubelet. The
+// edge is healthy once it has a config with an origin to serve from, and
+// until it drains.
+func Health(c *gin.Context) {
+	if draining.Load() {
+		c.String(http.StatusServiceUnavailable, "draining")
+		return
+	}
+	if config.Current().Origin == "" {
+		c.String(http.StatusServiceUnavailable, "no config")
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/imageopt"
+	"github.com/benauro/kube-cdn/cdn/middleware"
+	"github.com/benauro/kube-cdn/cdn/origin"
+)
+
+// serveImage answers a request for a transformed image. It reports false
+// when no transform was requested, leaving the request to the plain proxy.
+func serveImage(c *gin.Context, base string, rule *config.CacheRule, cfg *config.ImageOptimization) bool {
+	opts, err := imageopt.ParseOptions(c.Request.URL.Query(), c.GetHeader("Accept"), cfg)
+	if errors.Is(err, imageopt.ErrNoTransform) {
+		return false
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return true
+	}
+
+	// The source image is fetched without the transform parameters
+	query := c.Request.URL.Query()
+	imageopt.StripQuery(query)
+	c.Request.URL.RawQuery = query.Encode()
+
+	// Every variant is cached on its own, keyed like the source image plus
+	// the normalized transform
+	key := cache.Key(cacheKey(c.Request.Host, c.Request.URL.RequestURI(), rule, middleware.ClientLocation(c).Country), "image="+opts.String())
+	cacheable := rule != nil && rule.TTL > 0 && sharedRequest(c.Request, rule)
+	if cacheable {
+		if entry, ok := cache.Get(key); ok {
+			writeEntry(c, entry, CacheHit)
+			return true
+		}
+	}
+
+	// Transforms need the raw image bytes
+	c.Request.Header.Del("Accept-Encoding")
+
+	resp, err := origin.Fetch(base, c.Request)
+	if err != nil {
+		log.Printf("Failed to fetch %s from origin %s: %v", c.Request.URL.Path, base, err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return true
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		stream(c, resp, nil, CacheBypass)
+		return true
+	}
+
+	limit := imageopt.MaxSourceSize(cfg)
+	src, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		log.Printf("Failed to read %s from origin %s: %v", c.Request.URL.Path, base, err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return true
+	}
+	if int64(len(src)) > limit {
+		// Too large to transform, serve the original
+		stream(c, resp, src, CacheBypass)
+		return true
+	}
+
+	body, contentType, err := imageopt.Transform(src, opts)
+	if err != nil {
+		log.Printf("Failed to transform %s: %v", c.Request.URL.Path, err)
+		stream(c, resp, src, CacheBypass)
+		return true
+	}
+
+	entry := &cache.Entry{
+		Status: http.StatusOK,
+		Header: http.Header{},
+		Body:   body,
+	}
+	origin.CopyHeader(entry.Header, resp.Header)
+	entry.Header.Del("ETag")
+	entry.Header.Set("Content-Type", contentType)
+	entry.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// The output format may depend on Accept
+	entry.Header.Add("Vary", "Accept")
+
+	status := CacheBypass
+	if cacheable && storable(resp.Header, rule) {
+		status = CacheMiss
+		stored := *entry
+		stored.Header = storedHeader(entry.Header)
+		stored.Expires = time.Now().Add(time.Duration(rule.TTL) * time.Second)
+		cache.Set(key, &stored)
+	}
+	writeEntry(c, entry, status)
+	return true
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/origin"
+	"github.com/benauro/kube-cdn/cdn/streaming"
+)
+
+var (
+	prefetchMu       sync.Mutex
+	prefetchInflight = map[string]bool{}
+	// Bounds the origin load caused by prefetching
+	prefetchSlots = make(chan struct{}, 8)
+)
+
+// prefetchSegments warms the cache with the upcoming segments of a manifest
+// which was just fetched from the origin.
+func prefetchSegments(c *gin.Context, base string, spec *config.Spec, country string, manifest []byte, live bool) {
+	n := spec.Streaming.PrefetchSegments
+	uris := streaming.UpcomingSegments(c.Request.URL, manifest, live, n)
+	if len(uris) == 0 {
+		return
+	}
+
+	// Conditional and range headers of the manifest request do not apply
+	header := c.Request.Header.Clone()
+	for _, h := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		header.Del(h)
+	}
+
+	host := c.Request.Host
+	for _, uri := range uris {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, uri, nil)
+		if err != nil {
+			continue
+		}
+		req.Host = host
+		req.Header = header.Clone()
+
+		rule := spec.CacheRuleFor(req.URL.Path)
+		if !sharedRequest(req, rule) {
+			continue
+		}
+		key := cacheKey(host, uri, rule, country)
+		if _, ok := cache.Lookup(key, req.Header); ok {
+			continue
+		}
+
+		prefetchMu.Lock()
+		if prefetchInflight[key] {
+			prefetchMu.Unlock()
+			continue
+		}
+		prefetchInflight[key] = true
+		prefetchMu.Unlock()
+
+		go prefetch(base, key, req, rule, spec.Streaming.EffectiveSegmentTTL())
+	}
+}
+
+func prefetch(base, key string, req *http.Request, rule *config.CacheRule, ttl int) {
+	defer func() {
+		prefetchMu.Lock()
+		delete(prefetchInflight, key)
+		prefetchMu.Unlock()
+	}()
+
+	prefetchSlots <- struct{}{}
+	defer func() { <-prefetchSlots }()
+
+	ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+	defer cancel()
+
+	resp, err := origin.Fetch(base, req.WithContext(ctx))
+	if err != nil {
+		log.Printf("Failed to prefetch %s: %v", req.URL.Path, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength > cache.MaxObjectSize() || !storable(resp.Header, rule) {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cache.MaxObjectSize()+1))
+	if err != nil || int64(len(body)) > cache.MaxObjectSize() {
+		return
+	}
+
+	cache.Store(key, req.Header, &cache.Entry{
+		Status:  resp.StatusCode,
+		Header:  storedHeader(resp.Header),
+		Body:    body,
+		Expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	})
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/middleware"
+	"github.com/benauro/kube-cdn/cdn/origin"
+	"github.com/benauro/kube-cdn/cdn/streaming"
+)
+
+const (
+	// HeaderCache reports whether the response came from the edge cache
+	HeaderCache = "X-Cache"
+
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+)
+
+// Proxy serves a request from the edge cache, falling back to the origin
+// selected for the client's country.
+func Proxy(c *gin.Context) {
+	spec := config.Current()
+	loc := middleware.ClientLocation(c)
+
+	base := middleware.RewriteOrigin(c)
+	if base == "" {
+		base = spec.OriginFor(loc.Country)
+	}
+	if base == "" {
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	rule := spec.CacheRuleFor(c.Request.URL.Path)
+
+	if route := spec.RouteFor(c.Request.URL.Path); route != nil && route.ImageOptimization != nil &&
+		(c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) {
+		if serveImage(c, base, rule, route.ImageOptimization) {
+			return
+		}
+	}
+
+	ttl := 0
+	if rule != nil {
+		ttl = rule.TTL
+	}
+
+	// Streaming manifests and segments are cached by kind, manifests of live
+	// streams get their final TTL once fetched
+	kind := streaming.KindNone
+	if spec.Streaming != nil {
+		kind = streaming.Kind(c.Request.URL.Path)
+		if kind != streaming.KindNone {
+			ttl = spec.Streaming.EffectiveSegmentTTL()
+		}
+	}
+
+	cacheable := ttl > 0 &&
+		(c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) &&
+		sharedRequest(c.Request, rule)
+
+	var key string
+	if cacheable {
+		key = cacheKey(c.Request.Host, c.Request.URL.RequestURI(), rule, loc.Country)
+
+		if rule != nil && rule.SliceSize > 0 {
+			serveSliced(c, base, rule, key, ttl)
+			return
+		}
+
+		if entry, ok := cache.Lookup(key, c.Request.Header); ok {
+			writeEntry(c, entry, CacheHit)
+			return
+		}
+	}
+
+	resp, err := origin.Fetch(base, c.Request)
+	if err != nil {
+		log.Printf("Failed to fetch %s from origin %s: %v", c.Request.URL.Path, base, err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	status := CacheBypass
+	if cacheable && storable(resp.Header, rule) {
+		status = CacheMiss
+	}
+
+	// Only complete, reasonably sized successful responses are kept
+	if status != CacheMiss || c.Request.Method != http.MethodGet || resp.StatusCode != http.StatusOK ||
+		resp.ContentLength > cache.MaxObjectSize() {
+		stream(c, resp, nil, status)
+		return
+	}
+
+	body, ok := tee(c, resp, status)
+	if !ok {
+		return
+	}
+
+	if kind == streaming.KindManifest {
+		live := streaming.IsLive(c.Request.URL.Path, body)
+		ttl = spec.Streaming.ManifestTTL(live)
+		prefetchSegments(c, base, spec, loc.Country, body, live)
+	}
+
+	cache.Store(key, c.Request.Header, &cache.Entry{
+		Status:  resp.StatusCode,
+		Header:  storedHeader(resp.Header),
+		Body:    body,
+		Expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	})
+}
+
+// cacheKey returns the key of requestURI on host, including the variants
+// required by rule.
+func cacheKey(host, requestURI string, rule *config.CacheRule, country string) string {
+	var variants []string
+	if rule != nil && rule.VaryByCountry {
+		variants = append(variants, "country="+country)
+	}
+	return cache.Key(host, requestURI, variants...)
+}
+
+// sharedRequest reports whether r may be answered from the cache shared by
+// every client. Requests carrying cookies or credentials may get personal
+// responses, so they go to the origin unless rule opts in.
+func sharedRequest(r *http.Request, rule *config.CacheRule) bool {
+	if rule != nil && rule.CachePrivate {
+		return true
+	}
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// storable reports whether a response with header h may be cached. Responses
+// the origin forbids storing or requires revalidating are never kept, private
+// ones and those setting cookies only when rule opts in.
+func storable(h http.Header, rule *config.CacheRule) bool {
+	private := len(h.Values("Set-Cookie")) > 0
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache":
+				return false
+			case "private":
+				private = true
+			}
+		}
+	}
+	return !private || (rule != nil && rule.CachePrivate)
+}
+
+// storedHeader copies h for a cache entry, leaving out the cookies it sets,
+// which belong to the client the response was fetched for.
+func storedHeader(h http.Header) http.Header {
+	stored := http.Header{}
+	origin.CopyHeader(stored, h)
+	stored.Del("Set-Cookie")
+	return stored
+}
+
+// tee streams the origin response to the client while keeping a copy of the
+// body for the cache, which is dropped once it outgrows the cache object
+// limit. Bodies of unknown length, such as partial segments still being
+// written, are flushed to the client chunk by chunk as they arrive.
+func tee(c *gin.Context, resp *http.Response, status string) ([]byte, bool) {
+	origin.CopyHeader(c.Writer.Header(), resp.Header)
+	c.Header(HeaderCache, status)
+	c.Status(resp.StatusCode)
+
+	var w io.Writer = c.Writer
+	if resp.ContentLength < 0 {
+		w = flushWriter{c.Writer}
+	}
+
+	buf := &cappedBuffer{max: cache.MaxObjectSize()}
+	if _, err := io.Copy(io.MultiWriter(w, buf), resp.Body); err != nil {
+		log.Printf("Failed to stream %s: %v", c.Request.URL.Path, err)
+		return nil, false
+	}
+	if buf.overflow {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
+
+// cappedBuffer accepts every write but stops buffering past max bytes
+type cappedBuffer struct {
+	bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || int64(b.Len()+len(p)) > b.max {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// stream writes the origin response to the client, starting with the part
+// of the body that was already read.
+func stream(c *gin.Context, resp *http.Response, head []byte, status string) {
+	origin.CopyHeader(c.Writer.Header(), resp.Header)
+	c.Header(HeaderCache, status)
+	c.Status(resp.StatusCode)
+	if _, err := c.Writer.Write(head); err != nil {
+		log.Printf("Failed to stream %s: %v", c.Request.URL.Path, err)
+		return
+	}
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		log.Printf("Failed to stream %s: %v", c.Request.URL.Path, err)
+	}
+}
+
+func writeEntry(c *gin.Context, entry *cache.Entry, status string) {
+	for k, vv := range entry.Header {
+		c.Writer.Header()[k] = append([]string(nil), vv...)
+	}
+	c.Header(HeaderCache, status)
+	c.Status(entry.Status)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := c.Writer.Write(entry.Body); err != nil {
+		log.Printf("Failed to write %s: %v", c.Request.URL.Path, err)
+	}
+}
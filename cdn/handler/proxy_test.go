@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+func loadSpec(t *testing.T, spec string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "spec.json")
+	if err := os.WriteFile(p, []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorable(t *testing.T) {
+	optIn := &config.CacheRule{CachePrivate: true}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		rule   *config.CacheRule
+		want   bool
+	}{
+		{"no directives", http.Header{}, nil, true},
+		{"public", http.Header{"Cache-Control": {"public, max-age=60"}}, nil, true},
+		{"private", http.Header{"Cache-Control": {"max-age=60, Private"}}, nil, false},
+		{"private fields", http.Header{"Cache-Control": {`private="Set-Cookie"`}}, nil, false},
+		{"sets a cookie", http.Header{"Set-Cookie": {"session=1"}}, nil, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, nil, false},
+		{"no-cache", http.Header{"Cache-Control": {"public", "no-cache"}}, nil, false},
+		{"private opted in", http.Header{"Cache-Control": {"private"}}, optIn, true},
+		{"cookie opted in", http.Header{"Set-Cookie": {"session=1"}}, optIn, true},
+		{"no-store opted in", http.Header{"Cache-Control": {"no-store"}}, optIn, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storable(tt.header, tt.rule); got != tt.want {
+				t.Errorf("storable(%v) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		rule   *config.CacheRule
+		want   bool
+	}{
+		{"anonymous", http.Header{}, nil, true},
+		{"authorization", http.Header{"Authorization": {"Bearer x"}}, nil, false},
+		{"cookie", http.Header{"Cookie": {"session=1"}}, &config.CacheRule{}, false},
+		{"cookie opted in", http.Header{"Cookie": {"session=1"}}, &config.CacheRule{CachePrivate: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+			if got := sharedRequest(r, tt.rule); got != tt.want {
+				t.Errorf("sharedRequest(%v) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyPrivateResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fetches := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches[r.URL.Path]++
+		switch r.URL.Path {
+		case "/private.html":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/login.html", "/shared/login.html":
+			w.Header().Set("Set-Cookie", "session=secret")
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	loadSpec(t, fmt.Sprintf(`{
+		"origin": %q,
+		"cacheRules": [
+			{"pathPattern": "/shared/*", "ttl": 60, "cachePrivate": true},
+			{"pathPattern": "/*", "ttl": 60}
+		]
+	}`, srv.URL))
+
+	tests := []struct {
+		name        string
+		path        string
+		cookie      string
+		wantCache   string
+		wantFetches int
+		// Whether the second client is sent the cookie set for the first
+		wantCookie bool
+	}{
+		{"public", "/index.html", "", CacheHit, 1, false},
+		{"private", "/private.html", "", CacheBypass, 2, false},
+		{"sets a cookie", "/login.html", "", CacheBypass, 2, true},
+		{"with credentials", "/account.html", "session=1", CacheBypass, 2, false},
+		{"opted in", "/shared/login.html", "session=1", CacheHit, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.NoRoute(Proxy)
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				w = httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+				if tt.cookie != "" {
+					req.Header.Set("Cookie", tt.cookie)
+				}
+				r.ServeHTTP(w, req)
+			}
+
+			if got := w.Header().Get(HeaderCache); got != tt.wantCache {
+				t.Errorf("second %s = %s, want %s", HeaderCache, got, tt.wantCache)
+			}
+			if fetches[tt.path] != tt.wantFetches {
+				t.Errorf("origin fetched %s %d times, want %d", tt.path, fetches[tt.path], tt.wantFetches)
+			}
+			if got := w.Header().Get("Set-Cookie") != ""; got != tt.wantCookie {
+				t.Errorf("second response sets a cookie: %v, want %v", got, tt.wantCookie)
+			}
+			if w.Body.String() != tt.path {
+				t.Errorf("second body = %q, want %q", w.Body.String(), tt.path)
+			}
+		})
+	}
+}
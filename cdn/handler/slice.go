@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/origin"
+)
+
+var errObjectChanged = errors.New("origin object changed")
+
+// serveSliced serves a large object out of fixed size slices, each cached on
+// disk under the object key, its ETag and its byte offset. Only the slices
+// covering the requested range are fetched from the origin. Slices are
+// fetched with If-Match on the ETag, so slices of different versions of the
+// object are never stitched together.
+func serveSliced(c *gin.Context, base string, rule *config.CacheRule, key string, ttl int) {
+	sliceSize := rule.SliceSize
+	metaKey := cache.Key(key, "slices")
+	fetched := map[int64][]byte{}
+
+	meta, ok := cache.Get(metaKey)
+	if !ok {
+		off := rangeStart(c.GetHeader("Range")) / sliceSize * sliceSize
+		resp, err := fetchSlice(c, base, off, sliceSize, "")
+		if err != nil {
+			log.Printf("Failed to fetch %s from origin %s: %v", c.Request.URL.Path, base, err)
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		etag := resp.Header.Get("ETag")
+		if resp.StatusCode != http.StatusPartialContent || etag == "" || strings.HasPrefix(etag, "W/") ||
+			!storable(resp.Header, rule) {
+			// Without range support or a strong validator slices can't be
+			// kept consistent, serve the object as a whole instead. Objects
+			// that may not be cached are not sliced either.
+			resp.Body.Close()
+			passThrough(c, base)
+			return
+		}
+
+		data, total, err := readSlice(resp, off, sliceSize)
+		if err != nil {
+			log.Printf("Failed to read slice of %s from origin %s: %v", c.Request.URL.Path, base, err)
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+
+		meta = &cache.Entry{
+			Status:  http.StatusOK,
+			Header:  storedHeader(resp.Header),
+			Expires: time.Now().Add(time.Duration(ttl) * time.Second),
+		}
+		meta.Header.Del("Content-Range")
+		meta.Header.Set("Content-Length", strconv.FormatInt(total, 10))
+		cache.Set(metaKey, meta)
+
+		cache.SetSlice(sliceKey(key, etag, off), data)
+		fetched[off] = data
+	}
+
+	total, _ := strconv.ParseInt(meta.Header.Get("Content-Length"), 10, 64)
+	etag := meta.Header.Get("ETag")
+
+	start, end, partial, ok := parseRange(c.GetHeader("Range"), total)
+	if !ok {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", total))
+		c.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	status := CacheHit
+	for off := start / sliceSize * sliceSize; off <= end; off += sliceSize {
+		if _, ok := fetched[off]; !ok && !cache.HasSlice(sliceKey(key, etag, off)) {
+			status = CacheMiss
+			break
+		}
+	}
+
+	for k, vv := range meta.Header {
+		c.Writer.Header()[k] = append([]string(nil), vv...)
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header(HeaderCache, status)
+	c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+	if partial {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+		c.Status(http.StatusPartialContent)
+	} else {
+		c.Status(http.StatusOK)
+	}
+	if c.Request.Method == http.MethodHead || total == 0 {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+
+	for off := start / sliceSize * sliceSize; off <= end; off += sliceSize {
+		data, ok := fetched[off]
+		if !ok {
+			data, ok = cache.GetSlice(sliceKey(key, etag, off))
+		}
+		if !ok {
+			var err error
+			if data, err = fetchAndStoreSlice(c, base, key, etag, off, sliceSize); err != nil {
+				// The response is cut short, the client notices the missing
+				// bytes and retries, getting the current version
+				if errors.Is(err, errObjectChanged) {
+					cache.Delete(metaKey)
+				}
+				log.Printf("Failed to fetch slice %d of %s: %v", off, c.Request.URL.Path, err)
+				return
+			}
+		}
+
+		lo := max(start-off, 0)
+		hi := min(end-off+1, int64(len(data)))
+		if lo >= hi {
+			log.Printf("Slice %d of %s is shorter than expected", off, c.Request.URL.Path)
+			cache.Delete(metaKey)
+			return
+		}
+		if _, err := c.Writer.Write(data[lo:hi]); err != nil {
+			return
+		}
+	}
+}
+
+func fetchAndStoreSlice(c *gin.Context, base, key, etag string, off, sliceSize int64) ([]byte, error) {
+	resp, err := fetchSlice(c, base, off, sliceSize, etag)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.Header.Get("ETag") != etag {
+		return nil, errObjectChanged
+	}
+	data, _, err := readSlice(resp, off, sliceSize)
+	if err != nil {
+		return nil, err
+	}
+	cache.SetSlice(sliceKey(key, etag, off), data)
+	return data, nil
+}
+
+// fetchSlice requests the slice at off from the origin, conditional on etag
+// when it is known.
+func fetchSlice(c *gin.Context, base string, off, sliceSize int64, etag string) (*http.Response, error) {
+	req := c.Request.Clone(c.Request.Context())
+	req.Method = http.MethodGet
+	for _, h := range []string{"If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+sliceSize-1))
+	// Offsets must refer to the stored representation
+	req.Header.Set("Accept-Encoding", "identity")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	return origin.Fetch(base, req)
+}
+
+// readSlice reads a 206 response for the slice at off, returning its bytes
+// and the full object size.
+func readSlice(resp *http.Response, off, sliceSize int64) ([]byte, int64, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var first, last, total int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err != nil {
+		return nil, 0, fmt.Errorf("invalid Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	if first != off || last < first || last-first+1 > sliceSize {
+		return nil, 0, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, sliceSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(data)) != last-first+1 {
+		return nil, 0, fmt.Errorf("got %d bytes for range %d-%d", len(data), first, last)
+	}
+	return data, total, nil
+}
+
+// passThrough forwards the request to the origin and streams the response
+// without caching it.
+func passThrough(c *gin.Context, base string) {
+	resp, err := origin.Fetch(base, c.Request)
+	if err != nil {
+		log.Printf("Failed to fetch %s from origin %s: %v", c.Request.URL.Path, base, err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	stream(c, resp, nil, CacheBypass)
+}
+
+func sliceKey(key, etag string, off int64) string {
+	return cache.Key(key, "slice", etag, strconv.FormatInt(off, 10))
+}
+
+// rangeStart returns the first offset of a "bytes=N-..." range, 0 otherwise.
+func rangeStart(header string) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parseRange resolves a single range header against an object of total
+// bytes, returning the inclusive byte span to serve. Multiple or malformed
+// ranges are ignored and the whole object is served, as RFC 9110 allows.
+func parseRange(header string, total int64) (start, end int64, partial, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") || total == 0 {
+		return 0, total - 1, false, true
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, total - 1, false, true
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, false
+		}
+		return max(total-n, 0), total - 1, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, total - 1, false, true
+	}
+	if start >= total {
+		return 0, 0, false, false
+	}
+	end = total - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, total - 1, false, true
+		}
+		end = min(end, total-1)
+	}
+	return start, end, true, true
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		total       int64
+		wantStart   int64
+		wantEnd     int64
+		wantPartial bool
+		wantOK      bool
+	}{
+		{"no range", "", 1000, 0, 999, false, true},
+		{"closed range", "bytes=100-199", 1000, 100, 199, true, true},
+		{"open range", "bytes=900-", 1000, 900, 999, true, true},
+		{"end past the object", "bytes=900-5000", 1000, 900, 999, true, true},
+		{"suffix", "bytes=-100", 1000, 900, 999, true, true},
+		{"suffix longer than the object", "bytes=-5000", 1000, 0, 999, true, true},
+		{"zero suffix", "bytes=-0", 1000, 0, 0, false, false},
+		{"start past the object", "bytes=1000-", 1000, 0, 0, false, false},
+		{"multiple ranges", "bytes=0-1,5-6", 1000, 0, 999, false, true},
+		{"other unit", "items=0-1", 1000, 0, 999, false, true},
+		{"malformed", "bytes=abc", 1000, 0, 999, false, true},
+		{"end before start", "bytes=200-100", 1000, 0, 999, false, true},
+		{"empty object", "bytes=0-10", 0, 0, -1, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, partial, ok := parseRange(tt.header, tt.total)
+			if start != tt.wantStart || end != tt.wantEnd || partial != tt.wantPartial || ok != tt.wantOK {
+				t.Errorf("parseRange(%q, %d) = %d, %d, %v, %v, want %d, %d, %v, %v",
+					tt.header, tt.total, start, end, partial, ok, tt.wantStart, tt.wantEnd, tt.wantPartial, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRangeStart(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"", 0},
+		{"bytes=5000-", 5000},
+		{"bytes=5000-5999", 5000},
+		{"bytes=-100", 0},
+		{"bytes=0-1,5000-5001", 0},
+		{"bytes=abc-", 0},
+	}
+	for _, tt := range tests {
+		if got := rangeStart(tt.header); got != tt.want {
+			t.Errorf("rangeStart(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSliceKey(t *testing.T) {
+	key := sliceKey("cdn.example.com|/video.mp4", `"v1"`, 0)
+	tests := []struct {
+		name string
+		etag string
+		off  int64
+	}{
+		{"other version", `"v2"`, 0},
+		{"other offset", `"v1"`, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sliceKey("cdn.example.com|/video.mp4", tt.etag, tt.off); got == key {
+				t.Errorf("sliceKey(%s, %d) = %q, same as the key of the first slice of %s", tt.etag, tt.off, got, `"v1"`)
+			}
+		})
+	}
+}
+
+func TestReadSlice(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentRange string
+		body         string
+		off          int64
+		wantTotal    int64
+		wantErr      bool
+	}{
+		{"full slice", http.StatusPartialContent, "bytes 4-7/10", "4567", 4, 10, false},
+		{"last slice", http.StatusPartialContent, "bytes 8-9/10", "89", 8, 10, false},
+		{"not partial", http.StatusOK, "", "0123456789", 0, 0, true},
+		{"other offset", http.StatusPartialContent, "bytes 0-3/10", "0123", 4, 0, true},
+		{"larger than a slice", http.StatusPartialContent, "bytes 4-8/10", "45678", 4, 0, true},
+		{"short body", http.StatusPartialContent, "bytes 4-7/10", "45", 4, 0, true},
+		{"malformed Content-Range", http.StatusPartialContent, "bytes */10", "4567", 4, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{"Content-Range": {tt.contentRange}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			data, total, err := readSlice(resp, tt.off, 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSlice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (string(data) != tt.body || total != tt.wantTotal) {
+				t.Errorf("readSlice() = %q, %d, want %q, %d", data, total, tt.body, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestFetchAndStoreSliceObjectChanged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		status int
+		etag   string
+	}{
+		{"precondition failed", http.StatusPreconditionFailed, ""},
+		{"other version", http.StatusPartialContent, `"v2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ifMatch, rangeHeader string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ifMatch, rangeHeader = r.Header.Get("If-Match"), r.Header.Get("Range")
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+					w.Header().Set("Content-Range", "bytes 4-7/10")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+			c.Request.Header.Set("If-None-Match", `"v0"`)
+
+			_, err := fetchAndStoreSlice(c, srv.URL, "cdn.example.com|/video.mp4", `"v1"`, 4, 4)
+			if !errors.Is(err, errObjectChanged) {
+				t.Errorf("fetchAndStoreSlice() error = %v, want %v", err, errObjectChanged)
+			}
+			if ifMatch != `"v1"` || rangeHeader != "bytes=4-7" {
+				t.Errorf("origin got If-Match %q and Range %q, want %q and %q", ifMatch, rangeHeader, `"v1"`, "bytes=4-7")
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+	"github.com/benauro/kube-cdn/cdn/stats"
+)
+
+// Stats serves the counters of the edge for the controller to aggregate.
+func Stats(c *gin.Context) {
+	snapshot := stats.Current()
+	snapshot.CacheDiskUsed, snapshot.CacheDiskCapacity = cache.DiskUsage()
+	c.JSON(http.StatusOK, snapshot)
+}
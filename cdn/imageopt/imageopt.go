@@ -0,0 +1,269 @@
+package imageopt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+
+	// Decoders for formats accepted as source images only
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+const (
+	// Decoding allocates about four bytes per pixel, this caps a single
+	// transform at roughly 100 MiB
+	maxSourcePixels = 25_000_000
+
+	defaultMaxDimension  = 2048
+	defaultQuality       = 80
+	defaultMaxSourceSize = 20 << 20
+
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+var (
+	ErrNoTransform = errors.New("no image transform requested")
+
+	// Bounds the CPU spent on transforms regardless of the request rate
+	slots = make(chan struct{}, runtime.NumCPU())
+
+	// Query parameters consumed by the edge, never forwarded to the origin
+	queryParams = []string{"w", "h", "fit", "q", "fmt", "preset"}
+)
+
+// Options is a normalized image transform
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+	// Output format, empty keeps the source format when it can be encoded
+	Format string
+}
+
+// String is stable for equal options, so it can be part of a cache key.
+func (o Options) String() string {
+	return fmt.Sprintf("w=%d,h=%d,fit=%s,q=%d,fmt=%s", o.Width, o.Height, o.Fit, o.Quality, o.Format)
+}
+
+// ParseOptions reads the transform requested through the w, h, fit, q, fmt
+// and preset query parameters and checks it against the route limits. The
+// output format is negotiated against accept when not requested explicitly.
+func ParseOptions(query url.Values, accept string, cfg *config.ImageOptimization) (Options, error) {
+	var opts Options
+	requested := false
+	for _, p := range queryParams {
+		if query.Has(p) {
+			requested = true
+		}
+	}
+	if !requested {
+		return opts, ErrNoTransform
+	}
+
+	if name := query.Get("preset"); name != "" {
+		preset := cfg.Preset(name)
+		if preset == nil {
+			return opts, fmt.Errorf("unknown preset %q", name)
+		}
+		opts = Options{Width: preset.Width, Height: preset.Height, Fit: preset.Fit, Quality: preset.Quality, Format: preset.Format}
+	} else {
+		if cfg.PresetsOnly {
+			return opts, errors.New("only presets are allowed")
+		}
+		var err error
+		if opts.Width, err = intParam(query, "w"); err != nil {
+			return opts, err
+		}
+		if opts.Height, err = intParam(query, "h"); err != nil {
+			return opts, err
+		}
+		if opts.Quality, err = intParam(query, "q"); err != nil {
+			return opts, err
+		}
+		opts.Fit = query.Get("fit")
+		opts.Format = query.Get("fmt")
+	}
+
+	maxWidth, maxHeight := cfg.MaxWidth, cfg.MaxHeight
+	if maxWidth <= 0 {
+		maxWidth = defaultMaxDimension
+	}
+	if maxHeight <= 0 {
+		maxHeight = defaultMaxDimension
+	}
+	if opts.Width < 0 || opts.Width > maxWidth || opts.Height < 0 || opts.Height > maxHeight {
+		return opts, fmt.Errorf("dimensions must be within %dx%d", maxWidth, maxHeight)
+	}
+
+	switch opts.Fit {
+	case "":
+		opts.Fit = FitContain
+	case FitContain, FitCover, FitFill:
+	default:
+		return opts, fmt.Errorf("unknown fit %q", opts.Fit)
+	}
+
+	switch {
+	case opts.Quality == 0 && cfg.Quality > 0:
+		opts.Quality = cfg.Quality
+	case opts.Quality == 0:
+		opts.Quality = defaultQuality
+	case opts.Quality < 0 || opts.Quality > 100:
+		return opts, errors.New("quality must be within 1-100")
+	}
+
+	switch opts.Format {
+	case "", "auto":
+		opts.Format = negotiate(accept)
+	case "jpg":
+		opts.Format = FormatJPEG
+	case FormatJPEG, FormatPNG:
+	default:
+		return opts, fmt.Errorf("unsupported format %q", opts.Format)
+	}
+
+	return opts, nil
+}
+
+// StripQuery removes the transform parameters from query.
+func StripQuery(query url.Values) {
+	for _, p := range queryParams {
+		query.Del(p)
+	}
+}
+
+// MaxSourceSize returns the largest source image the route accepts, in bytes.
+func MaxSourceSize(cfg *config.ImageOptimization) int64 {
+	if cfg.MaxSourceSize > 0 {
+		return cfg.MaxSourceSize
+	}
+	return defaultMaxSourceSize
+}
+
+// negotiate picks the output format when the client did not ask for one.
+// An empty result keeps the source format if it can be encoded. WebP is
+// accepted as input only, as no pure Go encoder exists for it.
+func negotiate(accept string) string {
+	if accept == "" || strings.Contains(accept, "image/*") || strings.Contains(accept, "*/*") {
+		return ""
+	}
+	if strings.Contains(accept, "image/png") && !strings.Contains(accept, "image/jpeg") {
+		return FormatPNG
+	}
+	if strings.Contains(accept, "image/jpeg") && !strings.Contains(accept, "image/png") {
+		return FormatJPEG
+	}
+	return ""
+}
+
+func intParam(query url.Values, name string) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// Transform decodes src, applies opts and encodes the result, returning it
+// with its content type.
+func Transform(src []byte, opts Options) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, "", fmt.Errorf("source image of %dx%d is too large", cfg.Width, cfg.Height)
+	}
+
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", err
+	}
+	img = resize(img, opts)
+
+	if opts.Format == "" {
+		opts.Format = FormatJPEG
+		if format == FormatPNG || format == "gif" || format == "webp" {
+			// Formats which may carry transparency
+			opts.Format = FormatPNG
+		}
+	}
+
+	var out bytes.Buffer
+	switch opts.Format {
+	case FormatPNG:
+		err = png.Encode(&out, img)
+	default:
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: opts.Quality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), "image/" + opts.Format, nil
+}
+
+func resize(img image.Image, opts Options) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	if srcW == 0 || srcH == 0 || (opts.Width == 0 && opts.Height == 0) {
+		return img
+	}
+
+	w, h := opts.Width, opts.Height
+	switch {
+	case w == 0:
+		w = srcW * h / srcH
+	case h == 0:
+		h = srcH * w / srcW
+	}
+	w, h = max(w, 1), max(h, 1)
+
+	src := b
+	switch opts.Fit {
+	case FitContain:
+		// Scale to fit inside the box, keeping the aspect ratio
+		if srcW*h > srcH*w {
+			h = max(srcH*w/srcW, 1)
+		} else {
+			w = max(srcW*h/srcH, 1)
+		}
+	case FitCover:
+		// Scale to fill the box, cropping the overflow around the center
+		if srcW*h > srcH*w {
+			cropW := srcH * w / h
+			src = image.Rect(b.Min.X+(srcW-cropW)/2, b.Min.Y, b.Min.X+(srcW+cropW)/2, b.Max.Y)
+		} else {
+			cropH := srcW * h / w
+			src = image.Rect(b.Min.X, b.Min.Y+(srcH-cropH)/2, b.Max.X, b.Min.Y+(srcH+cropH)/2)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,92 @@
+package imageopt
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+func TestParseOptions(t *testing.T) {
+	route := &config.ImageOptimization{
+		MaxWidth: 1000,
+		Presets: []config.ImagePreset{
+			{Name: "thumb", Width: 200, Height: 200, Fit: FitCover, Format: FormatJPEG},
+		},
+	}
+	presetsOnly := &config.ImageOptimization{
+		Quality:     60,
+		PresetsOnly: true,
+		Presets:     route.Presets,
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		accept  string
+		cfg     *config.ImageOptimization
+		want    Options
+		wantErr bool
+	}{
+		{name: "width", query: "w=300", cfg: route, want: Options{Width: 300, Fit: FitContain, Quality: defaultQuality}},
+		{name: "all parameters", query: "w=300&h=100&fit=fill&q=50&fmt=png", cfg: route, want: Options{Width: 300, Height: 100, Fit: FitFill, Quality: 50, Format: FormatPNG}},
+		{name: "jpg alias", query: "w=300&fmt=jpg", cfg: route, want: Options{Width: 300, Fit: FitContain, Quality: defaultQuality, Format: FormatJPEG}},
+		{name: "negotiated png", query: "w=300", accept: "image/png", cfg: route, want: Options{Width: 300, Fit: FitContain, Quality: defaultQuality, Format: FormatPNG}},
+		{name: "wildcard accept keeps the source format", query: "w=300&fmt=auto", accept: "image/webp,image/*", cfg: route, want: Options{Width: 300, Fit: FitContain, Quality: defaultQuality}},
+		{name: "preset", query: "preset=thumb&w=900", cfg: route, want: Options{Width: 200, Height: 200, Fit: FitCover, Quality: defaultQuality, Format: FormatJPEG}},
+		{name: "preset with route quality", query: "preset=thumb", cfg: presetsOnly, want: Options{Width: 200, Height: 200, Fit: FitCover, Quality: 60, Format: FormatJPEG}},
+		{name: "height within the default limit", query: "h=2048", cfg: route, want: Options{Height: 2048, Fit: FitContain, Quality: defaultQuality}},
+		{name: "width over the route limit", query: "w=1001", cfg: route, wantErr: true},
+		{name: "height over the default limit", query: "h=2049", cfg: route, wantErr: true},
+		{name: "negative width", query: "w=-1", cfg: route, wantErr: true},
+		{name: "invalid width", query: "w=abc", cfg: route, wantErr: true},
+		{name: "unknown fit", query: "w=300&fit=stretch", cfg: route, wantErr: true},
+		{name: "quality out of range", query: "q=101", cfg: route, wantErr: true},
+		{name: "unsupported format", query: "fmt=webp", cfg: route, wantErr: true},
+		{name: "unknown preset", query: "preset=hero", cfg: route, wantErr: true},
+		{name: "presets only", query: "w=300", cfg: presetsOnly, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseOptions(query, tt.accept, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOptions(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseOptions(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOptionsNoTransform(t *testing.T) {
+	query := url.Values{"v": {"2"}}
+	if _, err := ParseOptions(query, "", &config.ImageOptimization{}); !errors.Is(err, ErrNoTransform) {
+		t.Errorf("ParseOptions() error = %v, want %v", err, ErrNoTransform)
+	}
+}
+
+func TestOptionsString(t *testing.T) {
+	a := Options{Width: 300, Fit: FitContain, Quality: 80}
+	b := a
+	if a.String() != b.String() {
+		t.Errorf("String() = %q and %q for equal options", a.String(), b.String())
+	}
+	b.Format = FormatPNG
+	if a.String() == b.String() {
+		t.Errorf("String() = %q for different options", a.String())
+	}
+}
+
+func TestStripQuery(t *testing.T) {
+	query := url.Values{"w": {"300"}, "preset": {"thumb"}, "fmt": {"png"}, "v": {"2"}}
+	StripQuery(query)
+	if got := query.Encode(); got != "v=2" {
+		t.Errorf("StripQuery() left %q, want %q", got, "v=2")
+	}
+}
@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/benauro/kube-cdn/cdn/certs"
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/geoip"
 	"github.com/benauro/kube-cdn/cdn/handler"
 	"github.com/benauro/kube-cdn/cdn/logger"
 	"github.com/benauro/kube-cdn/cdn/middleware"
@@ -15,10 +28,32 @@ func init() {
 }
 
 func main() {
+	config.Watch(config.Path(), 10*time.Second, geoip.Sync)
+
 	r := gin.New()
+	// gin trusts X-Forwarded-For from any peer by default, which would let
+	// clients pick the address geo restrictions apply to
+	if err := r.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		log.Fatal(err)
+	}
 	r.Use(gin.Recovery())
+
+	// Registered ahead of the middlewares, so health checks and ACME
+	// challenges are neither logged nor subject to geo restrictions and
+	// redirects
+	r.GET("/healthz", handler.Health)
+	r.GET("/.well-known/acme-challenge/:token", handler.ACMEChallenge)
+
+	r.Use(middleware.StatsMiddleware)
+
+	r.Use(middleware.RequestIDMiddleware)
+	r.Use(middleware.ResponseHooksMiddleware)
 	r.Use(gin.LoggerWithFormatter(logger.Format))
 	r.Use(middleware.RequestLoggerMiddleware)
+	r.Use(middleware.GeoRestrictionMiddleware)
+	r.Use(middleware.CORSMiddleware)
+	r.Use(middleware.RedirectRewriteMiddleware)
+	r.Use(middleware.HeaderRulesMiddleware)
 
 	auth := r.Group("/", gin.BasicAuth(gin.Accounts{"root": "123"}))
 	{
@@ -27,35 +62,93 @@ func main() {
 
 	r.GET("/cdn/media/:mediaID", handler.GetMedia)
 
-	log.Printf("Start serving at: %v", ":8080")
-	r.Run(":8080")
+	// Everything else is served from the cache or the origin
+	r.NoRoute(handler.Proxy)
+
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	servers := []*http.Server{srv}
+
+	// TLS is terminated here too when certificates are mounted, for
+	// deployments without an ingress controller
+	if _, err := os.Stat(config.TLSDir()); err == nil {
+		certs.Watch(config.TLSDir(), 10*time.Second)
+		tlsSrv := &http.Server{Addr: tlsAddr(), Handler: r, TLSConfig: certs.TLSConfig()}
+		servers = append(servers, tlsSrv)
+		go func() {
+			log.Printf("Start serving TLS at: %v", tlsSrv.Addr)
+			if err := tlsSrv.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		drainOnSignal(servers...)
+		close(drained)
+	}()
+	go serveStats()
+
+	log.Printf("Start serving at: %v", srv.Addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	// ListenAndServe returns as soon as the shutdown starts
+	<-drained
+}
+
+// serveStats serves the counters of the edge and its Prometheus metrics on
+// CDN_STATS_ADDR, apart from the traffic it serves.
+func serveStats() {
+	addr := os.Getenv("CDN_STATS_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/stats", handler.Stats)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	log.Printf("Start serving stats at: %v", addr)
+	if err := http.ListenAndServe(addr, r); err != nil {
+		log.Printf("Failed to serve stats: %v", err)
+	}
+}
+
+func tlsAddr() string {
+	if addr := os.Getenv("CDN_TLS_ADDR"); addr != "" {
+		return addr
+	}
+	return ":8443"
 }
 
-func GetContentType(ext string) string {
-	switch ext {
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".png":
-		return "image/png"
-	case ".gif":
-		return "image/gif"
-	case ".svg":
-		return "image/svg+xml"
-	case ".mp4":
-		return "video/mp4"
-	case ".mp3":
-		return "audio/mpeg"
-	case ".wav":
-		return "audio/wav"
-	case ".pdf":
-		return "application/pdf"
-	case ".ps":
-		return "application/postscript"
-	default:
-		return ""
+// drainOnSignal fails the health checks on SIGTERM, gives load balancers
+// and the DNS layer CDN_DRAIN_SECONDS to stop sending traffic, then lets
+// in-flight requests finish before exiting.
+func drainOnSignal(servers ...*http.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	<-sig
+
+	delay := 10 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("CDN_DRAIN_SECONDS")); err == nil && v >= 0 {
+		delay = time.Duration(v) * time.Second
+	}
+	log.Printf("Draining for %v", delay)
+	handler.Drain()
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("Failed to shut down %s gracefully: %v", srv.Addr, err)
+			}
+		}(srv)
 	}
+	wg.Wait()
 }
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/benauro/kube-cdn/cdn/cache"
+)
+
+// Labels of the request metrics
+var requestLabels = []string{"code_class", "cache_status", "route", "method"}
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cdn_requests_total",
+		Help: "Requests served by the edge.",
+	}, requestLabels)
+	responseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cdn_response_bytes_total",
+		Help: "Bytes of the response bodies sent by the edge.",
+	}, requestLabels)
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cdn_request_duration_seconds",
+		Help:    "Time the edge took to serve a request.",
+		Buckets: prometheus.DefBuckets,
+	}, requestLabels)
+
+	originDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cdn_origin_fetch_duration_seconds",
+		Help:    "Time the origin took to answer a fetch, up to the response headers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code_class"})
+	originErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cdn_origin_fetch_errors_total",
+		Help: "Origin fetches which got no response or a server error.",
+	})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cdn_redis_errors_total",
+		Help: "Redis commands which failed.",
+	}, []string{"command"})
+)
+
+func init() {
+	tiers := map[string]func() cache.TierStats{
+		"memory": cache.MemoryStats,
+		"disk":   cache.DiskStats,
+	}
+	for tier, stats := range tiers {
+		stats := stats
+		labels := prometheus.Labels{"tier": tier}
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cdn_cache_objects",
+			Help:        "Objects, or slices on disk, held by a cache tier.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Objects) })
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cdn_cache_bytes",
+			Help:        "Bytes held by a cache tier.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Bytes) })
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cdn_cache_capacity_bytes",
+			Help:        "Bytes a cache tier holds at most.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Capacity) })
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "cdn_cache_evictions_total",
+			Help:        "Entries a cache tier evicted to stay within its capacity.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Evictions) })
+	}
+}
+
+// CodeClass returns the class of an HTTP status, such as 2xx.
+func CodeClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// ObserveRequest records a response served by the edge. cacheStatus is its
+// X-Cache header, route the route or cache rule pattern it matched.
+func ObserveRequest(status int, cacheStatus, route, method string, size int64, duration time.Duration) {
+	if cacheStatus == "" {
+		cacheStatus = "NONE"
+	}
+	values := []string{CodeClass(status), cacheStatus, route, method}
+	requests.WithLabelValues(values...).Inc()
+	responseBytes.WithLabelValues(values...).Add(float64(size))
+	requestDuration.WithLabelValues(values...).Observe(duration.Seconds())
+}
+
+// ObserveOrigin records a fetch from the origin, which answered status, or
+// failed with err.
+func ObserveOrigin(status int, err error, duration time.Duration) {
+	class := "error"
+	if err == nil {
+		class = CodeClass(status)
+	}
+	originDuration.WithLabelValues(class).Observe(duration.Seconds())
+	if err != nil || status >= 500 {
+		originErrors.Inc()
+	}
+}
+
+// RedisError records a failed Redis command.
+func RedisError(command string) {
+	redisErrors.WithLabelValues(command).Inc()
+}
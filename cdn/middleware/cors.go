@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+// CORSMiddleware enforces the CORS policy of the CDN. Preflight requests are
+// answered at the edge, other cross-origin responses get the
+// Access-Control-* headers of the policy in place of the origin's own.
+func CORSMiddleware(c *gin.Context) {
+	policy := config.Current().CORS
+	requestOrigin := c.GetHeader("Origin")
+	if policy == nil || requestOrigin == "" {
+		c.Next()
+		return
+	}
+
+	allowed := policy.AllowsOrigin(requestOrigin)
+
+	if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if allowed && policy.AllowsMethod(c.GetHeader("Access-Control-Request-Method")) {
+			setAllowOrigin(h, policy, requestOrigin)
+			h.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods(), ", "))
+			if headers := policy.AllowedRequestHeaders(c.GetHeader("Access-Control-Request-Headers")); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			if policy.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	OnWriteHeader(c, func() {
+		h := c.Writer.Header()
+		for k := range h {
+			if strings.HasPrefix(k, "Access-Control-") {
+				delete(h, k)
+			}
+		}
+		if !hasVary(h, "Origin") {
+			h.Add("Vary", "Origin")
+		}
+		if !allowed {
+			return
+		}
+		setAllowOrigin(h, policy, requestOrigin)
+		if len(policy.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+	})
+
+	c.Next()
+}
+
+// setAllowOrigin answers "*" when the policy allows any origin, never with
+// credentials, so that no site can read credentialed responses. Otherwise
+// it echoes the request origin, as browsers reject "*" on credentialed
+// requests.
+func setAllowOrigin(h http.Header, policy *config.CORSPolicy, origin string) {
+	if policy.AllowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func hasVary(h http.Header, field string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return true
+			}
+		}
+	}
+	return false
+}
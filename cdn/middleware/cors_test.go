@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+// loadSpec makes spec, in the JSON rendered by the controller, the current
+// edge config.
+func loadSpec(t *testing.T, spec string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "spec.json")
+	if err := os.WriteFile(p, []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name            string
+		cors            string
+		method          string
+		origin          string
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{
+			name:       "any origin answers a literal wildcard",
+			cors:       `{"allowedOrigins": ["*"]}`,
+			method:     http.MethodGet,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusOK,
+			wantOrigin: "*",
+		},
+		{
+			name:       "any origin never allows credentials",
+			cors:       `{"allowedOrigins": ["*"], "allowCredentials": true}`,
+			method:     http.MethodGet,
+			origin:     "https://evil.example",
+			wantStatus: http.StatusOK,
+			wantOrigin: "*",
+		},
+		{
+			name:            "listed origin is echoed with credentials",
+			cors:            `{"allowedOrigins": ["https://*.example.com"], "allowCredentials": true}`,
+			method:          http.MethodGet,
+			origin:          "https://app.example.com",
+			wantStatus:      http.StatusOK,
+			wantOrigin:      "https://app.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:       "other origin gets no CORS headers",
+			cors:       `{"allowedOrigins": ["https://app.example.com"], "allowCredentials": true}`,
+			method:     http.MethodGet,
+			origin:     "https://evil.example",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "preflight is answered at the edge",
+			cors:       `{"allowedOrigins": ["https://app.example.com"]}`,
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusNoContent,
+			wantOrigin: "https://app.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadSpec(t, `{"cors": `+tt.cors+`}`)
+
+			r := gin.New()
+			r.Use(ResponseHooksMiddleware, CORSMiddleware)
+			r.GET("/", func(c *gin.Context) {
+				// The origin's own CORS headers are replaced
+				c.Header("Access-Control-Allow-Origin", "https://origin.example")
+				c.Header("Access-Control-Allow-Credentials", "true")
+				c.String(http.StatusOK, "ok")
+			})
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/geoip"
+)
+
+const (
+	// HeaderClientCountry carries the resolved client country to the origin
+	HeaderClientCountry = "X-Client-Country"
+
+	locationKey = "kube-cdn/location"
+)
+
+// ClientLocation returns the location resolved by GeoRestrictionMiddleware.
+func ClientLocation(c *gin.Context) geoip.Location {
+	loc, _ := c.Get(locationKey)
+	l, _ := loc.(geoip.Location)
+	return l
+}
+
+func GeoRestrictionMiddleware(c *gin.Context) {
+	loc := geoip.Lookup(net.ParseIP(c.ClientIP()))
+	c.Set(locationKey, loc)
+
+	// Never trust a country sent by the client itself
+	c.Request.Header.Del(HeaderClientCountry)
+	if loc.Country != "" {
+		c.Request.Header.Set(HeaderClientCountry, loc.Country)
+	}
+
+	if g := config.Current().GeoRestrictions; g != nil && !g.Allowed(loc.Country) {
+		if g.RedirectURL != "" {
+			c.Redirect(http.StatusFound, g.RedirectURL)
+			c.Abort()
+			return
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+var variablePattern = regexp.MustCompile(`\$\{([a-z_]+)\}`)
+
+// HeaderRulesMiddleware applies the request header rules of the matching
+// route before the request is forwarded, and its response header rules right
+// before the response headers are sent.
+func HeaderRulesMiddleware(c *gin.Context) {
+	route := config.Current().RouteFor(c.Request.URL.Path)
+	if route == nil {
+		c.Next()
+		return
+	}
+
+	if route.RequestHeaders != nil {
+		applyHeaderRules(c, c.Request.Header, route.RequestHeaders)
+	}
+	if rules := route.ResponseHeaders; rules != nil {
+		OnWriteHeader(c, func() {
+			applyHeaderRules(c, c.Writer.Header(), rules)
+		})
+	}
+
+	c.Next()
+}
+
+func applyHeaderRules(c *gin.Context, h http.Header, rules *config.HeaderRules) {
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for _, hv := range rules.Set {
+		h.Set(hv.Name, expandVariables(c, hv.Value))
+	}
+	for _, hv := range rules.Add {
+		h.Add(hv.Name, expandVariables(c, hv.Value))
+	}
+}
+
+// expandVariables replaces ${name} references in value, unknown variables
+// expand to the empty string.
+func expandVariables(c *gin.Context, value string) string {
+	return variablePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		switch variablePattern.FindStringSubmatch(ref)[1] {
+		case "client_ip":
+			return c.ClientIP()
+		case "country":
+			return ClientLocation(c).Country
+		case "cache_status":
+			return c.Writer.Header().Get("X-Cache")
+		case "pod_name":
+			return os.Getenv("POD_NAME")
+		case "request_id":
+			return c.Request.Header.Get(HeaderRequestID)
+		case "host":
+			return c.Request.Host
+		case "method":
+			return c.Request.Method
+		case "path":
+			return c.Request.URL.Path
+		default:
+			return ""
+		}
+	})
+}
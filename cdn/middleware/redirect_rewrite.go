@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+)
+
+const originKey = "kube-cdn/origin"
+
+// RewriteOrigin returns the origin chosen by a rewrite rule, or "" to use the
+// default one.
+func RewriteOrigin(c *gin.Context) string {
+	return c.GetString(originKey)
+}
+
+// RedirectRewriteMiddleware answers requests matching a redirect rule and
+// maps requests matching a rewrite rule to their origin path.
+func RedirectRewriteMiddleware(c *gin.Context) {
+	spec := config.Current()
+	urlPath := c.Request.URL.Path
+
+	if target, status, rule := spec.Redirect(requestScheme(c), c.Request.Host, urlPath); rule != nil {
+		if !rule.DropQuery && c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(status, target)
+		c.Abort()
+		return
+	}
+
+	if rewritten, origin, ok := spec.Rewrite(urlPath); ok {
+		// A query in the replacement is merged into the request query, which
+		// allows path driven parameters such as image transforms
+		rewritten, rawQuery, _ := strings.Cut(rewritten, "?")
+		if rawQuery != "" {
+			query := c.Request.URL.Query()
+			if extra, err := url.ParseQuery(rawQuery); err == nil {
+				for k, vv := range extra {
+					query[k] = vv
+				}
+			}
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Request.URL.Path = rewritten
+		c.Request.URL.RawPath = ""
+		if origin != "" {
+			c.Set(originKey, origin)
+		}
+	}
+
+	c.Next()
+}
+
+// requestScheme returns the scheme the client used, trusting the ingress to
+// report it when TLS is terminated in front of the edge. Like X-Forwarded-For,
+// X-Forwarded-Proto is only read from the trusted proxies, clients would
+// otherwise skip HTTPS redirects by sending it.
+func requestScheme(c *gin.Context) string {
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" && config.IsTrustedProxy(net.ParseIP(c.RemoteIP())) {
+		return proto
+	}
+	return "http"
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedirectRewriteMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	loadSpec(t, `{
+		"redirects": [
+			{"match": "^/old/(.*)$", "target": "/new/$1"},
+			{"match": "^/tmp/(.*)$", "target": "/$1", "statusCode": 302, "dropQuery": true}
+		],
+		"rewrites": [
+			{"match": "^/thumb/(.*)$", "replacement": "/images/$1?w=200"},
+			{"match": "^/api/(.*)$", "replacement": "/$1", "origin": "https://api.example.com"}
+		]
+	}`)
+
+	tests := []struct {
+		name         string
+		target       string
+		wantStatus   int
+		wantLocation string
+		wantURI      string
+		wantOrigin   string
+	}{
+		{"redirect keeps the query", "/old/a?x=1", http.StatusMovedPermanently, "/new/a?x=1", "", ""},
+		{"redirect drops the query", "/tmp/a?x=1", http.StatusFound, "/a", "", ""},
+		{"rewrite merges the query", "/thumb/cat.png?w=800&v=2", http.StatusOK, "", "/images/cat.png?v=2&w=200", ""},
+		{"rewrite picks the origin", "/api/users?page=2", http.StatusOK, "", "/users?page=2", "https://api.example.com"},
+		{"untouched", "/index.html?x=1", http.StatusOK, "", "/index.html?x=1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotURI, gotOrigin string
+			r := gin.New()
+			r.Use(RedirectRewriteMiddleware)
+			r.NoRoute(func(c *gin.Context) {
+				gotURI, gotOrigin = c.Request.URL.RequestURI(), RewriteOrigin(c)
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if gotURI != tt.wantURI || gotOrigin != tt.wantOrigin {
+				t.Errorf("origin request = %q from %q, want %q from %q", gotURI, gotOrigin, tt.wantURI, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+func TestRedirectScheme(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CDN_TRUSTED_PROXIES", "10.0.0.0/8")
+	loadSpec(t, `{"redirects": [{"match": "^/.*$", "target": "https://${host}$0", "scheme": "http"}]}`)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		wantStatus int
+	}{
+		{"plain http", "203.0.113.1:1234", "", http.StatusMovedPermanently},
+		{"https through a trusted proxy", "10.0.0.1:1234", "https", http.StatusOK},
+		{"http through a trusted proxy", "10.0.0.1:1234", "http", http.StatusMovedPermanently},
+		{"forwarded proto from a client", "203.0.113.1:1234", "https", http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(RedirectRewriteMiddleware)
+			r.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "http://cdn.example.com/index.html", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
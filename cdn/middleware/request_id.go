@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderRequestID identifies a request across the edge and the origin
+const HeaderRequestID = "X-Request-ID"
+
+// RequestIDMiddleware keeps the request ID sent by the client or generates
+// one, and echoes it on the response.
+func RequestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(HeaderRequestID)
+	if id == "" || len(id) > 128 {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+		c.Request.Header.Set(HeaderRequestID, id)
+	}
+	c.Header(HeaderRequestID, id)
+
+	c.Next()
+}
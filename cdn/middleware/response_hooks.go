@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const hooksKey = "kube-cdn/response-hooks"
+
+// OnWriteHeader registers fn to run right before the response status line and
+// headers are sent, once the handler has set all of its headers. Hooks run in
+// registration order, so later middleware gets the final say.
+func OnWriteHeader(c *gin.Context, fn func()) {
+	if w, ok := c.Get(hooksKey); ok {
+		hw := w.(*hookWriter)
+		hw.hooks = append(hw.hooks, fn)
+	}
+}
+
+// ResponseHooksMiddleware enables OnWriteHeader for the rest of the chain.
+func ResponseHooksMiddleware(c *gin.Context) {
+	w := &hookWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Set(hooksKey, w)
+
+	c.Next()
+
+	// Gin flushes headers of bodyless responses through its own writer,
+	// which would bypass the hooks
+	w.WriteHeaderNow()
+}
+
+type hookWriter struct {
+	gin.ResponseWriter
+	hooks []func()
+	done  bool
+}
+
+func (w *hookWriter) run() {
+	if w.done || w.ResponseWriter.Written() {
+		return
+	}
+	w.done = true
+	for _, fn := range w.hooks {
+		fn()
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	w.run()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *hookWriter) Write(data []byte) (int, error) {
+	w.run()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	w.run()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hookWriter) Flush() {
+	w.run()
+	w.ResponseWriter.Flush()
+}
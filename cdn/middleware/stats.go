@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/benauro/kube-cdn/cdn/config"
+	"github.com/benauro/kube-cdn/cdn/metrics"
+	"github.com/benauro/kube-cdn/cdn/stats"
+)
+
+// StatsMiddleware counts the responses of the rest of the chain, by cache
+// status, size and latency, for the stats and the Prometheus metrics.
+func StatsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	latency := time.Since(start)
+	cacheStatus := c.Writer.Header().Get("X-Cache")
+	size := int64(max(c.Writer.Size(), 0))
+	stats.Record(cacheStatus, size, latency)
+	metrics.ObserveRequest(c.Writer.Status(), cacheStatus, route(c), c.Request.Method, size, latency)
+}
+
+// route labels the metrics of a request with the pattern it was routed by,
+// which keeps their cardinality bounded.
+func route(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+	spec := config.Current()
+	if route := spec.RouteFor(c.Request.URL.Path); route != nil {
+		return route.PathPattern
+	}
+	if rule := spec.CacheRuleFor(c.Request.URL.Path); rule != nil {
+		return rule.PathPattern
+	}
+	return "other"
+}
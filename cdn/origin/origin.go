@@ -0,0 +1,142 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/benauro/kube-cdn/cdn/metrics"
+	"github.com/benauro/kube-cdn/cdn/stats"
+)
+
+var (
+	client = &http.Client{
+		Timeout: 60 * time.Second,
+		// Redirects are the client's business, not the edge's
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Hop-by-hop headers, which are not forwarded to the origin
+	hopHeaders = []string{
+		"Connection",
+		"Keep-Alive",
+		"Proxy-Authenticate",
+		"Proxy-Authorization",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
+	}
+)
+
+// Fetch forwards r to the origin at base, keeping the request path and query.
+// The caller closes the response body.
+func Fetch(base string, r *http.Request) (*http.Response, error) {
+	target, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+	req.Header.Set("X-Forwarded-Host", r.Host)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	// Clients hanging up are not the origin's fault
+	if !errors.Is(err, context.Canceled) {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		stats.RecordOrigin(err != nil || status >= http.StatusInternalServerError)
+		metrics.ObserveOrigin(status, err, time.Since(start))
+	}
+	if err == nil && resp.StatusCode < http.StatusMultipleChoices {
+		setContentType(resp.Header, r.URL.Path)
+	}
+	return resp, err
+}
+
+// setContentType types content the origin served without a media type, or
+// as generic bytes, after the extension of urlPath. Players reject manifests
+// and segments served as application/octet-stream, as object stores do.
+func setContentType(h http.Header, urlPath string) {
+	switch mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mediaType {
+	case "", "application/octet-stream", "binary/octet-stream":
+		if contentType := ContentType(strings.ToLower(path.Ext(urlPath))); contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+	}
+}
+
+// CopyHeader copies src into dst, leaving out hop-by-hop headers.
+func CopyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		dst[k] = append([]string(nil), vv...)
+	}
+	for _, h := range hopHeaders {
+		dst.Del(h)
+	}
+}
+
+// ContentType returns the media type of files with the extension ext, empty
+// when unknown.
+func ContentType(ext string) string {
+	switch ext {
+	case ".css":
+		return "text/css"
+	case ".js":
+		return "application/javascript"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".svg":
+		return "image/svg+xml"
+	case ".mp4":
+		return "video/mp4"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	case ".pdf":
+		return "application/pdf"
+	case ".ps":
+		return "application/postscript"
+	default:
+		return ""
+	}
+}
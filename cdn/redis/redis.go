@@ -1,9 +1,12 @@
 package redis
 
 import (
+	"context"
 	"log"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/benauro/kube-cdn/cdn/metrics"
 )
 
 var (
@@ -14,6 +17,39 @@ var (
 	})
 )
 
+func init() {
+	redisClient.AddHook(errorHook{})
+}
+
+// errorHook counts the commands which failed, a missing key is no failure.
+type errorHook struct{}
+
+func (errorHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (errorHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if err != nil && err != redis.Nil {
+			metrics.RedisError(cmd.Name())
+		}
+		return err
+	}
+}
+
+func (errorHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				metrics.RedisError(cmd.Name())
+			}
+		}
+		return err
+	}
+}
+
 func Client() *redis.Client {
 	if redisClient == nil {
 		log.Fatal("Failed to initialize redis")
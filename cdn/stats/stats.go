@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBoundsMs are the upper bounds of the response latency buckets, in
+// milliseconds. Slower responses land in a last, unbounded bucket.
+var LatencyBoundsMs = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Snapshot holds what the edge served since it started. The controller
+// scrapes it from every edge pod and turns the differences between scrapes
+// into the metrics of the CDN.
+type Snapshot struct {
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+
+	Requests      int64 `json:"requests"`
+	ResponseBytes int64 `json:"responseBytes"`
+
+	// Responses that went through the cache, HIT, MISS or BYPASS, and those
+	// served from it
+	CacheLookups      int64 `json:"cacheLookups"`
+	CacheLookupBytes  int64 `json:"cacheLookupBytes"`
+	CacheHits         int64 `json:"cacheHits"`
+	CacheHitBytes     int64 `json:"cacheHitBytes"`
+	OriginFetches     int64 `json:"originFetches"`
+	OriginErrors      int64 `json:"originErrors"`
+	CacheDiskUsed     int64 `json:"cacheDiskUsedBytes"`
+	CacheDiskCapacity int64 `json:"cacheDiskCapacityBytes"`
+
+	LatencyBoundsMs []float64 `json:"latencyBoundsMs"`
+	// Responses per latency bucket, one more than there are bounds
+	LatencyCounts []int64 `json:"latencyCounts"`
+}
+
+var (
+	mu      sync.Mutex
+	started = time.Now()
+	current = Snapshot{LatencyCounts: make([]int64, len(LatencyBoundsMs)+1)}
+)
+
+// Record counts a response of size bytes, served in latency. cacheStatus is
+// its X-Cache header, empty when it didn't go through the cache.
+func Record(cacheStatus string, size int64, latency time.Duration) {
+	ms := float64(latency) / float64(time.Millisecond)
+	bucket := len(LatencyBoundsMs)
+	for i, bound := range LatencyBoundsMs {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current.Requests++
+	current.ResponseBytes += size
+	current.LatencyCounts[bucket]++
+	if cacheStatus == "" {
+		return
+	}
+	current.CacheLookups++
+	current.CacheLookupBytes += size
+	if cacheStatus == "HIT" {
+		current.CacheHits++
+		current.CacheHitBytes += size
+	}
+}
+
+// RecordOrigin counts a fetch from the origin, failed when it got no
+// response or a server error.
+func RecordOrigin(failed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.OriginFetches++
+	if failed {
+		current.OriginErrors++
+	}
+}
+
+// Current returns a copy of the counters.
+func Current() Snapshot {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := current
+	snapshot.UptimeSeconds = time.Since(started).Seconds()
+	snapshot.LatencyBoundsMs = LatencyBoundsMs
+	snapshot.LatencyCounts = append([]int64(nil), current.LatencyCounts...)
+	return snapshot
+}
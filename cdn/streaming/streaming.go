@@ -0,0 +1,117 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	KindNone     = ""
+	KindManifest = "manifest"
+	KindSegment  = "segment"
+)
+
+// Kind classifies urlPath as an HLS/DASH manifest, a media segment, or neither.
+func Kind(urlPath string) string {
+	switch strings.ToLower(path.Ext(urlPath)) {
+	case ".m3u8", ".mpd":
+		return KindManifest
+	case ".ts", ".m4s", ".mp4", ".m4a", ".m4v", ".aac", ".vtt", ".cmfv", ".cmfa":
+		return KindSegment
+	default:
+		return KindNone
+	}
+}
+
+// IsLive reports whether manifest describes a live stream, whose manifest
+// keeps changing: an HLS playlist without EXT-X-ENDLIST or a dynamic MPD.
+func IsLive(urlPath string, manifest []byte) bool {
+	if strings.EqualFold(path.Ext(urlPath), ".mpd") {
+		var mpd struct {
+			Type string `xml:"type,attr"`
+		}
+		return xml.Unmarshal(manifest, &mpd) == nil && mpd.Type == "dynamic"
+	}
+	return !bytes.Contains(manifest, []byte("#EXT-X-ENDLIST"))
+}
+
+// UpcomingSegments returns up to n segment URLs from manifest, resolved
+// against the manifest URL. Live playlists yield their newest segments, which
+// players joining at the live edge request next, VOD ones their first.
+// HLS master playlists and template based DASH manifests yield nothing.
+func UpcomingSegments(manifestURL *url.URL, manifest []byte, live bool, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	var uris []string
+	if strings.EqualFold(path.Ext(manifestURL.Path), ".mpd") {
+		uris = dashSegments(manifest)
+	} else {
+		uris = hlsSegments(manifest)
+	}
+
+	if len(uris) > n {
+		if live {
+			uris = uris[len(uris)-n:]
+		} else {
+			uris = uris[:n]
+		}
+	}
+
+	resolved := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		u := manifestURL.ResolveReference(ref)
+		// Segments on other hosts are not ours to cache
+		if u.Host != manifestURL.Host {
+			continue
+		}
+		resolved = append(resolved, u.RequestURI())
+	}
+	return resolved
+}
+
+func hlsSegments(manifest []byte) []string {
+	var uris []string
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			// A master playlist lists variants, not segments
+			return nil
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			uris = append(uris, line)
+		}
+	}
+	return uris
+}
+
+func dashSegments(manifest []byte) []string {
+	var uris []string
+	decoder := xml.NewDecoder(bytes.NewReader(manifest))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return uris
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || (el.Name.Local != "SegmentURL" && el.Name.Local != "Initialization") {
+			continue
+		}
+		for _, attr := range el.Attr {
+			if attr.Name.Local == "media" || attr.Name.Local == "sourceURL" {
+				uris = append(uris, attr.Value)
+			}
+		}
+	}
+}
@@ -34,6 +34,8 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+
 	cdnv3 "github.com/benauro/kube-cdn/api/v3"
 	"github.com/benauro/kube-cdn/internal/controller"
 	//+kubebuilder:scaffold:imports
@@ -46,6 +48,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(cdiv1.AddToScheme(scheme))
 
 	utilruntime.Must(cdnv3.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
@@ -123,8 +126,9 @@ func main() {
 	}
 
 	if err = (&controller.ContentDeliveryNetworkReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("contentdeliverynetwork-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ContentDeliveryNetwork")
 		os.Exit(1)
@@ -143,6 +147,13 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "DomainNameSystem")
 		os.Exit(1)
 	}
+	if err = (&controller.CacheWarmupReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CacheWarmup")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
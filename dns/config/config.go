@@ -0,0 +1,461 @@
+package config
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultPath is where the controller mounts the rendered zones
+	DefaultPath = "/etc/kube-cdn/dns/zones.json"
+	// DefaultKeysPath is where the controller mounts the DNSSEC keys
+	DefaultKeysPath = "/etc/kube-cdn/dnssec"
+)
+
+// Config is the set of zones rendered by the DomainNameSystem controller.
+type (
+	Config struct {
+		Zones []Zone `json:"zones"`
+		// GeoIP country database mapping clients to regions by country
+		GeoIPFile string `json:"geoIPFile,omitempty"`
+	}
+
+	Zone struct {
+		// Fully qualified zone apex
+		Name string `json:"name"`
+		// Fully qualified nameserver names, the first is the SOA primary
+		Nameservers []string `json:"nameservers"`
+		// Glue addresses of the nameservers inside the zone
+		NameserverAddresses []string `json:"nameserverAddresses,omitempty"`
+		Hostmaster          string   `json:"hostmaster,omitempty"`
+		Serial              uint32   `json:"serial"`
+		// TTL of answers and negative answers, in seconds
+		TTL   uint32 `json:"ttl"`
+		Hosts []Host `json:"hosts,omitempty"`
+		// Regions clients and endpoints are grouped in
+		Regions []Region `json:"regions,omitempty"`
+		// Signs answers when set
+		DNSSEC *DNSSEC `json:"dnssec,omitempty"`
+
+		nameserverEndpoints []Endpoint
+	}
+
+	DNSSEC struct {
+		// Denial of existence records, NSEC or NSEC3
+		Denial string `json:"denial"`
+		// Key signing the DNSKEY set, named as in the keys directory
+		KSK string `json:"ksk"`
+		// Key signing everything else
+		ZSK string `json:"zsk"`
+		// Keys only published in the DNSKEY set, ahead of or after their use
+		Published []string `json:"published,omitempty"`
+
+		ksk, zsk *Key
+		dnskeys  []*dns.DNSKEY
+	}
+
+	// Key is a DNSSEC key pair
+	Key struct {
+		DNSKEY *dns.DNSKEY
+		Signer crypto.Signer
+	}
+
+	Host struct {
+		// Fully qualified host name
+		Name      string     `json:"name"`
+		Endpoints []Endpoint `json:"endpoints"`
+		// Replace the zone regions for this host
+		Regions []Region `json:"regions,omitempty"`
+		// Weighted pools, each answer comes from one of them
+		Pools []Pool `json:"pools,omitempty"`
+	}
+
+	Pool struct {
+		Name string `json:"name"`
+		// Relative weight, 0 drains the pool
+		Weight    uint32     `json:"weight"`
+		Endpoints []Endpoint `json:"endpoints"`
+	}
+
+	Endpoint struct {
+		Address string `json:"address"`
+		Region  string `json:"region,omitempty"`
+
+		ip net.IP
+	}
+
+	Region struct {
+		Name string `json:"name"`
+		// Client subnets belonging to the region
+		CIDRs []string `json:"cidrs,omitempty"`
+		// ISO country codes belonging to the region
+		Countries []string `json:"countries,omitempty"`
+		// Regions answered from, in order, when this one has no endpoint
+		Fallback []string `json:"fallback,omitempty"`
+
+		nets []*net.IPNet
+	}
+)
+
+var current atomic.Pointer[Config]
+
+func init() {
+	current.Store(&Config{})
+}
+
+// Current returns the most recently loaded config, never nil.
+func Current() *Config {
+	return current.Load()
+}
+
+// Load reads the config at p and makes it the current one.
+func Load(p string) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	if err := cfg.compile(); err != nil {
+		return err
+	}
+	current.Store(cfg)
+	return nil
+}
+
+// compile canonicalizes names and parses addresses, so a bad entry rejects
+// the whole config instead of failing queries.
+func (c *Config) compile() error {
+	for i := range c.Zones {
+		z := &c.Zones[i]
+		z.Name = dns.CanonicalName(z.Name)
+		for j := range z.Nameservers {
+			z.Nameservers[j] = dns.CanonicalName(z.Nameservers[j])
+		}
+		z.nameserverEndpoints = nil
+		for _, addr := range z.NameserverAddresses {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return fmt.Errorf("zone %s: invalid nameserver address %q", z.Name, addr)
+			}
+			z.nameserverEndpoints = append(z.nameserverEndpoints, Endpoint{Address: addr, ip: ip})
+		}
+		if err := compileRegions(z.Regions); err != nil {
+			return fmt.Errorf("zone %s: %w", z.Name, err)
+		}
+		if z.DNSSEC != nil {
+			if err := z.DNSSEC.load(KeysPath(), z.Name); err != nil {
+				return fmt.Errorf("zone %s: %w", z.Name, err)
+			}
+		}
+		for j := range z.Hosts {
+			h := &z.Hosts[j]
+			if err := compileRegions(h.Regions); err != nil {
+				return fmt.Errorf("host %s: %w", h.Name, err)
+			}
+			h.Name = dns.CanonicalName(h.Name)
+			if !dns.IsSubDomain(z.Name, h.Name) {
+				return fmt.Errorf("host %s is outside zone %s", h.Name, z.Name)
+			}
+			if err := compileEndpoints(h.Endpoints); err != nil {
+				return fmt.Errorf("host %s: %w", h.Name, err)
+			}
+			for k := range h.Pools {
+				if err := compileEndpoints(h.Pools[k].Endpoints); err != nil {
+					return fmt.Errorf("host %s: pool %s: %w", h.Name, h.Pools[k].Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// load reads the keys of the zone from dir, where each key is stored as
+// <name>.key and <name>.private in the BIND format.
+func (d *DNSSEC) load(dir, zone string) error {
+	var err error
+	if d.ksk, err = loadKey(dir, d.KSK, zone); err != nil {
+		return err
+	}
+	if d.zsk, err = loadKey(dir, d.ZSK, zone); err != nil {
+		return err
+	}
+	d.dnskeys = []*dns.DNSKEY{d.ksk.DNSKEY, d.zsk.DNSKEY}
+	for _, name := range d.Published {
+		key, err := loadKey(dir, name, zone)
+		if err != nil {
+			return err
+		}
+		d.dnskeys = append(d.dnskeys, key.DNSKEY)
+	}
+	return nil
+}
+
+func loadKey(dir, name, zone string) (*Key, error) {
+	public := filepath.Join(dir, name+".key")
+	data, err := os.ReadFile(public)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := dns.NewRR(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", public, err)
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok || dns.CanonicalName(dnskey.Hdr.Name) != zone {
+		return nil, fmt.Errorf("%s: not a DNSKEY of %s", public, zone)
+	}
+
+	private := filepath.Join(dir, name+".private")
+	f, err := os.Open(private)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	key, err := dnskey.ReadPrivateKey(f, private)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key", private)
+	}
+	return &Key{DNSKEY: dnskey, Signer: signer}, nil
+}
+
+// KSKey returns the key signing the DNSKEY set.
+func (d *DNSSEC) KSKey() *Key {
+	return d.ksk
+}
+
+// ZSKey returns the key signing every other set.
+func (d *DNSSEC) ZSKey() *Key {
+	return d.zsk
+}
+
+// DNSKEYs returns the published keys.
+func (d *DNSSEC) DNSKEYs() []*dns.DNSKEY {
+	return d.dnskeys
+}
+
+func compileEndpoints(endpoints []Endpoint) error {
+	for i := range endpoints {
+		e := &endpoints[i]
+		if e.ip = net.ParseIP(e.Address); e.ip == nil {
+			return fmt.Errorf("invalid address %q", e.Address)
+		}
+	}
+	return nil
+}
+
+func compileRegions(regions []Region) error {
+	for i := range regions {
+		r := &regions[i]
+		r.nets = nil
+		for _, cidr := range r.CIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("region %s: %w", r.Name, err)
+			}
+			r.nets = append(r.nets, n)
+		}
+	}
+	return nil
+}
+
+// Path returns the config path, overridable through DNS_CONFIG_PATH.
+func Path() string {
+	if v := os.Getenv("DNS_CONFIG_PATH"); v != "" {
+		return v
+	}
+	return DefaultPath
+}
+
+// KeysPath returns the DNSSEC keys directory, overridable through
+// DNSSEC_KEYS_PATH.
+func KeysPath() string {
+	if v := os.Getenv("DNSSEC_KEYS_PATH"); v != "" {
+		return v
+	}
+	return DefaultKeysPath
+}
+
+// Watch loads the config at p and polls every interval for the zones or the
+// DNSSEC keys to change, reloading the config then. The keys and the zones
+// naming them are mounted separately, a reload failing on a key not there
+// yet succeeds once it is. The onLoad hooks run after every successful load.
+func Watch(p string, interval time.Duration, onLoad ...func()) {
+	load := func() {
+		if err := Load(p); err != nil {
+			log.Printf("Failed to load config %s: %v", p, err)
+			return
+		}
+		log.Printf("Loaded config %s", p)
+		for _, fn := range onLoad {
+			fn()
+		}
+	}
+
+	paths := []string{p, KeysPath()}
+	last := modTimes(paths)
+	load()
+	go func() {
+		for range time.Tick(interval) {
+			if mod := modTimes(paths); !slices.EqualFunc(mod, last, time.Time.Equal) {
+				last = mod
+				load()
+			}
+		}
+	}()
+}
+
+// modTimes returns the modification times of paths, zero for the missing
+// ones. The kubelet updates the zones ConfigMap and the keys Secret by
+// swapping the symlink their files are reached through, which os.Stat
+// follows.
+func modTimes(paths []string) []time.Time {
+	times := make([]time.Time, len(paths))
+	for i, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			times[i] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// ZoneFor returns the most specific zone containing name.
+func (c *Config) ZoneFor(name string) *Zone {
+	var best *Zone
+	for i := range c.Zones {
+		z := &c.Zones[i]
+		if dns.IsSubDomain(z.Name, name) && (best == nil || len(z.Name) > len(best.Name)) {
+			best = z
+		}
+	}
+	return best
+}
+
+// Host returns the host named name in the zone.
+func (z *Zone) Host(name string) *Host {
+	for i := range z.Hosts {
+		if z.Hosts[i].Name == name {
+			return &z.Hosts[i]
+		}
+	}
+	return nil
+}
+
+// HasDescendant reports whether a host exists below name, which makes name
+// an empty non-terminal rather than a missing name.
+func (z *Zone) HasDescendant(name string) bool {
+	for i := range z.Hosts {
+		if strings.HasSuffix(z.Hosts[i].Name, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// NameserverEndpoints returns the glue addresses of the in-zone nameservers.
+func (z *Zone) NameserverEndpoints() []Endpoint {
+	return z.nameserverEndpoints
+}
+
+// IP returns the parsed endpoint address.
+func (e *Endpoint) IP() net.IP {
+	return e.ip
+}
+
+// RegionsFor returns the regions answers for h are chosen by.
+func (z *Zone) RegionsFor(h *Host) []Region {
+	if len(h.Regions) > 0 {
+		return h.Regions
+	}
+	return z.Regions
+}
+
+// RegionOf returns the region ip belongs to: the one with the most specific
+// subnet containing ip, or else the one listing country.
+func RegionOf(regions []Region, ip net.IP, country string) *Region {
+	var best *Region
+	bestBits := -1
+	for i := range regions {
+		for _, n := range regions[i].nets {
+			if bits, _ := n.Mask.Size(); n.Contains(ip) && bits > bestBits {
+				best, bestBits = &regions[i], bits
+			}
+		}
+	}
+	if best != nil || country == "" {
+		return best
+	}
+	for i := range regions {
+		for _, c := range regions[i].Countries {
+			if strings.EqualFold(c, country) {
+				return &regions[i]
+			}
+		}
+	}
+	return nil
+}
+
+// PickPool returns a pool of h with a probability proportional to its
+// weight, among the pools having endpoints. n draws a random number in
+// [0, total).
+func (h *Host) PickPool(n func(total uint32) uint32) *Pool {
+	var total uint32
+	for i := range h.Pools {
+		if len(h.Pools[i].Endpoints) > 0 {
+			total += h.Pools[i].Weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	pick := n(total)
+	for i := range h.Pools {
+		p := &h.Pools[i]
+		if len(p.Endpoints) == 0 {
+			continue
+		}
+		if pick < p.Weight {
+			return p
+		}
+		pick -= p.Weight
+	}
+	return nil
+}
+
+// EndpointsIn returns the endpoints in region, or in its fallback regions in
+// order when it has none. Without a region, or when no fallback has
+// endpoints either, every endpoint is returned.
+func EndpointsIn(all []Endpoint, region *Region) []Endpoint {
+	if region == nil {
+		return all
+	}
+	for _, name := range append([]string{region.Name}, region.Fallback...) {
+		var endpoints []Endpoint
+		for _, e := range all {
+			if e.Region == name {
+				endpoints = append(endpoints, e)
+			}
+		}
+		if len(endpoints) > 0 {
+			return endpoints
+		}
+	}
+	return all
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestRegionOf(t *testing.T) {
+	regions := []Region{
+		{Name: "eu", CIDRs: []string{"10.0.0.0/8"}, Countries: []string{"DE", "FR"}},
+		{Name: "eu-west", CIDRs: []string{"10.1.0.0/16"}},
+		{Name: "us", CIDRs: []string{"2001:db8::/32"}, Countries: []string{"us"}},
+	}
+	if err := compileRegions(regions); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		country string
+		want    string
+	}{
+		{"subnet", "10.2.3.4", "", "eu"},
+		{"most specific subnet", "10.1.2.3", "", "eu-west"},
+		{"subnet before country", "10.1.2.3", "US", "eu-west"},
+		{"ipv6 subnet", "2001:db8::1", "", "us"},
+		{"country", "192.0.2.1", "FR", "eu"},
+		{"country is case insensitive", "192.0.2.1", "US", "us"},
+		{"unknown country", "192.0.2.1", "JP", ""},
+		{"no match", "192.0.2.1", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if r := RegionOf(regions, net.ParseIP(tt.ip), tt.country); r != nil {
+				got = r.Name
+			}
+			if got != tt.want {
+				t.Errorf("RegionOf(%s, %q) = %q, want %q", tt.ip, tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointsIn(t *testing.T) {
+	all := []Endpoint{
+		{Address: "192.0.2.1", Region: "eu"},
+		{Address: "192.0.2.2", Region: "us"},
+		{Address: "192.0.2.3", Region: "us"},
+		{Address: "192.0.2.4"},
+	}
+
+	tests := []struct {
+		name   string
+		region *Region
+		want   []string
+	}{
+		{"no region", nil, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}},
+		{"own endpoints", &Region{Name: "us", Fallback: []string{"eu"}}, []string{"192.0.2.2", "192.0.2.3"}},
+		{"first fallback with endpoints", &Region{Name: "ap", Fallback: []string{"sa", "eu", "us"}}, []string{"192.0.2.1"}},
+		{"no fallback", &Region{Name: "ap"}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}},
+		{"fallbacks without endpoints", &Region{Name: "ap", Fallback: []string{"sa"}}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range EndpointsIn(all, tt.region) {
+				got = append(got, e.Address)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("EndpointsIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickPool(t *testing.T) {
+	endpoints := []Endpoint{{Address: "192.0.2.1"}}
+	host := &Host{Pools: []Pool{
+		{Name: "blue", Weight: 90, Endpoints: endpoints},
+		{Name: "empty", Weight: 50},
+		{Name: "drained", Weight: 0, Endpoints: endpoints},
+		{Name: "green", Weight: 10, Endpoints: endpoints},
+	}}
+
+	tests := []struct {
+		name      string
+		host      *Host
+		draw      uint32
+		wantTotal uint32
+		want      string
+	}{
+		{"first pool", host, 0, 100, "blue"},
+		{"end of the first pool", host, 89, 100, "blue"},
+		{"skips empty and drained pools", host, 90, 100, "green"},
+		{"last pool", host, 99, 100, "green"},
+		{"all drained", &Host{Pools: []Pool{{Name: "blue", Endpoints: endpoints}}}, 0, 0, ""},
+		{"no endpoints", &Host{Pools: []Pool{{Name: "blue", Weight: 1}}}, 0, 0, ""},
+		{"no pools", &Host{}, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total uint32
+			p := tt.host.PickPool(func(n uint32) uint32 {
+				total = n
+				return tt.draw
+			})
+			got := ""
+			if p != nil {
+				got = p.Name
+			}
+			if got != tt.want || total != tt.wantTotal {
+				t.Errorf("PickPool() drawing %d = %q out of %d, want %q out of %d", tt.draw, got, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
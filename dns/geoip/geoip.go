@@ -0,0 +1,94 @@
+package geoip
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/benauro/kube-cdn/dns/config"
+)
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+var countryDB atomic.Pointer[maxminddb.Reader]
+
+// Watch loads the country database named in the current config, then checks
+// every interval whether the zones name another file or the file changed,
+// and reloads it then. Regions fall back to client subnets while no
+// database is loaded.
+func Watch(interval time.Duration) {
+	var (
+		loaded  string
+		modTime time.Time
+	)
+	check := func() {
+		p := config.Current().GeoIPFile
+		if p == "" {
+			loaded = ""
+			countryDB.Store(nil)
+			return
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			if p != loaded {
+				log.Printf("Failed to open GeoIP database %s: %v", p, err)
+				loaded = p
+			}
+			return
+		}
+		if p == loaded && fi.ModTime().Equal(modTime) {
+			return
+		}
+		if err := load(p); err != nil {
+			log.Printf("Failed to load GeoIP database %s: %v", p, err)
+		} else {
+			log.Printf("Loaded GeoIP database %s", p)
+		}
+		loaded, modTime = p, fi.ModTime()
+	}
+
+	check()
+	go func() {
+		for range time.Tick(interval) {
+			check()
+		}
+	}()
+}
+
+// load makes the database at p the one queries are located with. Queries
+// being answered keep the reader they started with, so the database is
+// read into memory instead of being mapped and unmapped from under them.
+func load(p string) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	countryDB.Store(reader)
+	return nil
+}
+
+// Country returns the ISO code of the country ip is located in, empty when
+// no database is loaded or the address is unknown.
+func Country(ip net.IP) string {
+	db := countryDB.Load()
+	if db == nil || ip == nil {
+		return ""
+	}
+	var rec countryRecord
+	if err := db.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	return rec.Country.ISOCode
+}
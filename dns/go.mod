@@ -0,0 +1,15 @@
+module github.com/benauro/kube-cdn/dns
+
+go 1.21
+
+require (
+	github.com/miekg/dns v1.1.58
+	github.com/oschwald/maxminddb-golang v1.12.0
+)
+
+require (
+	golang.org/x/mod v0.14.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/tools v0.17.0 // indirect
+)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/benauro/kube-cdn/dns/config"
+	"github.com/benauro/kube-cdn/dns/geoip"
+	"github.com/benauro/kube-cdn/dns/server"
+)
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func main() {
+	config.Watch(config.Path(), 5*time.Second)
+	geoip.Watch(30 * time.Second)
+
+	addr := env("DNS_LISTEN", ":5353")
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: network, Handler: server.Handler}
+		go func() {
+			log.Fatal(srv.ListenAndServe())
+		}()
+	}
+
+	// Ready once zones are loaded, so new replicas don't answer REFUSED
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if len(config.Current().Zones) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Answers given per weighted host and pool, scraped by the controller
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"answers": server.Answers()})
+	})
+
+	log.Printf("Start serving at: %v", addr)
+	log.Fatal(http.ListenAndServe(env("DNS_HEALTH_LISTEN", ":8081"), nil))
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/base32"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/benauro/kube-cdn/dns/config"
+)
+
+const (
+	// Signatures are valid for a week and renewed halfway
+	signatureValidity = 7 * 24 * time.Hour
+	signatureRenewal  = signatureValidity / 2
+	// Clock skew tolerated on validators
+	signatureInception = time.Hour
+	// Signatures kept at most, the cache is flushed past it
+	maxCachedSignatures = 10000
+)
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// Signatures by key and RRset. Answers are signed on the fly, the cache
+// spares signing the same sets over and over.
+var signatures = struct {
+	sync.Mutex
+	m map[string]*dns.RRSIG
+}{m: map[string]*dns.RRSIG{}}
+
+// secure adds the denial of existence records to negative answers and signs
+// every RRset of m. NXDOMAIN is proven by minimally covering NSEC3 records
+// generated for the query, while with NSEC it is answered as NODATA for the
+// query name, so no name of the zone is ever disclosed.
+func secure(m *dns.Msg, q dns.Question, zone *config.Zone) {
+	name := dns.CanonicalName(q.Name)
+	if len(m.Answer) == 0 {
+		nsec3 := zone.DNSSEC.Denial == "NSEC3"
+		switch {
+		case m.Rcode == dns.RcodeNameError && nsec3:
+			ce, nc := closestEncloser(zone, name)
+			m.Ns = append(m.Ns, matchingNSEC3(zone, ce))
+			m.Ns = append(m.Ns, coveringNSEC3(zone, nc))
+			m.Ns = append(m.Ns, coveringNSEC3(zone, "*."+ce))
+		case m.Rcode == dns.RcodeNameError:
+			m.Rcode = dns.RcodeSuccess
+			m.Ns = append(m.Ns, nsec(zone, name, nil))
+		case nsec3:
+			m.Ns = append(m.Ns, matchingNSEC3(zone, name))
+		default:
+			m.Ns = append(m.Ns, nsec(zone, name, types(zone, name)))
+		}
+	}
+
+	m.Answer = signSection(zone, m.Answer)
+	m.Ns = signSection(zone, m.Ns)
+	m.Extra = signSection(zone, m.Extra)
+}
+
+// dnskeys returns the DNSKEY set of the zone.
+func dnskeys(zone *config.Zone) []dns.RR {
+	var rrs []dns.RR
+	for _, key := range zone.DNSSEC.DNSKEYs() {
+		rr := *key
+		rr.Hdr = header(zone.Name, dns.TypeDNSKEY, zone.TTL)
+		rrs = append(rrs, &rr)
+	}
+	return rrs
+}
+
+func nsec3param(zone *config.Zone) dns.RR {
+	return &dns.NSEC3PARAM{
+		Hdr:  header(zone.Name, dns.TypeNSEC3PARAM, zone.TTL),
+		Hash: dns.SHA1,
+	}
+}
+
+// exists reports whether name owns records or has descendants that do.
+func exists(zone *config.Zone, name string) bool {
+	return name == zone.Name || zone.Host(name) != nil || zone.HasDescendant(name) || isNameserver(zone, name)
+}
+
+// types returns the types of the RRsets owned by name.
+func types(zone *config.Zone, name string) []uint16 {
+	var types []uint16
+	if name == zone.Name {
+		types = append(types, dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY)
+		if zone.DNSSEC.Denial == "NSEC3" {
+			types = append(types, dns.TypeNSEC3PARAM)
+		}
+	}
+	var endpoints []config.Endpoint
+	if host := zone.Host(name); host != nil {
+		endpoints = host.Endpoints
+		if len(host.Pools) > 0 {
+			// Weighted hosts are answered from their pools, drained ones
+			// aside
+			endpoints = nil
+			for i := range host.Pools {
+				if host.Pools[i].Weight > 0 {
+					endpoints = append(endpoints, host.Pools[i].Endpoints...)
+				}
+			}
+		}
+	} else if isNameserver(zone, name) {
+		endpoints = zone.NameserverEndpoints()
+	}
+	var v4, v6 bool
+	for i := range endpoints {
+		if ip := endpoints[i].IP(); ip.To4() != nil {
+			v4 = true
+		} else if ip != nil {
+			v6 = true
+		}
+	}
+	if v4 {
+		types = append(types, dns.TypeA)
+	}
+	if v6 {
+		types = append(types, dns.TypeAAAA)
+	}
+	if len(types) > 0 {
+		types = append(types, dns.TypeRRSIG)
+	}
+	return types
+}
+
+// nsec returns an NSEC record owned by name covering nothing but its own
+// types, plus the NSEC and RRSIG ones.
+func nsec(zone *config.Zone, name string, owned []uint16) dns.RR {
+	bitmap := append([]uint16{dns.TypeNSEC, dns.TypeRRSIG}, owned...)
+	return &dns.NSEC{
+		Hdr:        header(name, dns.TypeNSEC, zone.TTL),
+		NextDomain: `\000.` + name,
+		TypeBitMap: sortTypes(bitmap),
+	}
+}
+
+// closestEncloser returns the closest existing ancestor of name and the next
+// closer name below it.
+func closestEncloser(zone *config.Zone, name string) (string, string) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		if ce := dns.Fqdn(strings.Join(labels[i:], ".")); exists(zone, ce) {
+			return ce, dns.Fqdn(strings.Join(labels[i-1:], "."))
+		}
+	}
+	return zone.Name, name
+}
+
+// matchingNSEC3 returns the NSEC3 record proving the types of name.
+func matchingNSEC3(zone *config.Zone, name string) dns.RR {
+	hash := dns.HashName(name, dns.SHA1, 0, "")
+	return nsec3(zone, hash, shiftHash(hash, 1), types(zone, name))
+}
+
+// coveringNSEC3 returns an NSEC3 record spanning just the hash of name,
+// proving it doesn't exist.
+func coveringNSEC3(zone *config.Zone, name string) dns.RR {
+	hash := dns.HashName(name, dns.SHA1, 0, "")
+	return nsec3(zone, shiftHash(hash, -1), shiftHash(hash, 1), nil)
+}
+
+// nsec3 uses no salt and no extra iterations, as advised by RFC 9276.
+func nsec3(zone *config.Zone, owner, next string, bitmap []uint16) dns.RR {
+	return &dns.NSEC3{
+		Hdr:        header(strings.ToLower(owner)+"."+zone.Name, dns.TypeNSEC3, zone.TTL),
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: next,
+		TypeBitMap: sortTypes(bitmap),
+	}
+}
+
+// shiftHash adds delta to a base32hex encoded SHA-1 hash, wrapping around.
+func shiftHash(hash string, delta int64) string {
+	raw, err := base32Hex.DecodeString(hash)
+	if err != nil {
+		return hash
+	}
+	n := new(big.Int).SetBytes(raw)
+	n.Add(n, big.NewInt(delta))
+	n.Mod(n, new(big.Int).Lsh(big.NewInt(1), 160))
+	return base32Hex.EncodeToString(n.FillBytes(make([]byte, 20)))
+}
+
+// sortTypes orders types for a type bitmap, dropping duplicates.
+func sortTypes(types []uint16) []uint16 {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	unique := types[:0]
+	for _, t := range types {
+		if len(unique) == 0 || t != unique[len(unique)-1] {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// signSection appends an RRSIG to rrs for each RRset in it.
+func signSection(zone *config.Zone, rrs []dns.RR) []dns.RR {
+	var order []string
+	sets := map[string][]dns.RR{}
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT || h.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := dns.CanonicalName(h.Name) + "/" + strconv.Itoa(int(h.Rrtype))
+		if _, ok := sets[key]; !ok {
+			order = append(order, key)
+		}
+		sets[key] = append(sets[key], rr)
+	}
+	for _, key := range order {
+		if sig := signature(zone, sets[key]); sig != nil {
+			rrs = append(rrs, sig)
+		}
+	}
+	return rrs
+}
+
+// signature signs rrset, with the KSK for the DNSKEY set and the ZSK for
+// the others.
+func signature(zone *config.Zone, rrset []dns.RR) *dns.RRSIG {
+	key := zone.DNSSEC.ZSKey()
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+		key = zone.DNSSEC.KSKey()
+	}
+	tag := key.DNSKEY.KeyTag()
+
+	rdata := make([]string, 0, len(rrset))
+	for _, rr := range rrset {
+		rdata = append(rdata, strings.ToLower(rr.String()))
+	}
+	sort.Strings(rdata)
+	cacheKey := strconv.Itoa(int(tag)) + "\n" + strings.Join(rdata, "\n")
+
+	now := time.Now()
+	signatures.Lock()
+	cached := signatures.m[cacheKey]
+	signatures.Unlock()
+	if cached != nil && time.Unix(int64(cached.Expiration), 0).Sub(now) > signatureRenewal {
+		sig := dns.Copy(cached).(*dns.RRSIG)
+		sig.Hdr.Name = rrset[0].Header().Name
+		return sig
+	}
+
+	h := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		TypeCovered: h.Rrtype,
+		Algorithm:   key.DNSKEY.Algorithm,
+		KeyTag:      tag,
+		SignerName:  zone.Name,
+		Inception:   uint32(now.Add(-signatureInception).Unix()),
+		Expiration:  uint32(now.Add(signatureValidity).Unix()),
+	}
+	if err := sig.Sign(key.Signer, rrset); err != nil {
+		log.Printf("Failed to sign %s %s: %v", h.Name, dns.TypeToString[h.Rrtype], err)
+		return nil
+	}
+
+	signatures.Lock()
+	if len(signatures.m) >= maxCachedSignatures {
+		signatures.m = map[string]*dns.RRSIG{}
+	}
+	signatures.m[cacheKey] = sig
+	signatures.Unlock()
+	return dns.Copy(sig).(*dns.RRSIG)
+}
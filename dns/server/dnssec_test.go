@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/benauro/kube-cdn/dns/config"
+)
+
+// loadZone makes example.com. the current zone, signed with fresh keys and
+// denial of existence records of the given type.
+func loadZone(t *testing.T, denial string) *config.Zone {
+	t.Helper()
+	dir := t.TempDir()
+	for name, flags := range map[string]uint16{"ksk": 257, "zsk": 256} {
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		private, err := key.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".key"), []byte(key.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".private"), []byte(key.PrivateKeyString(private)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("DNSSEC_KEYS_PATH", dir)
+
+	zones := fmt.Sprintf(`{"zones": [{
+		"name": "example.com.",
+		"nameservers": ["ns1.example.com.", "ns.example.net."],
+		"nameserverAddresses": ["192.0.2.53"],
+		"serial": 1,
+		"ttl": 300,
+		"hosts": [
+			{"name": "www.example.com.", "endpoints": [{"address": "192.0.2.1"}, {"address": "2001:db8::1"}]},
+			{"name": "v6.a.b.example.com.", "endpoints": [{"address": "2001:db8::2"}]},
+			{"name": "weighted.example.com.", "endpoints": [], "pools": [
+				{"name": "blue", "weight": 90, "endpoints": [{"address": "192.0.2.10"}]},
+				{"name": "green", "weight": 10, "endpoints": [{"address": "2001:db8::10"}]},
+				{"name": "drained", "weight": 0, "endpoints": [{"address": "192.0.2.20"}]}
+			]},
+			{"name": "weighted4.example.com.", "pools": [
+				{"name": "blue", "weight": 1, "endpoints": [{"address": "192.0.2.11"}]},
+				{"name": "drained", "weight": 0, "endpoints": [{"address": "2001:db8::11"}]}
+			]}
+		],
+		"dnssec": {"denial": %q, "ksk": "ksk", "zsk": "zsk"}
+	}]}`, denial)
+	p := filepath.Join(t.TempDir(), "zones.json")
+	if err := os.WriteFile(p, []byte(zones), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(p); err != nil {
+		t.Fatal(err)
+	}
+	return &config.Current().Zones[0]
+}
+
+func TestTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		denial string
+		owner  string
+		want   []uint16
+	}{
+		{"apex", "NSEC", "example.com.", []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeRRSIG}},
+		{"apex with NSEC3", "NSEC3", "example.com.", []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeNSEC3PARAM, dns.TypeRRSIG}},
+		{"dual stack host", "NSEC", "www.example.com.", []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG}},
+		{"ipv6 host", "NSEC", "v6.a.b.example.com.", []uint16{dns.TypeAAAA, dns.TypeRRSIG}},
+		{"nameserver", "NSEC", "ns1.example.com.", []uint16{dns.TypeA, dns.TypeRRSIG}},
+		{"weighted host", "NSEC", "weighted.example.com.", []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG}},
+		{"weighted host with a drained pool", "NSEC", "weighted4.example.com.", []uint16{dns.TypeA, dns.TypeRRSIG}},
+		{"empty non-terminal", "NSEC", "b.example.com.", nil},
+		{"missing", "NSEC", "mail.example.com.", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := loadZone(t, tt.denial)
+			if got := types(zone, tt.owner); !slices.Equal(got, tt.want) {
+				t.Errorf("types(%s) = %v, want %v", tt.owner, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClosestEncloser(t *testing.T) {
+	zone := loadZone(t, "NSEC3")
+	tests := []struct {
+		name           string
+		wantEncloser   string
+		wantNextCloser string
+	}{
+		{"mail.example.com.", "example.com.", "mail.example.com."},
+		{"x.y.example.com.", "example.com.", "y.example.com."},
+		{"x.www.example.com.", "www.example.com.", "x.www.example.com."},
+		{"x.a.b.example.com.", "a.b.example.com.", "x.a.b.example.com."},
+		{"x.y.b.example.com.", "b.example.com.", "y.b.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce, nc := closestEncloser(zone, tt.name)
+			if ce != tt.wantEncloser || nc != tt.wantNextCloser {
+				t.Errorf("closestEncloser(%s) = %s, %s, want %s, %s", tt.name, ce, nc, tt.wantEncloser, tt.wantNextCloser)
+			}
+		})
+	}
+}
+
+func TestShiftHash(t *testing.T) {
+	tests := []struct {
+		hash  string
+		delta int64
+		want  string
+	}{
+		{"00000000000000000000000000000000", 1, "00000000000000000000000000000001"},
+		{"0000000000000000000000000000000V", 1, "00000000000000000000000000000010"},
+		{"00000000000000000000000000000000", -1, "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV"},
+		{"VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV", 1, "00000000000000000000000000000000"},
+	}
+	for _, tt := range tests {
+		if got := shiftHash(tt.hash, tt.delta); got != tt.want {
+			t.Errorf("shiftHash(%s, %d) = %s, want %s", tt.hash, tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestSecureDenial(t *testing.T) {
+	tests := []struct {
+		name      string
+		denial    string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		// Names whose hashes the NSEC3 records must match, then cover
+		wantMatch []string
+		wantCover []string
+		// Types the NSEC record proves the query name owns
+		wantTypes []uint16
+	}{
+		{
+			name: "NSEC NODATA", denial: "NSEC", qname: "www.example.com.", qtype: dns.TypeMX,
+			wantRcode: dns.RcodeSuccess,
+			wantTypes: []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{
+			name: "NSEC NXDOMAIN answered as NODATA", denial: "NSEC", qname: "mail.example.com.", qtype: dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantTypes: []uint16{dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{
+			name: "NSEC empty non-terminal", denial: "NSEC", qname: "a.b.example.com.", qtype: dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantTypes: []uint16{dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{
+			name: "NSEC NODATA for a weighted host", denial: "NSEC", qname: "weighted4.example.com.", qtype: dns.TypeAAAA,
+			wantRcode: dns.RcodeSuccess,
+			wantTypes: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{
+			name: "NSEC3 NODATA", denial: "NSEC3", qname: "www.example.com.", qtype: dns.TypeMX,
+			wantRcode: dns.RcodeSuccess,
+			wantMatch: []string{"www.example.com."},
+		},
+		{
+			name: "NSEC3 NXDOMAIN", denial: "NSEC3", qname: "x.mail.example.com.", qtype: dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+			wantMatch: []string{"example.com."},
+			wantCover: []string{"mail.example.com.", "*.example.com."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := loadZone(t, tt.denial)
+			q := dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET}
+			m := new(dns.Msg)
+			answer(m, q, &client{ip: net.ParseIP("192.0.2.100")})
+			secure(m, q, zone)
+
+			if m.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+
+			var nsecs []*dns.NSEC
+			var nsec3s []*dns.NSEC3
+			for _, rr := range m.Ns {
+				switch rr := rr.(type) {
+				case *dns.NSEC:
+					nsecs = append(nsecs, rr)
+				case *dns.NSEC3:
+					nsec3s = append(nsec3s, rr)
+				}
+			}
+
+			if tt.denial == "NSEC" {
+				if len(nsecs) != 1 || len(nsec3s) != 0 {
+					t.Fatalf("got %d NSEC and %d NSEC3 records, want one NSEC", len(nsecs), len(nsec3s))
+				}
+				if nsecs[0].Hdr.Name != tt.qname {
+					t.Errorf("NSEC owner = %s, want %s", nsecs[0].Hdr.Name, tt.qname)
+				}
+				if !slices.Equal(nsecs[0].TypeBitMap, sortTypes(tt.wantTypes)) {
+					t.Errorf("NSEC types = %v, want %v", nsecs[0].TypeBitMap, sortTypes(tt.wantTypes))
+				}
+			} else {
+				if len(nsecs) != 0 || len(nsec3s) != len(tt.wantMatch)+len(tt.wantCover) {
+					t.Fatalf("got %d NSEC and %d NSEC3 records, want %d NSEC3", len(nsecs), len(nsec3s), len(tt.wantMatch)+len(tt.wantCover))
+				}
+				for i, name := range tt.wantMatch {
+					if !nsec3s[i].Match(name) {
+						t.Errorf("NSEC3 %s does not match %s", nsec3s[i].Hdr.Name, name)
+					}
+				}
+				for i, name := range tt.wantCover {
+					if rr := nsec3s[len(tt.wantMatch)+i]; !rr.Cover(name) {
+						t.Errorf("NSEC3 %s does not cover %s", rr.Hdr.Name, name)
+					}
+				}
+			}
+
+			verifySigned(t, zone, m.Ns)
+		})
+	}
+}
+
+// verifySigned checks every RRset of rrs is signed by the zone signing key.
+func verifySigned(t *testing.T, zone *config.Zone, rrs []dns.RR) {
+	t.Helper()
+	sets := map[string][]dns.RR{}
+	sigs := map[string]*dns.RRSIG{}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[sig.Hdr.Name+" "+dns.TypeToString[sig.TypeCovered]] = sig
+			continue
+		}
+		key := rr.Header().Name + " " + dns.TypeToString[rr.Header().Rrtype]
+		sets[key] = append(sets[key], rr)
+	}
+	for key, set := range sets {
+		sig := sigs[key]
+		if sig == nil {
+			t.Errorf("%s is not signed", key)
+			continue
+		}
+		if err := sig.Verify(zone.DNSSEC.ZSKey().DNSKEY, set); err != nil {
+			t.Errorf("signature of %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"math/rand"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/benauro/kube-cdn/dns/config"
+	"github.com/benauro/kube-cdn/dns/geoip"
+)
+
+const (
+	// Largest UDP response advertised, avoiding IP fragmentation
+	maxUDPSize = 1232
+	// Addresses returned per answer, the endpoints rotate between queries
+	maxAnswers = 8
+)
+
+// Handler answers queries authoritatively from the current config.
+var Handler = dns.HandlerFunc(serveDNS)
+
+// client is who answers are chosen for: the subnet sent by the resolver
+// through EDNS Client Subnet, or else the resolver itself.
+type client struct {
+	ip     net.IP
+	subnet *dns.EDNS0_SUBNET
+	// Set once the answer depends on the client address
+	geo bool
+}
+
+func clientOf(w dns.ResponseWriter, req *dns.Msg) *client {
+	c := &client{}
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				c.subnet = subnet
+				// A zero prefix asks not to answer by subnet
+				if subnet.SourceNetmask > 0 {
+					c.ip = subnet.Address
+				}
+			}
+		}
+	}
+	if c.ip == nil {
+		switch addr := w.RemoteAddr().(type) {
+		case *net.UDPAddr:
+			c.ip = addr.IP
+		case *net.TCPAddr:
+			c.ip = addr.IP
+		}
+	}
+	return c
+}
+
+func serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Compress = true
+
+	c := clientOf(w, req)
+	size := dns.MinMsgSize
+	// Signatures are only sent to resolvers asking for them
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		size = int(min(max(opt.UDPSize(), dns.MinMsgSize), maxUDPSize))
+		do = opt.Do()
+		m.SetEdns0(maxUDPSize, do)
+	}
+	if w.RemoteAddr().Network() == "tcp" {
+		size = dns.MaxMsgSize
+	}
+
+	switch {
+	case req.Opcode != dns.OpcodeQuery:
+		m.Rcode = dns.RcodeNotImplemented
+	case len(req.Question) != 1 || req.Question[0].Qclass != dns.ClassINET:
+		m.Rcode = dns.RcodeRefused
+	default:
+		if zone := answer(m, req.Question[0], c); zone != nil && zone.DNSSEC != nil && do {
+			secure(m, req.Question[0], zone)
+		}
+	}
+
+	if c.subnet != nil {
+		// The scope tells resolvers which clients may share the answer
+		subnet := *c.subnet
+		subnet.SourceScope = 0
+		if c.geo {
+			subnet.SourceScope = subnet.SourceNetmask
+		}
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &subnet)
+	}
+
+	m.Truncate(size)
+	_ = w.WriteMsg(m)
+}
+
+// answer fills m from the zone containing the question, which it returns.
+func answer(m *dns.Msg, q dns.Question, c *client) *config.Zone {
+	name := dns.CanonicalName(q.Name)
+	zone := config.Current().ZoneFor(name)
+	if zone == nil {
+		m.Rcode = dns.RcodeRefused
+		return nil
+	}
+	m.Authoritative = true
+
+	if name == zone.Name {
+		switch q.Qtype {
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, soa(zone))
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, nameservers(zone)...)
+			m.Extra = append(m.Extra, glue(zone, dns.TypeA)...)
+			m.Extra = append(m.Extra, glue(zone, dns.TypeAAAA)...)
+		case dns.TypeDNSKEY:
+			if zone.DNSSEC != nil {
+				m.Answer = append(m.Answer, dnskeys(zone)...)
+			}
+		case dns.TypeNSEC3PARAM:
+			if zone.DNSSEC != nil && zone.DNSSEC.Denial == "NSEC3" {
+				m.Answer = append(m.Answer, nsec3param(zone))
+			}
+		}
+	}
+
+	if host := zone.Host(name); host != nil {
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeAAAA:
+			m.Answer = append(m.Answer, addresses(zone, host, c, q.Qtype)...)
+		case dns.TypeANY:
+			m.Answer = append(m.Answer, addresses(zone, host, c, dns.TypeA, dns.TypeAAAA)...)
+		}
+	} else if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+		m.Answer = append(m.Answer, nameserverAddresses(zone, name, q.Qtype)...)
+	}
+
+	if len(m.Answer) > 0 {
+		// Echo the query case, resolvers may randomize it against spoofing
+		for _, rr := range m.Answer {
+			if rr.Header().Name == name {
+				rr.Header().Name = q.Name
+			}
+		}
+		return zone
+	}
+	if !exists(zone, name) {
+		m.Rcode = dns.RcodeNameError
+	}
+	// Negative answers carry the SOA, whose TTL bounds negative caching
+	m.Ns = append(m.Ns, soa(zone))
+	return zone
+}
+
+func header(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+}
+
+func soa(zone *config.Zone) dns.RR {
+	primary := "ns1." + zone.Name
+	if len(zone.Nameservers) > 0 {
+		primary = zone.Nameservers[0]
+	}
+	hostmaster := "hostmaster." + zone.Name
+	if zone.Hostmaster != "" {
+		hostmaster = dns.Fqdn(zone.Hostmaster)
+	}
+	return &dns.SOA{
+		Hdr:     header(zone.Name, dns.TypeSOA, zone.TTL),
+		Ns:      primary,
+		Mbox:    hostmaster,
+		Serial:  zone.Serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  zone.TTL,
+	}
+}
+
+func nameservers(zone *config.Zone) []dns.RR {
+	rrs := make([]dns.RR, 0, len(zone.Nameservers))
+	for _, ns := range zone.Nameservers {
+		rrs = append(rrs, &dns.NS{Hdr: header(zone.Name, dns.TypeNS, zone.TTL), Ns: ns})
+	}
+	return rrs
+}
+
+func isNameserver(zone *config.Zone, name string) bool {
+	for _, ns := range zone.Nameservers {
+		if ns == name {
+			return true
+		}
+	}
+	return false
+}
+
+// glue returns the addresses of the nameservers inside the zone.
+func glue(zone *config.Zone, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, ns := range zone.Nameservers {
+		rrs = append(rrs, nameserverAddresses(zone, ns, qtype)...)
+	}
+	return rrs
+}
+
+func nameserverAddresses(zone *config.Zone, name string, qtype uint16) []dns.RR {
+	if !isNameserver(zone, name) || !dns.IsSubDomain(zone.Name, name) {
+		return nil
+	}
+	return addressRecords(name, zone.NameserverEndpoints(), qtype, zone.TTL)
+}
+
+// addresses answers with the endpoints of a pool picked by weight, if the
+// host is weighted, and of the client region, falling back to other regions
+// when it has no healthy endpoint.
+func addresses(zone *config.Zone, host *config.Host, c *client, qtypes ...uint16) []dns.RR {
+	endpoints := host.Endpoints
+	if len(host.Pools) > 0 {
+		pool := host.PickPool(func(total uint32) uint32 { return uint32(rand.Int63n(int64(total))) })
+		if pool == nil {
+			return nil
+		}
+		countAnswer(host.Name, pool.Name)
+		endpoints = pool.Endpoints
+	}
+	if regions := zone.RegionsFor(host); len(regions) > 0 && c.ip != nil {
+		c.geo = true
+		region := config.RegionOf(regions, c.ip, "")
+		if region == nil {
+			region = config.RegionOf(regions, c.ip, geoip.Country(c.ip))
+		}
+		endpoints = config.EndpointsIn(endpoints, region)
+	}
+
+	var rrs []dns.RR
+	for _, qtype := range qtypes {
+		rrs = append(rrs, addressRecords(host.Name, endpoints, qtype, zone.TTL)...)
+	}
+	// Spread the load over all endpoints even when clients pick the first
+	rand.Shuffle(len(rrs), func(i, j int) { rrs[i], rrs[j] = rrs[j], rrs[i] })
+	if len(rrs) > maxAnswers {
+		rrs = rrs[:maxAnswers]
+	}
+	return rrs
+}
+
+func addressRecords(name string, endpoints []config.Endpoint, qtype uint16, ttl uint32) []dns.RR {
+	var rrs []dns.RR
+	for i := range endpoints {
+		ip := endpoints[i].IP()
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			if qtype == dns.TypeA {
+				rrs = append(rrs, &dns.A{Hdr: header(name, dns.TypeA, ttl), A: ip4})
+			}
+		} else if qtype == dns.TypeAAAA {
+			rrs = append(rrs, &dns.AAAA{Hdr: header(name, dns.TypeAAAA, ttl), AAAA: ip})
+		}
+	}
+	return rrs
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// answerKey identifies a pool of a weighted host
+type answerKey struct {
+	host, pool string
+}
+
+// answers counts the answers given from each pool since start
+var answers sync.Map
+
+func countAnswer(host, pool string) {
+	v, ok := answers.Load(answerKey{host, pool})
+	if !ok {
+		v, _ = answers.LoadOrStore(answerKey{host, pool}, new(atomic.Uint64))
+	}
+	v.(*atomic.Uint64).Add(1)
+}
+
+// Answers returns the answers given since start by host and pool.
+func Answers() map[string]map[string]uint64 {
+	counts := map[string]map[string]uint64{}
+	answers.Range(func(k, v any) bool {
+		key := k.(answerKey)
+		if counts[key.host] == nil {
+			counts[key.host] = map[string]uint64{}
+		}
+		counts[key.host][key.pool] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	return counts
+}
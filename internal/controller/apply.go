@@ -0,0 +1,143 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Field manager of the server-side applies of the controllers
+const fieldManager = "kube-cdn"
+
+// apply server-side applies obj as a child of owner. obj holds every field
+// the controller manages and only those: the ones it no longer sets are
+// removed, drift on the others is reverted, and fields set by others, such
+// as the ExternalDNS annotations, are left alone. The child is garbage
+// collected with its owner. The result tells whether the child was created,
+// changed or already as desired.
+func apply(ctx context.Context, c client.Client, owner, obj client.Object) (controllerutil.OperationResult, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	if err := controllerutil.SetControllerReference(owner, obj, c.Scheme()); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	existing := obj.DeepCopyObject().(client.Object)
+	result := controllerutil.OperationResultUpdated
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); errors.IsNotFound(err) {
+		result = controllerutil.OperationResultCreated
+	} else if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	if err := c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if result == controllerutil.OperationResultUpdated && obj.GetResourceVersion() == existing.GetResourceVersion() {
+		result = controllerutil.OperationResultNone
+	}
+	return result, nil
+}
+
+// deleteOwned deletes obj when it is a child of owner. Kinds whose CRD isn't
+// installed have nothing to delete.
+func deleteOwned(ctx context.Context, c client.Client, owner, obj client.Object) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); meta.IsNoMatchError(err) {
+		return nil
+	} else if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, owner) {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, obj))
+}
+
+// replaceOnSelectorChange deletes the Deployment or StatefulSet obj is
+// applied over when it is a child of owner with another selector, which is
+// immutable, so that obj can be created in its place. Its pods go with it.
+func replaceOnSelectorChange(ctx context.Context, c client.Client, owner, obj client.Object) error {
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(existing, owner) || equality.Semantic.DeepEqual(workloadSelector(existing), workloadSelector(obj)) {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
+
+func workloadSelector(obj client.Object) *metav1.LabelSelector {
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		return obj.Spec.Selector
+	case *appsv1.StatefulSet:
+		return obj.Spec.Selector
+	}
+	return nil
+}
+
+// managedByOthers reports whether a field manager other than the one of the
+// controllers owns the field of obj at path, given as in managedFields such
+// as "f:spec", "f:replicas".
+func managedByOthers(obj client.Object, path ...string) bool {
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == fieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		for i, name := range path {
+			field, ok := fields[name].(map[string]interface{})
+			if !ok {
+				break
+			}
+			if i == len(path)-1 {
+				return true
+			}
+			fields = field
+		}
+	}
+	return false
+}
+
+// kindInstalled reports whether the CRD of an optional kind is installed.
+func kindInstalled(mapper meta.RESTMapper, gvk schema.GroupVersionKind) (bool, error) {
+	_, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
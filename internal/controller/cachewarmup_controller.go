@@ -0,0 +1,478 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	WarmupPending   = "Pending"
+	WarmupRunning   = "Running"
+	WarmupCompleted = "Completed"
+	WarmupFailed    = "Failed"
+
+	WarmupTargetAllPods = "AllPods"
+
+	// Failures kept in status, the counters cover the rest
+	maxWarmupFailures = 20
+	// URLs read from a sitemap or manifest
+	maxWarmupURLs = 50000
+)
+
+// CacheWarmupReconciler reconciles a CacheWarmup object
+type CacheWarmupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	mu   sync.Mutex
+	runs map[types.NamespacedName]*warmupRun
+}
+
+// warmupRun tracks the fetches of a warm-up running in the background. The
+// reconciler copies its progress into the status while it runs.
+type warmupRun struct {
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	succeeded int
+	failed    int
+	failures  []cdnv3.WarmupFailure
+	done      bool
+}
+
+type warmupFetch struct {
+	url string
+	pod *corev1.Pod
+}
+
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=cachewarmups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=cachewarmups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=cachewarmups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworks,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile starts a warm-up when it is new, changed or due on its schedule,
+// and reports the progress of the running one.
+func (r *CacheWarmupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var warmup cdnv3.CacheWarmup
+	if err := r.Get(ctx, req.NamespacedName, &warmup); err != nil {
+		if errors.IsNotFound(err) {
+			r.stop(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Unable to fetch CacheWarmup")
+		return ctrl.Result{}, err
+	}
+
+	var schedule cron.Schedule
+	if warmup.Spec.Schedule != "" {
+		var err error
+		if schedule, err = cron.ParseStandard(warmup.Spec.Schedule); err != nil {
+			r.stop(req.NamespacedName)
+			warmup.Status.State = WarmupFailed
+			warmup.Status.Message = fmt.Sprintf("invalid schedule: %v", err)
+			return ctrl.Result{}, r.updateStatus(ctx, &warmup)
+		}
+	}
+
+	if run := r.run(req.NamespacedName); run != nil {
+		if warmup.Status.ObservedGeneration != warmup.Generation {
+			// The spec changed under the running warm-up, start over
+			r.stop(req.NamespacedName)
+			return r.start(ctx, &warmup)
+		}
+		return r.reportProgress(ctx, &warmup, run, schedule)
+	}
+
+	switch warmup.Status.State {
+	case WarmupCompleted, WarmupFailed:
+		if warmup.Status.ObservedGeneration == warmup.Generation {
+			if schedule == nil || warmup.Status.StartTime == nil {
+				return ctrl.Result{}, nil
+			}
+			if next := schedule.Next(warmup.Status.StartTime.Time); time.Now().Before(next) {
+				return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+			}
+		}
+	}
+
+	// New, changed, due, or left running by a previous controller instance
+	return r.start(ctx, &warmup)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CacheWarmupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cdnv3.CacheWarmup{}).
+		Complete(r)
+}
+
+func (r *CacheWarmupReconciler) run(key types.NamespacedName) *warmupRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[key]
+}
+
+func (r *CacheWarmupReconciler) stop(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if run, ok := r.runs[key]; ok {
+		run.cancel()
+		delete(r.runs, key)
+	}
+}
+
+func (r *CacheWarmupReconciler) updateStatus(ctx context.Context, warmup *cdnv3.CacheWarmup) error {
+	warmup.Status.ObservedGeneration = warmup.Generation
+	if err := r.Status().Update(ctx, warmup); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to update CacheWarmup status")
+		return err
+	}
+	return nil
+}
+
+// start resolves the URLs and edge pods of the warm-up and fetches them in
+// the background.
+func (r *CacheWarmupReconciler) start(ctx context.Context, warmup *cdnv3.CacheWarmup) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var cdn cdnv3.ContentDeliveryNetwork
+	if err := r.Get(ctx, types.NamespacedName{Name: warmup.Spec.CDNRef, Namespace: warmup.Namespace}, &cdn); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		warmup.Status.State = WarmupPending
+		warmup.Status.Message = fmt.Sprintf("ContentDeliveryNetwork %s not found", warmup.Spec.CDNRef)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, r.updateStatus(ctx, warmup)
+	}
+
+	pods, err := readyEdgePods(ctx, r, &cdn)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(pods) == 0 {
+		warmup.Status.State = WarmupPending
+		warmup.Status.Message = "no ready edge pods"
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, r.updateStatus(ctx, warmup)
+	}
+
+	now := metav1.Now()
+	warmup.Status.StartTime = &now
+	warmup.Status.CompletionTime = nil
+	warmup.Status.Succeeded = 0
+	warmup.Status.Failed = 0
+	warmup.Status.Failures = nil
+
+	urls, err := warmupURLs(ctx, &warmup.Spec)
+	if err != nil {
+		logger.Error(err, "Failed to list warm-up URLs")
+		warmup.Status.State = WarmupFailed
+		warmup.Status.Message = err.Error()
+		warmup.Status.Total = 0
+		warmup.Status.CompletionTime = &now
+		return r.requeueScheduled(warmup), r.updateStatus(ctx, warmup)
+	}
+
+	var fetches []warmupFetch
+	for _, u := range urls {
+		for _, pod := range pods {
+			fetches = append(fetches, warmupFetch{url: u, pod: pod})
+		}
+	}
+
+	warmup.Status.State = WarmupRunning
+	warmup.Status.Message = ""
+	warmup.Status.Total = len(fetches)
+	if err := r.updateStatus(ctx, warmup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &warmupRun{cancel: cancel}
+	r.mu.Lock()
+	if r.runs == nil {
+		r.runs = map[types.NamespacedName]*warmupRun{}
+	}
+	r.runs[client.ObjectKeyFromObject(warmup)] = run
+	r.mu.Unlock()
+
+	concurrency := warmup.Spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	go run.fetchAll(runCtx, cdn.Spec.DomainName, fetches, concurrency)
+
+	logger.Info("Started cache warm-up", "urls", len(urls), "pods", len(pods))
+	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+}
+
+// reportProgress copies the progress of run into the status, completing the
+// warm-up once all fetches are done.
+func (r *CacheWarmupReconciler) reportProgress(ctx context.Context, warmup *cdnv3.CacheWarmup, run *warmupRun, schedule cron.Schedule) (ctrl.Result, error) {
+	run.mu.Lock()
+	warmup.Status.Succeeded = run.succeeded
+	warmup.Status.Failed = run.failed
+	warmup.Status.Failures = append([]cdnv3.WarmupFailure(nil), run.failures...)
+	done := run.done
+	run.mu.Unlock()
+
+	if !done {
+		warmup.Status.State = WarmupRunning
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, r.updateStatus(ctx, warmup)
+	}
+
+	now := metav1.Now()
+	warmup.Status.CompletionTime = &now
+	warmup.Status.State = WarmupCompleted
+	if warmup.Status.Failed > 0 {
+		warmup.Status.State = WarmupFailed
+		warmup.Status.Message = fmt.Sprintf("%d of %d fetches failed", warmup.Status.Failed, warmup.Status.Total)
+	}
+	if err := r.updateStatus(ctx, warmup); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.stop(client.ObjectKeyFromObject(warmup))
+
+	if schedule == nil {
+		return ctrl.Result{}, nil
+	}
+	return r.requeueScheduled(warmup), nil
+}
+
+func (r *CacheWarmupReconciler) requeueScheduled(warmup *cdnv3.CacheWarmup) ctrl.Result {
+	if warmup.Spec.Schedule == "" || warmup.Status.StartTime == nil {
+		return ctrl.Result{}
+	}
+	schedule, err := cron.ParseStandard(warmup.Spec.Schedule)
+	if err != nil {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: time.Until(schedule.Next(warmup.Status.StartTime.Time))}
+}
+
+func (run *warmupRun) fetchAll(ctx context.Context, host string, fetches []warmupFetch, concurrency int) {
+	httpClient := &http.Client{
+		Timeout: time.Minute,
+		// Redirects point at the client facing host, not at the pod
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	jobs := make(chan warmupFetch)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				run.record(f, warmURL(ctx, httpClient, host, f))
+			}
+		}()
+	}
+
+	for _, f := range fetches {
+		select {
+		case jobs <- f:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	run.mu.Lock()
+	run.done = true
+	run.mu.Unlock()
+}
+
+func (run *warmupRun) record(f warmupFetch, err error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if err == nil {
+		run.succeeded++
+		return
+	}
+	run.failed++
+	if len(run.failures) < maxWarmupFailures {
+		run.failures = append(run.failures, cdnv3.WarmupFailure{URL: f.url, Pod: f.pod.Name, Error: err.Error()})
+	}
+}
+
+// warmURL requests requestURI from the pod as if sent to host, which makes
+// the edge fetch and cache it. The body is read in full so the edge caches
+// the whole object.
+func warmURL(ctx context.Context, httpClient *http.Client, host string, f warmupFetch) error {
+	target := "http://" + net.JoinHostPort(f.pod.Status.PodIP, strconv.Itoa(edgePort)) + f.url
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = host
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// warmupURLs collects the request URIs to warm from the spec URLs, the
+// sitemap and the manifest, without duplicates.
+func warmupURLs(ctx context.Context, spec *cdnv3.CacheWarmupSpec) ([]string, error) {
+	raw := append([]string(nil), spec.URLs...)
+	if spec.SitemapURL != "" {
+		urls, err := readSitemap(ctx, spec.SitemapURL, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sitemap: %w", err)
+		}
+		raw = append(raw, urls...)
+	}
+	if spec.ManifestURL != "" {
+		urls, err := readManifest(ctx, spec.ManifestURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		raw = append(raw, urls...)
+	}
+
+	seen := map[string]bool{}
+	var urls []string
+	for _, s := range raw {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || u.Path == "" && u.RawQuery == "" {
+			return nil, fmt.Errorf("invalid URL %q", s)
+		}
+		// Absolute URLs are warmed on the CDN domain whatever their host
+		uri := u.RequestURI()
+		if !strings.HasPrefix(uri, "/") {
+			return nil, fmt.Errorf("invalid URL %q", s)
+		}
+		if !seen[uri] {
+			seen[uri] = true
+			urls = append(urls, uri)
+		}
+	}
+	if len(urls) > maxWarmupURLs {
+		return nil, fmt.Errorf("%d URLs exceed the limit of %d", len(urls), maxWarmupURLs)
+	}
+	return urls, nil
+}
+
+func fetchList(ctx context.Context, u string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %d", u, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// readSitemap returns the locations listed by a sitemap, following the
+// sitemaps of a sitemap index one level deep.
+func readSitemap(ctx context.Context, u string, followIndex bool) ([]string, error) {
+	body, err := fetchList(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var sitemap struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(body, 50<<20)).Decode(&sitemap); err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, entry := range sitemap.URLs {
+		urls = append(urls, entry.Loc)
+	}
+	if followIndex {
+		for _, entry := range sitemap.Sitemaps {
+			nested, err := readSitemap(ctx, strings.TrimSpace(entry.Loc), false)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, nested...)
+		}
+	}
+	return urls, nil
+}
+
+// readManifest returns the URLs listed one per line by a manifest, skipping
+// blank lines and # comments.
+func readManifest(ctx context.Context, u string) ([]string, error) {
+	body, err := fetchList(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(io.LimitReader(body, 50<<20))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
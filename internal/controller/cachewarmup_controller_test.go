@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+var _ = Describe("CacheWarmup Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default", // TODO(user):Modify as needed
+		}
+		cachewarmup := &cdnv3.CacheWarmup{}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind CacheWarmup")
+			err := k8sClient.Get(ctx, typeNamespacedName, cachewarmup)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &cdnv3.CacheWarmup{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+					Spec: cdnv3.CacheWarmupSpec{
+						CDNRef: "missing-cdn",
+						URLs:   []string{"/index.html"},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			// TODO(user): Cleanup logic after each test, like removing the resource instance.
+			resource := &cdnv3.CacheWarmup{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance CacheWarmup")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+		It("should successfully reconcile the resource", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &CacheWarmupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting for the referenced CDN")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			Expect(cachewarmup.Status.State).To(Equal(WarmupPending))
+			Expect(cachewarmup.Status.Message).To(Equal("ContentDeliveryNetwork missing-cdn not found"))
+		})
+
+		It("should fail on an invalid schedule", func() {
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			cachewarmup.Spec.Schedule = "every day"
+			Expect(k8sClient.Update(ctx, cachewarmup)).To(Succeed())
+
+			controllerReconciler := &CacheWarmupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			Expect(cachewarmup.Status.State).To(Equal(WarmupFailed))
+			Expect(cachewarmup.Status.Message).To(HavePrefix("invalid schedule"))
+		})
+
+		It("should report the progress of the running warm-up until it completes", func() {
+			By("Starting from a warm-up of two fetches")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			cachewarmup.Status.State = WarmupRunning
+			cachewarmup.Status.Total = 2
+			cachewarmup.Status.ObservedGeneration = cachewarmup.Generation
+			Expect(k8sClient.Status().Update(ctx, cachewarmup)).To(Succeed())
+
+			run := &warmupRun{cancel: func() {}}
+			controllerReconciler := &CacheWarmupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+				runs:   map[types.NamespacedName]*warmupRun{typeNamespacedName: run},
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "edge-0"}}
+
+			By("Reporting the fetches done so far")
+			run.record(warmupFetch{url: "/index.html", pod: pod}, nil)
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			Expect(cachewarmup.Status.State).To(Equal(WarmupRunning))
+			Expect(cachewarmup.Status.Succeeded).To(Equal(1))
+
+			By("Failing the warm-up once a fetch failed")
+			run.record(warmupFetch{url: "/app.js", pod: pod}, fmt.Errorf("status 502"))
+			run.mu.Lock()
+			run.done = true
+			run.mu.Unlock()
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cachewarmup)).To(Succeed())
+			Expect(cachewarmup.Status.State).To(Equal(WarmupFailed))
+			Expect(cachewarmup.Status.Message).To(Equal("1 of 2 fetches failed"))
+			Expect(cachewarmup.Status.Failures).To(Equal([]cdnv3.WarmupFailure{{URL: "/app.js", Pod: "edge-0", Error: "status 502"}}))
+			Expect(cachewarmup.Status.CompletionTime).NotTo(BeNil())
+			Expect(controllerReconciler.run(typeNamespacedName)).To(BeNil())
+		})
+	})
+
+	Context("When collecting the URLs to warm", func() {
+		ctx := context.Background()
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml</loc></sitemap></sitemapindex>`, server.URL)
+			})
+			mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `<urlset><url><loc>https://www.example.com/</loc></url><url><loc>https://www.example.com/about?lang=en</loc></url></urlset>`)
+			})
+			mux.HandleFunc("/manifest.txt", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "# assets\n/app.js\n\n/index.html\n")
+			})
+			server = httptest.NewServer(mux)
+			DeferCleanup(server.Close)
+		})
+
+		It("should merge the URLs, sitemap and manifest into request URIs", func() {
+			urls, err := warmupURLs(ctx, &cdnv3.CacheWarmupSpec{
+				URLs:        []string{"/index.html", "https://cdn.example.com/index.html"},
+				SitemapURL:  server.URL + "/sitemap.xml",
+				ManifestURL: server.URL + "/manifest.txt",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(urls).To(Equal([]string{"/index.html", "/", "/about?lang=en", "/app.js"}))
+		})
+
+		It("should reject an unreachable manifest", func() {
+			_, err := warmupURLs(ctx, &cdnv3.CacheWarmupSpec{ManifestURL: server.URL + "/missing.txt"})
+			Expect(err).To(MatchError(ContainSubstring("failed to read manifest")))
+		})
+
+		It("should reject a URL without a path", func() {
+			_, err := warmupURLs(ctx, &cdnv3.CacheWarmupSpec{URLs: []string{"https://cdn.example.com"}})
+			Expect(err).To(MatchError(`invalid URL "https://cdn.example.com"`))
+		})
+	})
+})
@@ -0,0 +1,460 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// Key of the account key in the ACME account Secret
+	acmeAccountKeyKey = "account.key"
+	// Certificates are renewed once they have less than this left
+	acmeRenewBefore = 30 * 24 * time.Hour
+	// Time an order has to complete
+	acmeOrderTimeout = 10 * time.Minute
+	// Time a failed order waits before it is retried
+	acmeRetryAfter = 5 * time.Minute
+	// Interval the edges are polled at until they serve the challenges
+	acmeChallengePoll = 5 * time.Second
+)
+
+// acmeOrder is a certificate order run in the background, as it waits on
+// the kubelet to publish the challenges to the edges and on the CA.
+type acmeOrder struct {
+	cancel context.CancelFunc
+	names  []string
+
+	mu       sync.Mutex
+	err      error
+	finished time.Time
+}
+
+func (o *acmeOrder) finish(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.err = err
+	o.finished = time.Now()
+}
+
+// state returns when the order finished, zero while it runs, and why it
+// failed if it did.
+func (o *acmeOrder) state() (time.Time, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.finished, o.err
+}
+
+// reconcileACME orders the certificate of cdn from its ACME CA when its
+// Secret is missing, invalid, doesn't cover its names or is due for
+// renewal.
+func (r *ContentDeliveryNetworkReconciler) reconcileACME(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	key := client.ObjectKeyFromObject(cdn)
+	names := certificateNames(cdn, cdn.Spec.SSLConfig.ACME.DNSNames)
+
+	if order := r.acmeOrder(key); order != nil && slices.Equal(order.names, names) {
+		finished, err := order.state()
+		if finished.IsZero() || (err != nil && time.Since(finished) < acmeRetryAfter) {
+			return nil
+		}
+	}
+	r.stopACMEOrder(key)
+
+	// No order runs, so no challenge is pending
+	if err := r.apply(ctx, cdn, &corev1.ConfigMap{ObjectMeta: acmeChallengesMeta(cdn)}); err != nil {
+		return err
+	}
+
+	due, err := r.acmeRenewalDue(ctx, cdn, names)
+	if err != nil || !due {
+		return err
+	}
+	httpClient, err := acmeHTTPClient(cdn.Spec.SSLConfig.ACME.CABundle)
+	if err != nil {
+		return err
+	}
+	accountKey, err := r.acmeAccountKey(ctx, cdn)
+	if err != nil {
+		return err
+	}
+
+	acmeClient := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: cdn.Spec.SSLConfig.ACME.Server,
+		HTTPClient:   httpClient,
+		UserAgent:    "kube-cdn",
+	}
+	logger := log.FromContext(ctx)
+	orderCtx, cancel := context.WithTimeout(log.IntoContext(context.Background(), logger), acmeOrderTimeout)
+	order := &acmeOrder{cancel: cancel, names: names}
+	r.ordersMu.Lock()
+	if r.orders == nil {
+		r.orders = map[types.NamespacedName]*acmeOrder{}
+	}
+	r.orders[key] = order
+	r.ordersMu.Unlock()
+
+	go func(cdn *cdnv3.ContentDeliveryNetwork) {
+		defer cancel()
+		err := r.orderCertificate(orderCtx, cdn, acmeClient, names)
+		order.finish(err)
+		if err != nil {
+			logger.Error(err, "ACME order failed", "names", names)
+			r.Recorder.Eventf(cdn, corev1.EventTypeWarning, "OrderFailed", "Failed to order the certificate: %v", err)
+			return
+		}
+		logger.Info("Issued certificate", "names", names)
+		r.Recorder.Eventf(cdn, corev1.EventTypeNormal, "Issued", "Issued the certificate for %s", strings.Join(names, ", "))
+	}(cdn.DeepCopy())
+
+	logger.Info("Ordering certificate", "server", acmeClient.DirectoryURL, "names", names)
+	return nil
+}
+
+// removeACME stops the order of cdn and deletes its challenges and account.
+func (r *ContentDeliveryNetworkReconciler) removeACME(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	r.stopACMEOrder(client.ObjectKeyFromObject(cdn))
+	if err := deleteOwned(ctx, r.Client, cdn, &corev1.ConfigMap{ObjectMeta: acmeChallengesMeta(cdn)}); err != nil {
+		return err
+	}
+	return deleteOwned(ctx, r.Client, cdn, &corev1.Secret{ObjectMeta: acmeAccountMeta(cdn)})
+}
+
+func (r *ContentDeliveryNetworkReconciler) acmeOrder(key types.NamespacedName) *acmeOrder {
+	r.ordersMu.Lock()
+	defer r.ordersMu.Unlock()
+	return r.orders[key]
+}
+
+func (r *ContentDeliveryNetworkReconciler) stopACMEOrder(key types.NamespacedName) {
+	r.ordersMu.Lock()
+	defer r.ordersMu.Unlock()
+	if order, ok := r.orders[key]; ok {
+		order.cancel()
+		delete(r.orders, key)
+	}
+}
+
+// acmeOrderError returns why the last order of cdn failed, if it did.
+func (r *ContentDeliveryNetworkReconciler) acmeOrderError(cdn *cdnv3.ContentDeliveryNetwork) error {
+	order := r.acmeOrder(client.ObjectKeyFromObject(cdn))
+	if order == nil {
+		return nil
+	}
+	_, err := order.state()
+	return err
+}
+
+// ConfigMap the pending HTTP-01 challenge responses are published to the
+// edges in, by token
+func acmeChallengesMeta(cdn *cdnv3.ContentDeliveryNetwork) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: cdn.Name + "-acme", Namespace: cdn.Namespace}
+}
+
+// Secret holding the key of the ACME account of cdn
+func acmeAccountMeta(cdn *cdnv3.ContentDeliveryNetwork) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: cdn.Name + "-acme-account", Namespace: cdn.Namespace}
+}
+
+// acmeRenewalDue reports whether the certificate in the Secret of cdn needs
+// to be ordered: it is missing, invalid, misses one of names or expires
+// within acmeRenewBefore.
+func (r *ContentDeliveryNetworkReconciler) acmeRenewalDue(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, names []string) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: tlsSecretName(cdn), Namespace: cdn.Namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return true, nil
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil || time.Until(leaf.NotAfter) < acmeRenewBefore {
+		return true, nil
+	}
+	for _, name := range names {
+		if leaf.VerifyHostname(name) != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// acmeAccountKey returns the key of the ACME account of cdn, generating it
+// on first use.
+func (r *ContentDeliveryNetworkReconciler) acmeAccountKey(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) (*ecdsa.PrivateKey, error) {
+	secret := &corev1.Secret{ObjectMeta: acmeAccountMeta(cdn)}
+	err := r.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+	if err == nil {
+		block, _ := pem.Decode(secret.Data[acmeAccountKeyKey])
+		if block == nil {
+			return nil, fmt.Errorf("Secret %s holds no PEM account key", secret.Name)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ecKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	secret.Data = map[string][]byte{acmeAccountKeyKey: keyPEM}
+	return key, r.apply(ctx, cdn, secret)
+}
+
+// acmeHTTPClient returns the client to reach the ACME directory with, which
+// trusts caBundle if set.
+func acmeHTTPClient(caBundle []byte) (*http.Client, error) {
+	if len(caBundle) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("sslConfig.acme.caBundle holds no PEM certificate")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// orderCertificate orders a certificate for names, publishes the HTTP-01
+// challenges to the edges of cdn, waits for them to serve the challenges
+// before the CA checks them, and stores the issued certificate and its key
+// in the Secret of cdn.
+func (r *ContentDeliveryNetworkReconciler) orderCertificate(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, acmeClient *acme.Client, names []string) error {
+	// Registering the key of an existing account looks it up
+	account := &acme.Account{}
+	if email := cdn.Spec.SSLConfig.ACME.Email; email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	if _, err := acmeClient.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("failed to register account: %w", err)
+	}
+
+	order, err := acmeClient.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+
+	// Authorizations the account already holds need no challenge
+	var pending []*acme.Challenge
+	var authzURLs []string
+	responses := map[string]string{}
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := acmeClient.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		challenge := http01Challenge(authz)
+		if challenge == nil {
+			return fmt.Errorf("no HTTP-01 challenge offered for %s", authz.Identifier.Value)
+		}
+		response, err := acmeClient.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+		responses[challenge.Token] = response
+		pending = append(pending, challenge)
+		authzURLs = append(authzURLs, authzURL)
+	}
+
+	if len(pending) > 0 {
+		if err := r.apply(ctx, cdn, &corev1.ConfigMap{ObjectMeta: acmeChallengesMeta(cdn), Data: responses}); err != nil {
+			return err
+		}
+		defer func() {
+			// A canceled order was replaced or removed along with its challenges
+			if ctx.Err() == nil {
+				if err := r.apply(ctx, cdn, &corev1.ConfigMap{ObjectMeta: acmeChallengesMeta(cdn)}); err != nil {
+					log.FromContext(ctx).Error(err, "Failed to clear ACME challenges")
+				}
+			}
+		}()
+		if err := r.waitForChallenges(ctx, cdn, responses); err != nil {
+			return err
+		}
+		for _, challenge := range pending {
+			if _, err := acmeClient.Accept(ctx, challenge); err != nil {
+				return fmt.Errorf("failed to accept challenge: %w", err)
+			}
+		}
+		for _, authzURL := range authzURLs {
+			if _, err := acmeClient.WaitAuthorization(ctx, authzURL); err != nil {
+				return err
+			}
+		}
+	}
+
+	if order, err = acmeClient.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := acmeClient.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM, err := ecKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	return r.apply(ctx, cdn, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: tlsSecretName(cdn), Namespace: cdn.Namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	})
+}
+
+// waitForChallenges polls the ready edge pods of cdn until each serves every
+// challenge response, as the kubelet takes up to a minute to update the
+// mounted ConfigMap.
+func (r *ContentDeliveryNetworkReconciler) waitForChallenges(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, responses map[string]string) error {
+	httpClient := &http.Client{
+		Timeout:       10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	ticker := time.NewTicker(acmeChallengePoll)
+	defer ticker.Stop()
+	for {
+		pods, err := readyEdgePods(ctx, r, cdn)
+		if err != nil {
+			return err
+		}
+		served := len(pods) > 0
+		for _, pod := range pods {
+			for token, response := range responses {
+				if !servesChallenge(ctx, httpClient, cdn.Spec.DomainName, pod, token, response) {
+					served = false
+				}
+			}
+		}
+		if served {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("edges did not serve the ACME challenges: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// servesChallenge reports whether pod answers the challenge token with
+// response, as the CA will request it.
+func servesChallenge(ctx context.Context, httpClient *http.Client, host string, pod *corev1.Pod, token, response string) bool {
+	target := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(edgePort)) + "/.well-known/acme-challenge/" + token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	req.Host = host
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return err == nil && resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == response
+}
+
+func http01Challenge(authz *acme.Authorization) *acme.Challenge {
+	for _, challenge := range authz.Challenges {
+		if challenge.Type == "http-01" {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// certificateNames returns the domain name of cdn followed by the other
+// names, without duplicates.
+func certificateNames(cdn *cdnv3.ContentDeliveryNetwork, names []string) []string {
+	all := []string{cdn.Spec.DomainName}
+	for _, name := range names {
+		if !slices.Contains(all, name) {
+			all = append(all, name)
+		}
+	}
+	return all
+}
+
+func ecKeyPEM(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// Per pod edge metrics, served to the HorizontalPodAutoscaler by a
+	// custom metrics adapter
+	edgeRequestsMetric  = "cdn_requests_per_second"
+	edgeBandwidthMetric = "cdn_bandwidth_bytes_per_second"
+	edgeCacheFillMetric = "cdn_cache_fill_ratio"
+
+	// Prometheus queries KEDA computes the same metrics with, from the
+	// series scraped off the metrics Service of a CDN
+	edgeRequestsQuery  = `sum(rate(cdn_requests_total{namespace=%q,service=%q}[2m]))`
+	edgeBandwidthQuery = `sum(rate(cdn_response_bytes_total{namespace=%q,service=%q}[2m]))`
+	edgeCacheFillQuery = `avg(cdn_cache_bytes{tier="disk",namespace=%q,service=%q} / cdn_cache_capacity_bytes{tier="disk",namespace=%q,service=%q})`
+)
+
+// ScaledObject of KEDA, handled unstructured so the CRD stays optional
+var scaledObjectGVK = schema.GroupVersionKind{Group: "keda.sh", Version: "v1alpha1", Kind: "ScaledObject"}
+
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;create;update;patch;delete
+
+// autoScale scales the edge workload of cdn between MinReplicas and
+// MaxReplicas, through a HorizontalPodAutoscaler, or a KEDA ScaledObject when
+// asked for, removing the other one.
+func (r *ContentDeliveryNetworkReconciler) autoScale(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	scaling := autoscalingSpec(cdn)
+	objectMeta := metav1.ObjectMeta{Name: cdn.Name + "-edge", Namespace: cdn.Namespace}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: objectMeta}
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetGroupVersionKind(scaledObjectGVK)
+	scaledObject.SetName(objectMeta.Name)
+	scaledObject.SetNamespace(objectMeta.Namespace)
+
+	if scaling.KEDA == nil {
+		if err := deleteOwned(ctx, r.Client, cdn, scaledObject); err != nil {
+			return err
+		}
+		hpa.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: edgeScaleTarget(cdn),
+			MinReplicas:    &[]int32{edgeMinReplicas(cdn)}[0],
+			MaxReplicas:    edgeMaxReplicas(cdn),
+			Metrics:        edgeMetrics(scaling),
+			Behavior:       scaling.Behavior,
+		}
+		return r.apply(ctx, cdn, hpa)
+	}
+
+	// KEDA manages a HorizontalPodAutoscaler of its own
+	if err := deleteOwned(ctx, r.Client, cdn, hpa); err != nil {
+		return err
+	}
+	spec, err := scaledObjectSpec(cdn, scaling)
+	if err != nil {
+		return err
+	}
+	scaledObject.Object["spec"] = spec
+	err = r.apply(ctx, cdn, scaledObject)
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("KEDA ScaledObject CRD is not installed: %w", err)
+	}
+	return err
+}
+
+// autoscalingSpec returns the autoscaling of cdn with the defaults filled
+// in, for objects created before the CRD defaults existed.
+func autoscalingSpec(cdn *cdnv3.ContentDeliveryNetwork) cdnv3.CDNAutoscaling {
+	var scaling cdnv3.CDNAutoscaling
+	if cdn.Spec.Autoscaling != nil {
+		scaling = *cdn.Spec.Autoscaling
+	}
+	if scaling.TargetCPUUtilization == nil {
+		utilization := int32(70)
+		scaling.TargetCPUUtilization = &utilization
+	}
+	return scaling
+}
+
+func edgeMinReplicas(cdn *cdnv3.ContentDeliveryNetwork) int32 {
+	return int32(max(cdn.Spec.MinReplicas, 1))
+}
+
+func edgeMaxReplicas(cdn *cdnv3.ContentDeliveryNetwork) int32 {
+	return max(int32(cdn.Spec.MaxReplicas), edgeMinReplicas(cdn))
+}
+
+// edgeScaleTarget refers to the edge workload of the storage mode of cdn.
+func edgeScaleTarget(cdn *cdnv3.ContentDeliveryNetwork) autoscalingv2.CrossVersionObjectReference {
+	if storageSpec(cdn).Mode == cdnv3.CDNStorageModeShared {
+		return autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: cdn.Name + "-deployment"}
+	}
+	return autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: cdn.Name + "-edge"}
+}
+
+// edgeMetrics returns the HorizontalPodAutoscaler metrics of the targets.
+func edgeMetrics(scaling cdnv3.CDNAutoscaling) []autoscalingv2.MetricSpec {
+	metrics := []autoscalingv2.MetricSpec{
+		{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: scaling.TargetCPUUtilization,
+				},
+			},
+		},
+	}
+	pods := func(name string, target resource.Quantity) {
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: name},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: &target,
+				},
+			},
+		})
+	}
+	if scaling.TargetRequestsPerSecond != nil {
+		pods(edgeRequestsMetric, *scaling.TargetRequestsPerSecond)
+	}
+	if scaling.TargetBandwidth != nil {
+		pods(edgeBandwidthMetric, *scaling.TargetBandwidth)
+	}
+	if scaling.TargetCacheFill != nil {
+		pods(edgeCacheFillMetric, *resource.NewMilliQuantity(int64(*scaling.TargetCacheFill)*10, resource.DecimalSI))
+	}
+	return metrics
+}
+
+// scaledObjectSpec returns the spec of the KEDA ScaledObject scaling the
+// edges of cdn on the targets.
+func scaledObjectSpec(cdn *cdnv3.ContentDeliveryNetwork, scaling cdnv3.CDNAutoscaling) (map[string]interface{}, error) {
+	target := edgeScaleTarget(cdn)
+	service := cdn.Name + "-metrics"
+	triggers := []interface{}{
+		map[string]interface{}{
+			"type":       "cpu",
+			"metricType": "Utilization",
+			"metadata":   map[string]interface{}{"value": strconv.Itoa(int(*scaling.TargetCPUUtilization))},
+		},
+	}
+	prometheus := func(metricType, query, threshold string) {
+		triggers = append(triggers, map[string]interface{}{
+			"type":       "prometheus",
+			"metricType": metricType,
+			"metadata": map[string]interface{}{
+				"serverAddress": scaling.KEDA.ServerAddress,
+				"query":         query,
+				"threshold":     threshold,
+			},
+		})
+	}
+	// Sums are averaged over the pods, the cache fill already is an average
+	if scaling.TargetRequestsPerSecond != nil {
+		prometheus("AverageValue", fmt.Sprintf(edgeRequestsQuery, cdn.Namespace, service), scaling.TargetRequestsPerSecond.AsDec().String())
+	}
+	if scaling.TargetBandwidth != nil {
+		prometheus("AverageValue", fmt.Sprintf(edgeBandwidthQuery, cdn.Namespace, service), scaling.TargetBandwidth.AsDec().String())
+	}
+	if scaling.TargetCacheFill != nil {
+		prometheus("Value", fmt.Sprintf(edgeCacheFillQuery, cdn.Namespace, service, cdn.Namespace, service), strconv.FormatFloat(float64(*scaling.TargetCacheFill)/100, 'f', -1, 64))
+	}
+
+	spec := map[string]interface{}{
+		"scaleTargetRef": map[string]interface{}{
+			"apiVersion": target.APIVersion,
+			"kind":       target.Kind,
+			"name":       target.Name,
+		},
+		"minReplicaCount": int64(edgeMinReplicas(cdn)),
+		"maxReplicaCount": int64(edgeMaxReplicas(cdn)),
+		"triggers":        triggers,
+	}
+	if scaling.Behavior != nil {
+		behavior, err := runtime.DefaultUnstructuredConverter.ToUnstructured(scaling.Behavior)
+		if err != nil {
+			return nil, err
+		}
+		spec["advanced"] = map[string]interface{}{
+			"horizontalPodAutoscalerConfig": map[string]interface{}{"behavior": behavior},
+		}
+	}
+	return spec, nil
+}
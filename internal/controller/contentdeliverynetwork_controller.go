@@ -18,40 +18,84 @@ package controller
 
 import (
 	"context"
-	"math"
-	"strconv"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
-
 	cdnv3 "github.com/benauro/kube-cdn/api/v3"
 )
 
+const (
+	// Edge config rendered from the CDN spec, read by the cdn binary
+	edgeConfigKey       = "spec.json"
+	edgeConfigMountPath = "/etc/kube-cdn/config"
+	// GeoIP databases, read by the cdn binary
+	geoIPMountPath = "/etc/kube-cdn/geoip"
+	// TLS Secrets, one directory each, read by the cdn binary
+	tlsMountPath = "/etc/kube-cdn/tls"
+	// Pending ACME HTTP-01 challenge responses, read by the cdn binary
+	acmeMountPath = "/etc/kube-cdn/acme"
+	// Port the cdn binary listens on
+	edgePort = 8080
+
+	// Tells the pods run for the CDN itself from those of its nodes, which
+	// share its "app" label
+	edgeRoleLabel = "cdn.benauro.gg/role"
+	edgeRoleEdge  = "edge"
+	edgeRoleNode  = "node"
+)
+
 // ContentDeliveryNetworkReconciler reconciles a ContentDeliveryNetwork object
 type ContentDeliveryNetworkReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Previous stats scrape of each edge pod, by CDN
+	samplesMu sync.Mutex
+	samples   map[types.NamespacedName]map[types.UID]*edgeStats
+
+	// ACME certificate orders, by CDN
+	ordersMu sync.Mutex
+	orders   map[types.NamespacedName]*acmeOrder
 }
 
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworks,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworks/finalizers,verbs=update
 
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworknodes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cdi.kubevirt.io,resources=datavolumes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,57 +111,76 @@ func (r *ContentDeliveryNetworkReconciler) Reconcile(ctx context.Context, req ct
 
 	var cdn cdnv3.ContentDeliveryNetwork
 	if err := r.Get(ctx, req.NamespacedName, &cdn); err != nil {
+		if errors.IsNotFound(err) {
+			r.forgetMetrics(req.NamespacedName)
+			r.stopACMEOrder(req.NamespacedName)
+		}
 		logger.Error(err, "Unable to fetch ContentDeliveryNetwork")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
 	// Apply caching rules
 	if err := r.applyCacheRules(&cdn); err != nil {
-		logger.Error(err, "Failed to apply cache rules")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to apply cache rules")
 	}
 
-	// Handle ingress
-	if err := r.reconcileIngress(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to reconcile ingress")
-		return ctrl.Result{}, err
+	// Handle edge config
+	if err := r.reconcileEdgeConfig(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile edge config")
+	}
+
+	// Handle certificate
+	if err := r.reconcileCertificate(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile certificate")
+	}
+
+	// Handle exposure
+	if err := r.reconcileExposure(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile exposure")
 	}
 
 	// Handle service
 	if err := r.reconcileService(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to reconcile service")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to reconcile service")
+	}
+
+	// Handle monitoring
+	if err := r.reconcileMonitoring(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile monitoring")
+	}
+
+	// Handle nodes
+	if err := r.reconcileNodes(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile CDN nodes")
+	}
+
+	// Handle edges
+	if err := r.reconcileEdges(ctx, &cdn); err != nil {
+		return r.fail(ctx, &cdn, err, "Failed to reconcile edges")
 	}
 
 	// Auto scaling
 	if err := r.autoScale(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to auto-scale CDN nodes")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to auto-scale CDN nodes")
 	}
 
 	// Handle networking
 	if err := r.reconcileNetworking(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to reconcile networking")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to reconcile networking")
 	}
 
 	// Handle storage
 	if err := r.reconcileStorage(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to reconcile storage")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to reconcile storage")
 	}
 
 	// Update metrics
 	if err := r.updateMetrics(ctx, &cdn); err != nil {
-		logger.Error(err, "Failed to update metrics")
-		return ctrl.Result{}, err
+		return r.fail(ctx, &cdn, err, "Failed to update metrics")
 	}
 
-	// Updata status
-	cdn.Status.State = "Ready"
-	cdn.Status.LastUpdated = metav1.Now()
-
-	if err := r.Status().Update(ctx, &cdn); err != nil {
+	// Update status
+	if err := r.updateStatus(ctx, &cdn); err != nil {
 		logger.Error(err, "Unable to update ContentDeliveryNetwork status")
 		return ctrl.Result{}, err
 	}
@@ -128,12 +191,35 @@ func (r *ContentDeliveryNetworkReconciler) Reconcile(ctx context.Context, req ct
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ContentDeliveryNetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&cdnv3.ContentDeliveryNetwork{}).
 		Owns(&networkingv1.Ingress{}).
 		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
 		Owns(&appsv1.Deployment{}).
-		Complete(r)
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&cdnv3.ContentDeliveryNetworkNode{})
+
+	// Gateway API kinds are watched when installed, a watch on a missing
+	// CRD would keep the manager from starting
+	installed, err := kindInstalled(mgr.GetRESTMapper(), httpRouteGVK)
+	if err != nil {
+		return err
+	}
+	if installed {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(httpRouteGVK)
+		gateway := &unstructured.Unstructured{}
+		gateway.SetGroupVersionKind(gatewayGVK)
+		builder = builder.
+			Owns(route).
+			Watches(gateway, handler.EnqueueRequestsFromMapFunc(r.gatewayRequests))
+	}
+	return builder.Complete(r)
 }
 
 func (r *ContentDeliveryNetworkReconciler) applyCacheRules(cdn *cdnv3.ContentDeliveryNetwork) error {
@@ -142,6 +228,60 @@ func (r *ContentDeliveryNetworkReconciler) applyCacheRules(cdn *cdnv3.ContentDel
 	return nil
 }
 
+// reconcileEdgeConfig renders the CDN spec into the ConfigMap the edge nodes
+// load their routing, caching and access rules from.
+func (r *ContentDeliveryNetworkReconciler) reconcileEdgeConfig(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	if err := validateEdgeRules(cdn); err != nil {
+		return err
+	}
+
+	// The edges read their certificates from the mounted Secrets, the
+	// deprecated inline pair stays out of the ConfigMap
+	edgeSpec := cdn.Spec.DeepCopy()
+	if ssl := edgeSpec.SSLConfig; ssl != nil {
+		ssl.Cert, ssl.Key = "", ""
+	}
+	spec, err := json.Marshal(edgeSpec)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cdn.Name + "-config",
+			Namespace: cdn.Namespace,
+		},
+		Data: map[string]string{
+			edgeConfigKey: string(spec),
+		},
+	}
+
+	return r.apply(ctx, cdn, configMap)
+}
+
+// validateEdgeRules rejects rules the edge would fail to load, so a broken
+// spec never replaces a working edge config.
+func validateEdgeRules(cdn *cdnv3.ContentDeliveryNetwork) error {
+	for _, rule := range cdn.Spec.Redirects {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("invalid redirect %q: %w", rule.Match, err)
+		}
+	}
+	for _, rule := range cdn.Spec.Rewrites {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("invalid rewrite %q: %w", rule.Match, err)
+		}
+	}
+	if ssl := cdn.Spec.SSLConfig; ssl != nil {
+		for _, name := range ssl.CipherSuites {
+			if !knownCipherSuite(name) {
+				return fmt.Errorf("unknown cipher suite %q", name)
+			}
+		}
+	}
+	return nil
+}
+
 func (r *ContentDeliveryNetworkReconciler) reconcileService(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -154,105 +294,68 @@ func (r *ContentDeliveryNetworkReconciler) reconcileService(ctx context.Context,
 				{
 					Name:       "http",
 					Port:       80,
-					TargetPort: intstr.FromInt(80),
+					TargetPort: intstr.FromInt32(edgePort),
 					Protocol:   corev1.ProtocolTCP,
 				},
 			},
-			Type: corev1.ServiceTypeLoadBalancer,
+			Type: corev1.ServiceTypeClusterIP,
 		},
 	}
-
-	err := r.Create(ctx, service)
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return err
+	// Clients reach the Service itself only without an Ingress or Gateway
+	if exposure := exposureSpec(cdn); exposure.Mode == cdnv3.CDNExposureLoadBalancer {
+		service.Spec.Type = corev1.ServiceTypeLoadBalancer
+		service.Annotations = exposure.Annotations
 	}
-
-	if errors.IsAlreadyExists(err) {
-		return r.Update(ctx, service)
+	if tlsEnabled(cdn) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "https",
+			Port:       443,
+			TargetPort: intstr.FromInt32(edgeTLSPort),
+			Protocol:   corev1.ProtocolTCP,
+		})
 	}
 
-	return nil
+	return r.apply(ctx, cdn, service)
 }
 
-func (r *ContentDeliveryNetworkReconciler) autoScale(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
-	// Get current deployment
-	deployment := &appsv1.Deployment{}
-	err := r.Get(ctx, client.ObjectKey{Namespace: cdn.Namespace, Name: cdn.Name + "-deployment"}, deployment)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Creat one if not found
-			return r.createCDNDeployment(ctx, cdn)
+// reconcileNodes creates the ContentDeliveryNetworkNodes listed in the spec
+// of cdn and deletes those it no longer lists, which drain before they go.
+func (r *ContentDeliveryNetworkReconciler) reconcileNodes(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	listed := map[string]bool{}
+	for i := range cdn.Spec.CDNNodes {
+		spec := &cdn.Spec.CDNNodes[i]
+		if spec.Name == "" {
+			return fmt.Errorf("cdnNodes[%d] has no name", i)
 		}
-		return err
-	}
+		listed[spec.Name] = true
 
-	// Decide whether to auto-scale or not
-	desiredReplicas := int32(calculateDesiredReplicas(cdn))
-
-	if *deployment.Spec.Replicas != desiredReplicas {
-		deployment.Spec.Replicas = &desiredReplicas
-		if err := r.Update(ctx, deployment); err != nil {
+		node := &cdnv3.ContentDeliveryNetworkNode{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      spec.Name,
+				Namespace: cdn.Namespace,
+				Labels:    spec.Labels,
+			},
+			Spec: spec.Spec,
+		}
+		node.Spec.CDNRef = cdn.Name
+		if err := r.apply(ctx, cdn, node); err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func (r *ContentDeliveryNetworkReconciler) updateMetrics(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
-	// TODO:
-	_, _ = ctx, cdn
-	return nil
-}
-
-func (r *ContentDeliveryNetworkReconciler) reconcileIngress(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
-	ingress := &networkingv1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      cdn.Name + "-ingress",
-			Namespace: cdn.Namespace,
-			Annotations: map[string]string{
-				"nginx.ingress.kubernetes.io/rewrite-target": "/$1",
-			},
-		},
-		Spec: networkingv1.IngressSpec{
-			Rules: []networkingv1.IngressRule{
-				{
-					Host: cdn.Spec.DomainName,
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									PathType: func() *networkingv1.PathType {
-										t := networkingv1.PathTypePrefix
-										return &t
-									}(),
-									Path: "/",
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: cdn.Name + "-service",
-											Port: networkingv1.ServiceBackendPort{
-												Number: 80,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	err := r.Create(ctx, ingress)
-	if err != nil && !errors.IsAlreadyExists(err) {
+	var nodes cdnv3.ContentDeliveryNetworkNodeList
+	if err := r.List(ctx, &nodes, client.InNamespace(cdn.Namespace)); err != nil {
 		return err
 	}
-
-	if errors.IsAlreadyExists(err) {
-		return r.Update(ctx, ingress)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if listed[node.Name] || !metav1.IsControlledBy(node, cdn) || node.DeletionTimestamp != nil {
+			continue
+		}
+		if err := r.Delete(ctx, node); client.IgnoreNotFound(err) != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
@@ -269,116 +372,282 @@ func (r *ContentDeliveryNetworkReconciler) reconcileNetworking(ctx context.Conte
 			Ingress: []networkingv1.NetworkPolicyIngressRule{
 				{
 					Ports: []networkingv1.NetworkPolicyPort{
-						{Port: &intstr.IntOrString{Type: intstr.Int, IntVal: 80}},
+						{Port: &intstr.IntOrString{Type: intstr.Int, IntVal: edgePort}},
+						{Port: &intstr.IntOrString{Type: intstr.Int, IntVal: edgeStatsPort}},
 					},
 				},
 			},
 		},
 	}
-
-	if err := r.Create(ctx, networkPolicy); err != nil {
-		return err
+	if tlsEnabled(cdn) {
+		rule := &networkPolicy.Spec.Ingress[0]
+		rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Port: &intstr.IntOrString{Type: intstr.Int, IntVal: edgeTLSPort}})
 	}
 
-	return nil
+	return r.apply(ctx, cdn, networkPolicy)
 }
 
-func (r *ContentDeliveryNetworkReconciler) reconcileStorage(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
-	// Create or update DataVolume
-	dataVolume := &cdiv1.DataVolume{
+func (r *ContentDeliveryNetworkReconciler) reconcileDeployment(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	podSpec := edgePodSpec(cdn)
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, cacheEnv(storageSpec(cdn).Size, cdn.Spec.MaxReplicas)...)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "content",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: cdn.Name + "-storage",
+			},
+		},
+	})
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cdn.Name + "-data",
+			Name:      cdn.Name + "-deployment",
 			Namespace: cdn.Namespace,
 		},
-		Spec: cdiv1.DataVolumeSpec{
-			Source: &cdiv1.DataVolumeSource{
-				Blank: &cdiv1.DataVolumeBlankImage{},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: edgeLabels(cdn),
 			},
-			PVC: &corev1.PersistentVolumeClaimSpec{
-				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-				Resources: corev1.VolumeResourceRequirements{
-					Requests: corev1.ResourceList{
-						corev1.ResourceStorage: resource.MustParse("10Gi"),
-					},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: edgeLabels(cdn),
 				},
+				Spec: podSpec,
 			},
 		},
 	}
 
-	err := r.Create(ctx, dataVolume)
-	if err != nil && !errors.IsAlreadyExists(err) {
+	if err := replaceOnSelectorChange(ctx, r.Client, cdn, deployment); err != nil {
 		return err
 	}
-
-	if errors.IsAlreadyExists(err) {
-		return r.Update(ctx, dataVolume)
+	replicas, err := r.edgeReplicas(ctx, cdn, deployment)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	deployment.Spec.Replicas = replicas
+	return r.apply(ctx, cdn, deployment)
 }
 
-func calculateDesiredReplicas(cdn *cdnv3.ContentDeliveryNetwork) int {
-	requestsPerReplica := 100.0 // Assume each replica can handle 100 QPS
-	requestsPerSecond, _ := strconv.ParseFloat(cdn.Status.Metrics.RequestsPerSecond, 64)
-	desiredReplicas := int(math.Ceil(requestsPerSecond / requestsPerReplica))
+// reconcileEdgeStatefulSet runs the edge pods of cdn with a cache volume
+// claimed per pod. Claims are deleted with the StatefulSet, but kept when it
+// scales down, so that a pod coming back finds its cache.
+func (r *ContentDeliveryNetworkReconciler) reconcileEdgeStatefulSet(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	storage := storageSpec(cdn)
+	podSpec := edgePodSpec(cdn)
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, cacheEnv(storage.Size, 1)...)
 
-	if desiredReplicas < cdn.Spec.MinReplicas {
-		return cdn.Spec.MinReplicas
-	}
-	if desiredReplicas > cdn.Spec.MaxReplicas {
-		return cdn.Spec.MaxReplicas
-	}
-	return desiredReplicas
-}
-
-func (r *ContentDeliveryNetworkReconciler) createCDNDeployment(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
-	replicas := int32(cdn.Spec.MinReplicas)
-	deployment := &appsv1.Deployment{
+	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cdn.Name + "-deployment",
+			Name:      cdn.Name + "-edge",
 			Namespace: cdn.Namespace,
 		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
+		Spec: appsv1.StatefulSetSpec{
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": cdn.Name},
+				MatchLabels: edgeLabels(cdn),
 			},
+			ServiceName: cdn.Name + "-service",
+			// Edges share nothing, no need to start them one by one
+			PodManagementPolicy: appsv1.ParallelPodManagement,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": cdn.Name},
+					Labels: edgeLabels(cdn),
+				},
+				Spec: podSpec,
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "content"},
+					Spec:       cacheClaimSpec(storage, storage.Size),
 				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "cdn-node",
-							Image: "benauro/kube-cdn:latest",
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "content",
-									MountPath: "/data",
-								},
-							},
-							ImagePullPolicy: cdn.Spec.ImagePullPolicy, // Use imagePullPolicy from CDN spec
+			},
+			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+				WhenDeleted: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+				WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+
+	if err := replaceOnSelectorChange(ctx, r.Client, cdn, statefulSet); err != nil {
+		return err
+	}
+	replicas, err := r.edgeReplicas(ctx, cdn, statefulSet)
+	if err != nil {
+		return err
+	}
+	statefulSet.Spec.Replicas = replicas
+	return r.apply(ctx, cdn, statefulSet)
+}
+
+// edgeLabels returns the labels of the pods run for cdn itself, leaving out
+// those of its nodes.
+func edgeLabels(cdn *cdnv3.ContentDeliveryNetwork) map[string]string {
+	return map[string]string{"app": cdn.Name, edgeRoleLabel: edgeRoleEdge}
+}
+
+// edgePodSpec returns the pod spec running the cdn binary for cdn. The
+// caller adds the "content" volume holding the disk cache, mounted at /data.
+func edgePodSpec(cdn *cdnv3.ContentDeliveryNetwork) corev1.PodSpec {
+	// The autoscaler scales on CPU utilization of the request
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+	if cdn.Spec.Resources != nil {
+		resources = *cdn.Spec.Resources
+	}
+	podSpec := corev1.PodSpec{
+		Containers: []corev1.Container{
+			{
+				Name:  "cdn-node",
+				Image: "benauro/kube-cdn:latest",
+				Ports: []corev1.ContainerPort{
+					{Name: "http", ContainerPort: edgePort, Protocol: corev1.ProtocolTCP},
+					{Name: "https", ContainerPort: edgeTLSPort, Protocol: corev1.ProtocolTCP},
+					{Name: "stats", ContainerPort: edgeStatsPort, Protocol: corev1.ProtocolTCP},
+				},
+				Env: []corev1.EnvVar{
+					{
+						// Exposed to header rules as ${pod_name}
+						Name: "POD_NAME",
+						ValueFrom: &corev1.EnvVarSource{
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
 						},
 					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "content",
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: cdn.Name + "-storage",
-								},
-							},
-						},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "content",
+						MountPath: "/data",
+					},
+					{
+						Name:      "config",
+						MountPath: edgeConfigMountPath,
+						ReadOnly:  true,
+					},
+				},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt32(edgePort)},
+					},
+				},
+				Resources:       resources,
+				ImagePullPolicy: cdn.Spec.ImagePullPolicy, // Use imagePullPolicy from CDN spec
+			},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: cdn.Name + "-config"},
 					},
 				},
 			},
 		},
 	}
 
-	if err := r.Create(ctx, deployment); err != nil {
-		return err
+	if len(cdn.Spec.TrustedProxies) > 0 {
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+			Name:  "CDN_TRUSTED_PROXIES",
+			Value: strings.Join(cdn.Spec.TrustedProxies, ","),
+		})
 	}
+	if geo := cdn.Spec.GeoRestrictions; geo != nil {
+		addGeoIPVolume(&podSpec, &geo.Database)
+	}
+	if tlsEnabled(cdn) {
+		addTLSVolumes(&podSpec, cdn)
+		if cdn.Spec.SSLConfig.ACME != nil {
+			addACMEVolume(&podSpec, cdn)
+		}
+	}
+	return podSpec
+}
 
-	return nil
+// addGeoIPVolume mounts the GeoIP databases into the first container of
+// podSpec at geoIPMountPath.
+func addGeoIPVolume(podSpec *corev1.PodSpec, db *cdnv3.GeoIPDatabase) {
+	source := geoIPVolumeSource(db)
+	if source == nil {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{Name: "geoip", VolumeSource: *source})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "geoip",
+		MountPath: geoIPMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// geoIPVolumeSource returns the volume holding the GeoIP databases, or nil
+// when none is configured.
+func geoIPVolumeSource(db *cdnv3.GeoIPDatabase) *corev1.VolumeSource {
+	switch {
+	case db.ConfigMapName != "":
+		return &corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: db.ConfigMapName},
+			},
+		}
+	case db.ClaimName != "":
+		return &corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: db.ClaimName,
+				ReadOnly:  true,
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// edgePods returns the pods serving cdn which have an address and are not
+// shutting down, those of its nodes included.
+func edgePods(ctx context.Context, c client.Reader, cdn *cdnv3.ContentDeliveryNetwork) ([]*corev1.Pod, error) {
+	roles, err := labels.NewRequirement(edgeRoleLabel, selection.In, []string{edgeRoleEdge, edgeRoleNode})
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(labels.Set{"app": cdn.Name}).Add(*roles)
+
+	var list corev1.PodList
+	if err := c.List(ctx, &list, client.InNamespace(cdn.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	var pods []*corev1.Pod
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp == nil && pod.Status.PodIP != "" {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// readyEdgePods returns the pods serving cdn which are ready to serve, those
+// of its nodes included.
+func readyEdgePods(ctx context.Context, c client.Reader, cdn *cdnv3.ContentDeliveryNetwork) ([]*corev1.Pod, error) {
+	pods, err := edgePods(ctx, c, cdn)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := pods[:0]
+	for _, pod := range pods {
+		if podReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	return ready, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
 }
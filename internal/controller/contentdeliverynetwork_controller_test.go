@@ -21,8 +21,16 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -69,8 +77,9 @@ var _ = Describe("ContentDeliveryNetwork Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &ContentDeliveryNetworkReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -81,4 +90,291 @@ var _ = Describe("ContentDeliveryNetwork Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When the edges scale", func() {
+		const resourceName = "scaled-cdn"
+
+		ctx := context.Background()
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+		edgeName := types.NamespacedName{Name: resourceName + "-edge", Namespace: "default"}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cdnv3.ContentDeliveryNetworkSpec{
+					DomainName:  "cdn.example.com",
+					MinReplicas: 3,
+					MaxReplicas: 10,
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+		})
+
+		It("should keep MinReplicas until the autoscaler sets the replicas", func() {
+			controllerReconciler := &ContentDeliveryNetworkReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			reconcileTwice := func() {
+				for i := 0; i < 2; i++ {
+					_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}
+
+			By("Running MinReplicas edges across reconciles")
+			reconcileTwice()
+			statefulSet := &appsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, edgeName, statefulSet)).To(Succeed())
+			Expect(statefulSet.Spec.Replicas).To(HaveValue(BeEquivalentTo(3)))
+
+			By("Leaving the replicas set by the autoscaler")
+			patch := client.MergeFrom(statefulSet.DeepCopy())
+			replicas := int32(7)
+			statefulSet.Spec.Replicas = &replicas
+			Expect(k8sClient.Patch(ctx, statefulSet, patch, client.FieldOwner("horizontal-pod-autoscaler"))).To(Succeed())
+			reconcileTwice()
+			Expect(k8sClient.Get(ctx, edgeName, statefulSet)).To(Succeed())
+			Expect(statefulSet.Spec.Replicas).To(HaveValue(BeEquivalentTo(7)))
+		})
+	})
+
+	Context("When reporting its status", func() {
+		const resourceName = "status-cdn"
+
+		ctx := context.Background()
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cdnv3.ContentDeliveryNetworkSpec{
+					DomainName:  "status.example.com",
+					MinReplicas: 1,
+					MaxReplicas: 3,
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+			})).To(Succeed())
+		})
+
+		It("should set the conditions of the observed generation", func() {
+			controllerReconciler := &ContentDeliveryNetworkReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			reconcileAndGet := func() *cdnv3.ContentDeliveryNetwork {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+				cdn := &cdnv3.ContentDeliveryNetwork{}
+				Expect(k8sClient.Get(ctx, typeNamespacedName, cdn)).To(Succeed())
+				return cdn
+			}
+			expectObserved := func(cdn *cdnv3.ContentDeliveryNetwork) {
+				Expect(cdn.Status.ObservedGeneration).To(Equal(cdn.Generation))
+				for _, conditionType := range []string{
+					CDNConditionReady,
+					CDNConditionOriginReachable,
+					CDNConditionCertificateReady,
+					CDNConditionStorageBound,
+					CDNConditionDNSPublished,
+					CDNConditionDegraded,
+				} {
+					condition := meta.FindStatusCondition(cdn.Status.Conditions, conditionType)
+					Expect(condition).NotTo(BeNil(), conditionType)
+					Expect(condition.ObservedGeneration).To(Equal(cdn.Generation), conditionType)
+				}
+			}
+
+			By("Reporting edges without available pods as pending")
+			cdn := reconcileAndGet()
+			expectObserved(cdn)
+			Expect(meta.IsStatusConditionFalse(cdn.Status.Conditions, CDNConditionReady)).To(BeTrue())
+			Expect(meta.FindStatusCondition(cdn.Status.Conditions, CDNConditionReady).Reason).To(Equal("NoEdgeAvailable"))
+			Expect(cdn.Status.State).To(Equal(CDNStatePending))
+
+			By("Leaving an unchanged status unwritten")
+			Expect(reconcileAndGet().ResourceVersion).To(Equal(cdn.ResourceVersion))
+
+			By("Observing the next generation")
+			cdn.Spec.MaxReplicas = 5
+			Expect(k8sClient.Update(ctx, cdn)).To(Succeed())
+			cdn = reconcileAndGet()
+			Expect(cdn.Generation).To(BeEquivalentTo(2))
+			expectObserved(cdn)
+		})
+	})
+
+	Context("When claiming the edge storage", func() {
+		ctx := context.Background()
+
+		controllerReconciler := func() *ContentDeliveryNetworkReconciler {
+			return &ContentDeliveryNetworkReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+		}
+		createAndReconcile := func(name string, storage *cdnv3.CDNStorage) *cdnv3.ContentDeliveryNetwork {
+			cdn := &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: cdnv3.ContentDeliveryNetworkSpec{
+					DomainName:  name + ".example.com",
+					MinReplicas: 2,
+					MaxReplicas: 4,
+					Storage:     storage,
+				},
+			}
+			Expect(k8sClient.Create(ctx, cdn)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, cdn)).To(Succeed())
+			})
+			_, err := controllerReconciler().Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: name, Namespace: "default"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cdn), cdn)).To(Succeed())
+			return cdn
+		}
+
+		It("should claim a volume of the given size per edge replica", func() {
+			cdn := createAndReconcile("per-replica-cdn", &cdnv3.CDNStorage{
+				Mode: cdnv3.CDNStorageModePerReplica,
+				Size: resource.MustParse("2Gi"),
+			})
+
+			By("Templating the claims of the edge StatefulSet")
+			statefulSet := &appsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "per-replica-cdn-edge", Namespace: "default"}, statefulSet)).To(Succeed())
+			Expect(statefulSet.Spec.VolumeClaimTemplates).To(HaveLen(1))
+			template := statefulSet.Spec.VolumeClaimTemplates[0]
+			Expect(template.Name).To(Equal("content"))
+			Expect(template.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+			Expect(template.Spec.Resources.Requests.Storage().Cmp(resource.MustParse("2Gi"))).To(BeZero())
+
+			By("Naming the claims after the StatefulSet pods")
+			Expect(edgeClaimNames(cdn, 2)).To(Equal([]string{
+				"content-per-replica-cdn-edge-0",
+				"content-per-replica-cdn-edge-1",
+			}))
+
+			By("Leaving the shared claim out")
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "per-replica-cdn-storage", Namespace: "default"}, &corev1.PersistentVolumeClaim{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should claim a single shared volume of the given size", func() {
+			cdn := createAndReconcile("shared-cdn", &cdnv3.CDNStorage{
+				Mode: cdnv3.CDNStorageModeShared,
+				Size: resource.MustParse("5Gi"),
+			})
+
+			By("Claiming the shared volume")
+			claim := &corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "shared-cdn-storage", Namespace: "default"}, claim)).To(Succeed())
+			Expect(claim.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteMany))
+			Expect(claim.Spec.Resources.Requests.Storage().Cmp(resource.MustParse("5Gi"))).To(BeZero())
+			Expect(metav1.IsControlledBy(claim, cdn)).To(BeTrue())
+
+			By("Naming the shared claim once for all edges")
+			Expect(edgeClaimNames(cdn, 2)).To(Equal([]string{"shared-cdn-storage"}))
+			Expect(edgeClaimNames(cdn, 0)).To(BeEmpty())
+		})
+	})
+
+	Context("When applying its children", func() {
+		const resourceName = "owned-cdn"
+
+		ctx := context.Background()
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+		cdn := &cdnv3.ContentDeliveryNetwork{}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: cdnv3.ContentDeliveryNetworkSpec{
+					DomainName:  "owned.example.com",
+					MinReplicas: 1,
+					MaxReplicas: 3,
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cdn)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, cdn)).To(Succeed())
+		})
+
+		It("should own its children and leave them unchanged on the next reconcile", func() {
+			controllerReconciler := &ContentDeliveryNetworkReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			children := []client.Object{
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-config", Namespace: "default"}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-service", Namespace: "default"}},
+				&networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-network-policy", Namespace: "default"}},
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-edge", Namespace: "default"}},
+			}
+			resourceVersions := func() []string {
+				var versions []string
+				for _, child := range children {
+					Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(child), child)).To(Succeed())
+					versions = append(versions, child.GetResourceVersion())
+				}
+				return versions
+			}
+
+			By("Controlling the children")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			applied := resourceVersions()
+			for _, child := range children {
+				Expect(metav1.IsControlledBy(child, cdn)).To(BeTrue(), child.GetName())
+			}
+
+			By("Leaving the children as they are on the next reconcile")
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resourceVersions()).To(Equal(applied))
+		})
+
+		It("should tell a second apply changed nothing", func() {
+			configMap := func() *corev1.ConfigMap {
+				return &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-applied", Namespace: "default"},
+					Data:       map[string]string{"key": "value"},
+				}
+			}
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, configMap()))).To(Succeed())
+			})
+
+			result, err := apply(ctx, k8sClient, cdn, configMap())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(controllerutil.OperationResultCreated))
+
+			result, err = apply(ctx, k8sClient, cdn, configMap())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(controllerutil.OperationResultNone))
+
+			changed := configMap()
+			changed.Data["key"] = "other"
+			result, err = apply(ctx, k8sClient, cdn, changed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(controllerutil.OperationResultUpdated))
+		})
+	})
 })
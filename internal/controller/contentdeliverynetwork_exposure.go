@@ -0,0 +1,252 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+// Gateway API kinds, handled unstructured so the CRDs stay optional
+var (
+	gatewayGVK   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+	httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+)
+
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+
+// exposureSpec returns the exposure of cdn with the defaults filled in, for
+// objects created before the CRD defaults existed.
+func exposureSpec(cdn *cdnv3.ContentDeliveryNetwork) cdnv3.CDNExposure {
+	var exposure cdnv3.CDNExposure
+	if cdn.Spec.Exposure != nil {
+		exposure = *cdn.Spec.Exposure
+	}
+	if exposure.Mode == "" {
+		exposure.Mode = cdnv3.CDNExposureIngress
+	}
+	return exposure
+}
+
+// reconcileExposure routes client traffic for the domain of cdn to its edge
+// Service through an Ingress or an HTTPRoute, removing the one of the other
+// mode. The LoadBalancer mode needs neither.
+func (r *ContentDeliveryNetworkReconciler) reconcileExposure(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	exposure := exposureSpec(cdn)
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetName(cdn.Name + "-route")
+	route.SetNamespace(cdn.Namespace)
+
+	if exposure.Mode != cdnv3.CDNExposureIngress {
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: cdn.Name + "-ingress", Namespace: cdn.Namespace}}
+		if err := deleteOwned(ctx, r.Client, cdn, ingress); err != nil {
+			return err
+		}
+	}
+	if exposure.Mode != cdnv3.CDNExposureGateway {
+		if err := deleteOwned(ctx, r.Client, cdn, route); err != nil {
+			return err
+		}
+	}
+
+	switch exposure.Mode {
+	case cdnv3.CDNExposureIngress:
+		return r.apply(ctx, cdn, edgeIngress(cdn, exposure))
+
+	case cdnv3.CDNExposureGateway:
+		installed, err := kindInstalled(r.RESTMapper(), httpRouteGVK)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			return fmt.Errorf("the Gateway API HTTPRoute CRD is not installed")
+		}
+		route.SetAnnotations(exposure.Annotations)
+		route.Object["spec"] = httpRouteSpec(cdn, exposure.Gateway)
+		return r.apply(ctx, cdn, route)
+	}
+	return nil
+}
+
+// edgeIngress returns the Ingress routing the domain of cdn to its edge
+// Service, terminating TLS with the certificate of cdn.
+func edgeIngress(cdn *cdnv3.ContentDeliveryNetwork, exposure cdnv3.CDNExposure) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cdn.Name + "-ingress",
+			Namespace:   cdn.Namespace,
+			Annotations: exposure.Annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: exposure.IngressClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: cdn.Spec.DomainName,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									PathType: &pathType,
+									Path:     "/",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: cdn.Name + "-service",
+											Port: networkingv1.ServiceBackendPort{
+												Number: 80,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if tlsEnabled(cdn) {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{Hosts: []string{cdn.Spec.DomainName}, SecretName: tlsSecretName(cdn)},
+		}
+	}
+	return ingress
+}
+
+// httpRouteSpec returns the spec of the HTTPRoute attaching the domain of
+// cdn to gateway, with every request sent to the edge Service.
+func httpRouteSpec(cdn *cdnv3.ContentDeliveryNetwork, gateway *cdnv3.GatewayReference) map[string]interface{} {
+	parent := map[string]interface{}{
+		"group": gatewayGVK.Group,
+		"kind":  gatewayGVK.Kind,
+		"name":  gateway.Name,
+	}
+	if gateway.Namespace != "" {
+		parent["namespace"] = gateway.Namespace
+	}
+	if gateway.SectionName != "" {
+		parent["sectionName"] = gateway.SectionName
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parent},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"backendRefs": []interface{}{
+					map[string]interface{}{"name": cdn.Name + "-service", "port": int64(80)},
+				},
+			},
+		},
+	}
+	if cdn.Spec.DomainName != "" {
+		spec["hostnames"] = []interface{}{cdn.Spec.DomainName}
+	}
+	return spec
+}
+
+// exposureAddress returns the address clients reach the edges of cdn at, as
+// reported by the Ingress controller, the Gateway or the load balancer, and
+// the kind of the object reporting it.
+func (r *ContentDeliveryNetworkReconciler) exposureAddress(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) (string, string, error) {
+	exposure := exposureSpec(cdn)
+	switch exposure.Mode {
+	case cdnv3.CDNExposureIngress:
+		ingress := &networkingv1.Ingress{}
+		err := r.Get(ctx, types.NamespacedName{Name: cdn.Name + "-ingress", Namespace: cdn.Namespace}, ingress)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if address := lb.IP + lb.Hostname; address != "" {
+				return "Ingress", address, nil
+			}
+		}
+
+	case cdnv3.CDNExposureGateway:
+		gateway := &unstructured.Unstructured{}
+		gateway.SetGroupVersionKind(gatewayGVK)
+		err := r.Get(ctx, gatewayKey(cdn, exposure.Gateway), gateway)
+		if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return "", "", err
+		}
+		addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+		for _, address := range addresses {
+			if address, ok := address.(map[string]interface{}); ok {
+				if value, _ := address["value"].(string); value != "" {
+					return "Gateway", value, nil
+				}
+			}
+		}
+
+	case cdnv3.CDNExposureLoadBalancer:
+		service := &corev1.Service{}
+		err := r.Get(ctx, types.NamespacedName{Name: cdn.Name + "-service", Namespace: cdn.Namespace}, service)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		for _, lb := range service.Status.LoadBalancer.Ingress {
+			if address := lb.IP + lb.Hostname; address != "" {
+				return "Service", address, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// gatewayKey returns the key of the Gateway the HTTPRoute of cdn attaches to.
+func gatewayKey(cdn *cdnv3.ContentDeliveryNetwork, gateway *cdnv3.GatewayReference) types.NamespacedName {
+	key := types.NamespacedName{Name: gateway.Name, Namespace: gateway.Namespace}
+	if key.Namespace == "" {
+		key.Namespace = cdn.Namespace
+	}
+	return key
+}
+
+// gatewayRequests maps a Gateway to the CDNs whose HTTPRoute attaches to it,
+// so their address follows the one of the Gateway.
+func (r *ContentDeliveryNetworkReconciler) gatewayRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list cdnv3.ContentDeliveryNetworkList
+	if err := r.List(ctx, &list); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range list.Items {
+		cdn := &list.Items[i]
+		exposure := exposureSpec(cdn)
+		if exposure.Mode != cdnv3.CDNExposureGateway || exposure.Gateway == nil {
+			continue
+		}
+		if gatewayKey(cdn, exposure.Gateway) == client.ObjectKeyFromObject(obj) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cdn)})
+		}
+	}
+	return requests
+}
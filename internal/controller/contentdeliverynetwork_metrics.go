@@ -0,0 +1,336 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// Port the cdn binary serves its stats and Prometheus metrics on
+	edgeStatsPort = 9090
+	// Time an edge has to answer a stats scrape
+	edgeStatsTimeout = 2 * time.Second
+	// Label of the Service exposing the edge metrics of a CDN to Prometheus
+	edgeMetricsLabel = "cdn.benauro.gg/metrics"
+)
+
+// ServiceMonitor of the Prometheus operator, handled unstructured so the CRD
+// stays optional
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileMonitoring exposes the metrics port of the edges of cdn through a
+// cluster Service, apart from the Service serving the traffic, and has the
+// Prometheus operator scrape it when it is installed.
+func (r *ContentDeliveryNetworkReconciler) reconcileMonitoring(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cdn.Name + "-metrics",
+			Namespace: cdn.Namespace,
+			Labels:    map[string]string{edgeMetricsLabel: cdn.Name},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": cdn.Name},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "metrics",
+					Port:       edgeStatsPort,
+					TargetPort: intstr.FromInt32(edgeStatsPort),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+	if err := r.apply(ctx, cdn, service); err != nil {
+		return err
+	}
+
+	installed, err := kindInstalled(r.RESTMapper(), serviceMonitorGVK)
+	if err != nil || !installed {
+		// Created by the periodic requeue once the Prometheus operator is
+		// installed
+		return err
+	}
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(serviceMonitorGVK)
+	monitor.SetName(cdn.Name + "-edge")
+	monitor.SetNamespace(cdn.Namespace)
+	monitor.SetLabels(map[string]string{"app.kubernetes.io/name": "kube-cdn"})
+	monitor.Object["spec"] = map[string]interface{}{
+		"endpoints": []interface{}{
+			map[string]interface{}{
+				"path":   "/metrics",
+				"port":   "metrics",
+				"scheme": "http",
+			},
+		},
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{edgeMetricsLabel: cdn.Name},
+		},
+	}
+	return r.apply(ctx, cdn, monitor)
+}
+
+// edgeStats are the counters an edge served since it started, as served on
+// its stats port.
+type edgeStats struct {
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+
+	Requests          int64 `json:"requests"`
+	ResponseBytes     int64 `json:"responseBytes"`
+	CacheLookups      int64 `json:"cacheLookups"`
+	CacheLookupBytes  int64 `json:"cacheLookupBytes"`
+	CacheHits         int64 `json:"cacheHits"`
+	CacheHitBytes     int64 `json:"cacheHitBytes"`
+	OriginFetches     int64 `json:"originFetches"`
+	OriginErrors      int64 `json:"originErrors"`
+	CacheDiskUsed     int64 `json:"cacheDiskUsedBytes"`
+	CacheDiskCapacity int64 `json:"cacheDiskCapacityBytes"`
+
+	LatencyBoundsMs []float64 `json:"latencyBoundsMs"`
+	LatencyCounts   []int64   `json:"latencyCounts"`
+}
+
+// since returns the counters s gained after prev, a previous scrape of the
+// same pod. The edge restarted when its counters went back, s is then
+// returned whole.
+func (s edgeStats) since(prev *edgeStats) edgeStats {
+	if prev == nil || s.UptimeSeconds <= prev.UptimeSeconds || s.Requests < prev.Requests ||
+		!slices.Equal(s.LatencyBoundsMs, prev.LatencyBoundsMs) || len(s.LatencyCounts) != len(prev.LatencyCounts) {
+		return s
+	}
+	delta := s
+	delta.UptimeSeconds -= prev.UptimeSeconds
+	delta.Requests -= prev.Requests
+	delta.ResponseBytes -= prev.ResponseBytes
+	delta.CacheLookups -= prev.CacheLookups
+	delta.CacheLookupBytes -= prev.CacheLookupBytes
+	delta.CacheHits -= prev.CacheHits
+	delta.CacheHitBytes -= prev.CacheHitBytes
+	delta.OriginFetches -= prev.OriginFetches
+	delta.OriginErrors -= prev.OriginErrors
+	delta.LatencyCounts = make([]int64, len(s.LatencyCounts))
+	for i := range s.LatencyCounts {
+		delta.LatencyCounts[i] = s.LatencyCounts[i] - prev.LatencyCounts[i]
+	}
+	return delta
+}
+
+// updateMetrics scrapes the stats of the edge pods of cdn and aggregates the
+// counters they gained since the previous scrape into its status.
+func (r *ContentDeliveryNetworkReconciler) updateMetrics(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	logger := log.FromContext(ctx)
+	pods, err := edgePods(ctx, r, cdn)
+	if err != nil {
+		return err
+	}
+
+	cdn.Status.Nodes = make([]string, 0, len(pods))
+	for _, pod := range pods {
+		cdn.Status.Nodes = append(cdn.Status.Nodes, pod.Status.PodIP)
+	}
+	sort.Strings(cdn.Status.Nodes)
+
+	scraped := make([]*edgeStats, len(pods))
+	httpClient := &http.Client{Timeout: edgeStatsTimeout}
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		wg.Add(1)
+		go func(i int, pod *corev1.Pod) {
+			defer wg.Done()
+			stats, err := scrapeEdgeStats(ctx, httpClient, pod)
+			if err != nil {
+				// Edges running an image without a stats port end up here too
+				logger.V(1).Info("Unable to scrape edge stats", "pod", pod.Name, "error", err.Error())
+				return
+			}
+			scraped[i] = stats
+		}(i, pod)
+	}
+	wg.Wait()
+
+	key := client.ObjectKeyFromObject(cdn)
+	r.samplesMu.Lock()
+	defer r.samplesMu.Unlock()
+	prev := r.samples[key]
+	next := map[types.UID]*edgeStats{}
+	deltas := make([]edgeStats, 0, len(pods))
+	for i, pod := range pods {
+		if scraped[i] == nil {
+			continue
+		}
+		deltas = append(deltas, scraped[i].since(prev[pod.UID]))
+		next[pod.UID] = scraped[i]
+	}
+	if r.samples == nil {
+		r.samples = map[types.NamespacedName]map[types.UID]*edgeStats{}
+	}
+	r.samples[key] = next
+
+	cdn.Status.Metrics = aggregateEdgeStats(deltas)
+	return nil
+}
+
+// forgetMetrics drops the previous scrapes of a deleted CDN.
+func (r *ContentDeliveryNetworkReconciler) forgetMetrics(key types.NamespacedName) {
+	r.samplesMu.Lock()
+	defer r.samplesMu.Unlock()
+	delete(r.samples, key)
+}
+
+// scrapeEdgeStats fetches the stats of an edge pod.
+func scrapeEdgeStats(ctx context.Context, httpClient *http.Client, pod *corev1.Pod) (*edgeStats, error) {
+	target := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(edgeStatsPort)) + "/stats"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats answered %s", resp.Status)
+	}
+	stats := &edgeStats{}
+	if err := json.NewDecoder(resp.Body).Decode(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// aggregateEdgeStats sums the counters the edges gained into the metrics of
+// their CDN.
+func aggregateEdgeStats(deltas []edgeStats) cdnv3.CDNMetrics {
+	var (
+		requestRate, egressRate                     float64
+		lookups, lookupBytes, hits, hitBytes        int64
+		originFetches, originErrors, used, capacity int64
+		latencyBounds                               []float64
+		latencyCounts                               []int64
+	)
+	for _, delta := range deltas {
+		if delta.UptimeSeconds > 0 {
+			requestRate += float64(delta.Requests) / delta.UptimeSeconds
+			egressRate += float64(delta.ResponseBytes) / delta.UptimeSeconds
+		}
+		lookups += delta.CacheLookups
+		lookupBytes += delta.CacheLookupBytes
+		hits += delta.CacheHits
+		hitBytes += delta.CacheHitBytes
+		originFetches += delta.OriginFetches
+		originErrors += delta.OriginErrors
+		used += delta.CacheDiskUsed
+		capacity += delta.CacheDiskCapacity
+
+		// Edges of another version may bucket differently, the buckets of
+		// the first edge are kept
+		if latencyCounts == nil && len(delta.LatencyCounts) == len(delta.LatencyBoundsMs)+1 {
+			latencyBounds = delta.LatencyBoundsMs
+			latencyCounts = make([]int64, len(delta.LatencyCounts))
+		}
+		if slices.Equal(delta.LatencyBoundsMs, latencyBounds) && len(delta.LatencyCounts) == len(latencyCounts) {
+			for i, count := range delta.LatencyCounts {
+				latencyCounts[i] += count
+			}
+		}
+	}
+
+	return cdnv3.CDNMetrics{
+		Edges:             int32(len(deltas)),
+		RequestRate:       decimalQuantity(requestRate),
+		EgressRate:        decimalQuantity(egressRate),
+		CacheHitRatio:     decimalQuantity(ratio(hits, lookups)),
+		CacheByteHitRatio: decimalQuantity(ratio(hitBytes, lookupBytes)),
+		LatencyP50:        metav1.Duration{Duration: latencyPercentile(latencyBounds, latencyCounts, 0.50)},
+		LatencyP95:        metav1.Duration{Duration: latencyPercentile(latencyBounds, latencyCounts, 0.95)},
+		LatencyP99:        metav1.Duration{Duration: latencyPercentile(latencyBounds, latencyCounts, 0.99)},
+		OriginErrorRatio:  decimalQuantity(ratio(originErrors, originFetches)),
+		CacheUsed:         *resource.NewQuantity(used, resource.BinarySI),
+		CacheCapacity:     *resource.NewQuantity(capacity, resource.BinarySI),
+	}
+}
+
+func ratio(part, whole int64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// decimalQuantity returns v to the thousandth.
+func decimalQuantity(v float64) resource.Quantity {
+	return *resource.NewMilliQuantity(int64(math.Round(v*1000)), resource.DecimalSI)
+}
+
+// latencyPercentile estimates the latency under which q of the responses
+// were served, interpolating within the bucket it falls in. Responses slower
+// than the last bound count as served at it.
+func latencyPercentile(boundsMs []float64, counts []int64, q float64) time.Duration {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 || len(boundsMs) == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var below int64
+	for i, count := range counts {
+		if count == 0 || float64(below+count) < rank {
+			below += count
+			continue
+		}
+		if i == len(boundsMs) {
+			break
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = boundsMs[i-1]
+		}
+		ms := lower + (boundsMs[i]-lower)*(rank-float64(below))/float64(count)
+		return time.Duration(ms * float64(time.Millisecond)).Round(time.Millisecond)
+	}
+	return time.Duration(boundsMs[len(boundsMs)-1] * float64(time.Millisecond))
+}
@@ -0,0 +1,360 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	CDNStatePending  = "Pending"
+	CDNStateReady    = "Ready"
+	CDNStateDegraded = "Degraded"
+
+	// Edges are serving, from a reachable origin and with their storage and
+	// certificate in place
+	CDNConditionReady = "Ready"
+	// The edges fetch from the origin without errors
+	CDNConditionOriginReachable = "OriginReachable"
+	// The TLS certificate is valid, or TLS is off
+	CDNConditionCertificateReady = "CertificateReady"
+	// The volumes of the edges are bound
+	CDNConditionStorageBound = "StorageBound"
+	// The domain is served by a DomainNameSystem, or has an Ingress, Gateway
+	// or load balancer address
+	CDNConditionDNSPublished = "DNSPublished"
+	// Some edges are unavailable or failing their health checks, or the last
+	// reconcile failed
+	CDNConditionDegraded = "Degraded"
+
+	// Share of failed origin fetches from which the origin is reported
+	// unreachable
+	originErrorThreshold = 0.5
+)
+
+// apply applies a child of cdn, recording an event when it was created or
+// changed, or failed to be.
+func (r *ContentDeliveryNetworkReconciler) apply(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, obj client.Object) error {
+	result, err := apply(ctx, r.Client, cdn, obj)
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	switch {
+	case err != nil:
+		r.Recorder.Eventf(cdn, corev1.EventTypeWarning, "ApplyFailed", "Failed to apply %s %s: %v", kind, obj.GetName(), err)
+	case result == controllerutil.OperationResultCreated:
+		r.Recorder.Eventf(cdn, corev1.EventTypeNormal, "Created", "Created %s %s", kind, obj.GetName())
+	case result == controllerutil.OperationResultUpdated:
+		r.Recorder.Eventf(cdn, corev1.EventTypeNormal, "Updated", "Updated %s %s", kind, obj.GetName())
+	}
+	return err
+}
+
+// fail records err on cdn, as an event and in its Degraded condition, and
+// returns it for the reconcile to be retried.
+func (r *ContentDeliveryNetworkReconciler) fail(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, err error, msg string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Error(err, msg)
+	r.Recorder.Eventf(cdn, corev1.EventTypeWarning, "ReconcileFailed", "%s: %v", msg, err)
+
+	meta.SetStatusCondition(&cdn.Status.Conditions, metav1.Condition{
+		Type:               CDNConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cdn.Generation,
+		Reason:             "ReconcileFailed",
+		Message:            fmt.Sprintf("%s: %v", msg, err),
+	})
+	cdn.Status.State = CDNStatePending
+	if meta.IsStatusConditionTrue(cdn.Status.Conditions, CDNConditionReady) {
+		cdn.Status.State = CDNStateDegraded
+	}
+	cdn.Status.LastUpdated = metav1.Now()
+	if updateErr := r.Status().Update(ctx, cdn); updateErr != nil {
+		logger.Error(updateErr, "Unable to update ContentDeliveryNetwork status")
+	}
+	return ctrl.Result{}, err
+}
+
+// updateStatus computes the conditions of cdn from the state of its origin,
+// certificate, volumes, edges and DNS records. The status is only written when
+// more than its timestamp changed, as every write triggers another reconcile.
+func (r *ContentDeliveryNetworkReconciler) updateStatus(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	observed := cdn.Status.DeepCopy()
+	nodes, err := r.edgeNodes(ctx, cdn)
+	if err != nil {
+		return err
+	}
+	edges, err := r.countEdges(ctx, cdn, nodes)
+	if err != nil {
+		return err
+	}
+	storage, err := r.storageCondition(ctx, cdn, nodes, edges.replicas)
+	if err != nil {
+		return err
+	}
+	published, err := r.dnsCondition(ctx, cdn)
+	if err != nil {
+		return err
+	}
+	origin := originCondition(cdn)
+	certificate, certificateStatus, err := r.certificateCondition(ctx, cdn)
+	if err != nil {
+		return err
+	}
+	cdn.Status.Certificate = certificateStatus
+
+	ready := metav1.Condition{
+		Type:    CDNConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "EdgesAvailable",
+		Message: fmt.Sprintf("%d of %d edges available", edges.available, edges.desired),
+	}
+	if edges.available == 0 {
+		ready.Status, ready.Reason = metav1.ConditionFalse, "NoEdgeAvailable"
+	}
+	// A dependency in an unknown state doesn't hold the edges back
+	for _, dependency := range []metav1.Condition{origin, storage, certificate} {
+		if ready.Status == metav1.ConditionTrue && dependency.Status == metav1.ConditionFalse {
+			ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, dependency.Reason, dependency.Message
+		}
+	}
+
+	degraded := metav1.Condition{
+		Type:    CDNConditionDegraded,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: "All edges are available and healthy",
+	}
+	switch {
+	case edges.unhealthy > 0:
+		degraded.Status, degraded.Reason = metav1.ConditionTrue, "EdgesUnhealthy"
+		degraded.Message = fmt.Sprintf("%d edge pods failing the health checks", edges.unhealthy)
+	case edges.available < edges.desired:
+		degraded.Status, degraded.Reason = metav1.ConditionTrue, "EdgesUnavailable"
+		degraded.Message = fmt.Sprintf("%d of %d edges available", edges.available, edges.desired)
+	}
+
+	for _, condition := range []metav1.Condition{ready, origin, certificate, storage, published, degraded} {
+		condition.ObservedGeneration = cdn.Generation
+		meta.SetStatusCondition(&cdn.Status.Conditions, condition)
+	}
+	switch {
+	case ready.Status != metav1.ConditionTrue:
+		cdn.Status.State = CDNStatePending
+	case degraded.Status == metav1.ConditionTrue:
+		cdn.Status.State = CDNStateDegraded
+	default:
+		cdn.Status.State = CDNStateReady
+	}
+	cdn.Status.ObservedGeneration = cdn.Generation
+	cdn.Status.LastUpdated = observed.LastUpdated
+	if equality.Semantic.DeepEqual(&cdn.Status, observed) {
+		return nil
+	}
+	cdn.Status.LastUpdated = metav1.Now()
+	return r.Status().Update(ctx, cdn)
+}
+
+// edgeNodes returns the ContentDeliveryNetworkNodes serving cdn.
+func (r *ContentDeliveryNetworkReconciler) edgeNodes(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) ([]*cdnv3.ContentDeliveryNetworkNode, error) {
+	var list cdnv3.ContentDeliveryNetworkNodeList
+	if err := r.List(ctx, &list, client.InNamespace(cdn.Namespace)); err != nil {
+		return nil, err
+	}
+	var nodes []*cdnv3.ContentDeliveryNetworkNode
+	for i := range list.Items {
+		if node := &list.Items[i]; node.Spec.CDNRef == cdn.Name && node.DeletionTimestamp == nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+type edgeCounts struct {
+	desired, available, unhealthy int
+	// Desired pods of the Deployment or StatefulSet, outside of the nodes
+	replicas int
+}
+
+// countEdges counts the edge pods of the Deployment or StatefulSet and the
+// nodes of cdn, and those the DNS health checks ejected.
+func (r *ContentDeliveryNetworkReconciler) countEdges(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, nodes []*cdnv3.ContentDeliveryNetworkNode) (edgeCounts, error) {
+	var counts edgeCounts
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: cdn.Name + "-deployment", Namespace: cdn.Namespace}, deployment)
+	switch {
+	case err == nil:
+		if deployment.Spec.Replicas != nil {
+			counts.replicas += int(*deployment.Spec.Replicas)
+		}
+		counts.available += int(deployment.Status.AvailableReplicas)
+	case !errors.IsNotFound(err):
+		return counts, err
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	err = r.Get(ctx, types.NamespacedName{Name: cdn.Name + "-edge", Namespace: cdn.Namespace}, statefulSet)
+	switch {
+	case err == nil:
+		if statefulSet.Spec.Replicas != nil {
+			counts.replicas += int(*statefulSet.Spec.Replicas)
+		}
+		counts.available += int(statefulSet.Status.AvailableReplicas)
+	case !errors.IsNotFound(err):
+		return counts, err
+	}
+
+	counts.desired = counts.replicas
+	for _, node := range nodes {
+		counts.desired++
+		if node.Status.Available {
+			counts.available++
+		}
+	}
+
+	pods, err := edgePods(ctx, r, cdn)
+	if err != nil {
+		return counts, err
+	}
+	for _, pod := range pods {
+		if pod.Annotations[edgeHealthAnnotation] == "unhealthy" {
+			counts.unhealthy++
+		}
+	}
+	return counts, nil
+}
+
+// storageCondition reports whether the volumes of the edge pods and of the
+// nodes of cdn are bound.
+func (r *ContentDeliveryNetworkReconciler) storageCondition(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, nodes []*cdnv3.ContentDeliveryNetworkNode, replicas int) (metav1.Condition, error) {
+	claims := edgeClaimNames(cdn, replicas)
+	for _, node := range nodes {
+		claims = append(claims, cacheClaimName(node))
+	}
+
+	var pending []string
+	for _, name := range claims {
+		claim := &corev1.PersistentVolumeClaim{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cdn.Namespace}, claim)
+		switch {
+		case errors.IsNotFound(err):
+			pending = append(pending, name+" (missing)")
+		case err != nil:
+			return metav1.Condition{}, err
+		case claim.Status.Phase != corev1.ClaimBound:
+			pending = append(pending, name)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    CDNConditionStorageBound,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Bound",
+		Message: fmt.Sprintf("%d claims bound", len(claims)),
+	}
+	if len(pending) > 0 {
+		condition.Status, condition.Reason = metav1.ConditionFalse, "ClaimsPending"
+		condition.Message = "Waiting for claims " + strings.Join(pending, ", ")
+	}
+	return condition, nil
+}
+
+// dnsCondition reports whether the domain of cdn is served by a ready
+// DomainNameSystem, or else has an address through its Ingress, Gateway or
+// load balancer.
+func (r *ContentDeliveryNetworkReconciler) dnsCondition(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) (metav1.Condition, error) {
+	condition := metav1.Condition{Type: CDNConditionDNSPublished, Status: metav1.ConditionFalse}
+	if cdn.Spec.DomainName == "" {
+		condition.Reason, condition.Message = "NoDomainName", "No domain name is set"
+		return condition, nil
+	}
+	domain := fqdn(cdn.Spec.DomainName)
+
+	var list cdnv3.DomainNameSystemList
+	if err := r.List(ctx, &list, client.InNamespace(cdn.Namespace)); err != nil {
+		return condition, err
+	}
+	pending := ""
+	for i := range list.Items {
+		dns := &list.Items[i]
+		zones := make([]dnsZone, 0, len(dns.Spec.Zones))
+		for _, name := range dns.Spec.Zones {
+			zones = append(zones, dnsZone{Name: fqdn(name)})
+		}
+		if zoneFor(zones, domain) == nil {
+			continue
+		}
+		if dns.Status.State == DNSStateReady {
+			condition.Status, condition.Reason = metav1.ConditionTrue, "Published"
+			condition.Message = "Served by DomainNameSystem " + dns.Name
+			return condition, nil
+		}
+		pending = dns.Name
+	}
+
+	kind, address, err := r.exposureAddress(ctx, cdn)
+	if err != nil {
+		return condition, err
+	}
+	if address != "" {
+		condition.Status, condition.Reason = metav1.ConditionTrue, kind+"Published"
+		condition.Message = kind + " has address " + address
+		return condition, nil
+	}
+
+	if pending != "" {
+		condition.Reason, condition.Message = "DomainNameSystemPending", "DomainNameSystem "+pending+" is not ready"
+	} else {
+		condition.Reason, condition.Message = "NotPublished", "No DomainNameSystem serves "+cdn.Spec.DomainName
+	}
+	return condition, nil
+}
+
+// originCondition reports whether the edges of cdn reach its origin, from
+// the share of their origin fetches which failed since the previous scrape.
+// The controller doesn't contact the origin itself, which may only be
+// reachable from the edges. It is unknown until edge metrics come in.
+func originCondition(cdn *cdnv3.ContentDeliveryNetwork) metav1.Condition {
+	condition := metav1.Condition{Type: CDNConditionOriginReachable, Status: metav1.ConditionUnknown}
+	metrics := cdn.Status.Metrics
+	if metrics.Edges == 0 {
+		condition.Reason, condition.Message = "NoEdgeMetrics", "No edge reported its origin fetches"
+		return condition
+	}
+
+	errorRatio := metrics.OriginErrorRatio.AsApproximateFloat64()
+	condition.Message = fmt.Sprintf("%.0f%% of the origin fetches failed", errorRatio*100)
+	if errorRatio >= originErrorThreshold {
+		condition.Status, condition.Reason = metav1.ConditionFalse, "OriginErrors"
+		return condition
+	}
+	condition.Status, condition.Reason = metav1.ConditionTrue, "OriginResponding"
+	return condition
+}
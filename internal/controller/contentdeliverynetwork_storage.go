@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// Share of a cache volume the disk cache fills, the rest is left for
+	// the filesystem overhead and the slices being written
+	cacheVolumeFill = 0.9
+)
+
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+
+// storageSpec returns the storage of cdn with the defaults filled in, for
+// objects created before the CRD defaults existed.
+func storageSpec(cdn *cdnv3.ContentDeliveryNetwork) cdnv3.CDNStorage {
+	var storage cdnv3.CDNStorage
+	if cdn.Spec.Storage != nil {
+		storage = *cdn.Spec.Storage
+	}
+	if storage.Mode == "" {
+		storage.Mode = cdnv3.CDNStorageModePerReplica
+	}
+	if storage.Size.IsZero() {
+		storage.Size = resource.MustParse("10Gi")
+	}
+	if storage.AccessMode == "" {
+		// Each edge pod mounts its own claim in the PerReplica mode, while
+		// every pod of the Deployment mounts the shared one
+		storage.AccessMode = corev1.ReadWriteOnce
+		if storage.Mode == cdnv3.CDNStorageModeShared {
+			storage.AccessMode = corev1.ReadWriteMany
+		}
+	}
+	return storage
+}
+
+// cacheClaimSpec returns the spec of a cache volume claim of size.
+func cacheClaimSpec(storage cdnv3.CDNStorage, size resource.Quantity) corev1.PersistentVolumeClaimSpec {
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes:      []corev1.PersistentVolumeAccessMode{storage.AccessMode},
+		StorageClassName: storage.StorageClassName,
+		Resources: corev1.VolumeResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+		},
+	}
+}
+
+// cacheEnv sizes the disk cache of an edge pod to its share of a volume of
+// size shared by pods.
+func cacheEnv(size resource.Quantity, pods int) []corev1.EnvVar {
+	env := []corev1.EnvVar{{
+		Name:  "CDN_CACHE_DISK_BYTES",
+		Value: strconv.FormatInt(int64(float64(size.Value())*cacheVolumeFill)/int64(max(pods, 1)), 10),
+	}}
+	if pods > 1 {
+		// Keep the slices of the pods apart, they index them on their own
+		env = append(env, corev1.EnvVar{Name: "CDN_CACHE_DIR", Value: "/data/cache/$(POD_NAME)"})
+	}
+	return env
+}
+
+// reconcileStorage claims the volume shared by the edges of cdn in the
+// Shared mode, through a CDI DataVolume when a source is given, and removes
+// it in the PerReplica mode, where the edge StatefulSet claims the volumes.
+func (r *ContentDeliveryNetworkReconciler) reconcileStorage(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	storage := storageSpec(cdn)
+	objectMeta := metav1.ObjectMeta{
+		Name:      cdn.Name + "-storage",
+		Namespace: cdn.Namespace,
+	}
+
+	cdiInstalled, err := kindInstalled(r.RESTMapper(), cdiv1.SchemeGroupVersion.WithKind("DataVolume"))
+	if err != nil {
+		return err
+	}
+	if storage.Mode != cdnv3.CDNStorageModeShared || storage.DataVolumeSource == nil {
+		if cdiInstalled {
+			if err := deleteOwned(ctx, r.Client, cdn, &cdiv1.DataVolume{ObjectMeta: objectMeta}); err != nil {
+				return err
+			}
+		}
+	}
+	if storage.Mode != cdnv3.CDNStorageModeShared {
+		return deleteOwned(ctx, r.Client, cdn, &corev1.PersistentVolumeClaim{ObjectMeta: objectMeta})
+	}
+
+	claim := cacheClaimSpec(storage, storage.Size)
+	if storage.DataVolumeSource == nil {
+		return r.apply(ctx, cdn, &corev1.PersistentVolumeClaim{ObjectMeta: objectMeta, Spec: claim})
+	}
+	if !cdiInstalled {
+		// Claimed by the periodic requeue once CDI is installed
+		log.FromContext(ctx).Info("CDI is not installed, unable to populate the shared volume")
+		r.Recorder.Event(cdn, corev1.EventTypeWarning, "CDINotInstalled", "The DataVolume CRD is not installed, the shared volume is not claimed")
+		return nil
+	}
+	// The DataVolume claims a volume named after it
+	return r.apply(ctx, cdn, &cdiv1.DataVolume{
+		ObjectMeta: objectMeta,
+		Spec: cdiv1.DataVolumeSpec{
+			Source: storage.DataVolumeSource,
+			PVC:    &claim,
+		},
+	})
+}
+
+// reconcileEdges runs the edge pods of cdn, as a StatefulSet in the
+// PerReplica storage mode and a Deployment in the Shared one, and removes the
+// workload of the other mode. The workload runs MinReplicas until the
+// autoscaler sets its replicas, which are left to it from then on.
+func (r *ContentDeliveryNetworkReconciler) reconcileEdges(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: cdn.Name + "-deployment", Namespace: cdn.Namespace},
+	}
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: cdn.Name + "-edge", Namespace: cdn.Namespace},
+	}
+	shared := storageSpec(cdn).Mode == cdnv3.CDNStorageModeShared
+	var stale client.Object = deployment
+	if shared {
+		stale = statefulSet
+	}
+	if err := deleteOwned(ctx, r.Client, cdn, stale); err != nil {
+		return err
+	}
+
+	if shared {
+		return r.reconcileDeployment(ctx, cdn)
+	}
+	return r.reconcileEdgeStatefulSet(ctx, cdn)
+}
+
+// edgeReplicas returns the replicas to apply obj with: MinReplicas until the
+// HorizontalPodAutoscaler, KEDA or anyone else sets them, nil afterwards so
+// that their replicas are not taken over. Leaving the replicas out while the
+// controller still owns them would reset them to 1.
+func (r *ContentDeliveryNetworkReconciler) edgeReplicas(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork, obj client.Object) (*int32, error) {
+	existing := obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && managedByOthers(existing, "f:spec", "f:replicas") {
+		return nil, nil
+	}
+	replicas := edgeMinReplicas(cdn)
+	return &replicas, nil
+}
+
+// edgeClaimNames returns the names of the volume claims of the edge pods of
+// cdn, outside of its nodes.
+func edgeClaimNames(cdn *cdnv3.ContentDeliveryNetwork, replicas int) []string {
+	if storageSpec(cdn).Mode == cdnv3.CDNStorageModeShared {
+		if replicas == 0 {
+			return nil
+		}
+		return []string{cdn.Name + "-storage"}
+	}
+	names := make([]string, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		names = append(names, "content-"+cdn.Name+"-edge-"+strconv.Itoa(i))
+	}
+	return names
+}
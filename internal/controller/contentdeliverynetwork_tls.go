@@ -0,0 +1,271 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+// Port the cdn binary terminates TLS on
+const edgeTLSPort = 8443
+
+// Certificate of cert-manager, handled unstructured so the CRD stays optional
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+// tlsEnabled reports whether cdn serves its domain over TLS.
+func tlsEnabled(cdn *cdnv3.ContentDeliveryNetwork) bool {
+	return cdn.Spec.SSLConfig != nil && cdn.Spec.SSLConfig.Enabled
+}
+
+// tlsSecretName returns the name of the Secret holding the certificate of
+// cdn.
+func tlsSecretName(cdn *cdnv3.ContentDeliveryNetwork) string {
+	if ssl := cdn.Spec.SSLConfig; ssl != nil && ssl.SecretName != "" {
+		return ssl.SecretName
+	}
+	return cdn.Name + "-tls"
+}
+
+// reconcileCertificate provides the Secret holding the certificate of cdn:
+// requests it from cert-manager, orders it from an ACME CA, or copies the
+// deprecated inline pair into it. A Secret referenced by name is otherwise
+// left to its owner.
+func (r *ContentDeliveryNetworkReconciler) reconcileCertificate(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) error {
+	ssl := cdn.Spec.SSLConfig
+	objectMeta := metav1.ObjectMeta{Name: cdn.Name + "-tls", Namespace: cdn.Namespace}
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(objectMeta.Name)
+	certificate.SetNamespace(objectMeta.Namespace)
+
+	inline := tlsEnabled(cdn) && ssl.CertManager == nil && ssl.ACME == nil && (ssl.Cert != "" || ssl.Key != "")
+	acmeOrdered := tlsEnabled(cdn) && ssl.ACME != nil
+	if !inline && !(acmeOrdered && tlsSecretName(cdn) == objectMeta.Name) {
+		if err := deleteOwned(ctx, r.Client, cdn, &corev1.Secret{ObjectMeta: objectMeta}); err != nil {
+			return err
+		}
+	}
+	if !tlsEnabled(cdn) || ssl.CertManager == nil {
+		if err := deleteOwned(ctx, r.Client, cdn, certificate); err != nil {
+			return err
+		}
+	}
+	if !acmeOrdered {
+		if err := r.removeACME(ctx, cdn); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case inline:
+		r.Recorder.Event(cdn, corev1.EventTypeWarning, "InlineCertificate",
+			"sslConfig.cert and sslConfig.key are deprecated, move the pair to a Secret named in sslConfig.secretName")
+		return r.apply(ctx, cdn, &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       inlinePEM(ssl.Cert),
+				corev1.TLSPrivateKeyKey: inlinePEM(ssl.Key),
+			},
+		})
+
+	case tlsEnabled(cdn) && ssl.CertManager != nil:
+		installed, err := kindInstalled(r.RESTMapper(), certificateGVK)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			return fmt.Errorf("cert-manager Certificate CRD is not installed")
+		}
+		issuer := ssl.CertManager.IssuerRef
+		if issuer.Kind == "" {
+			issuer.Kind = "Issuer"
+		}
+		if issuer.Group == "" {
+			issuer.Group = certificateGVK.Group
+		}
+		var dnsNames []interface{}
+		for _, name := range certificateNames(cdn, ssl.CertManager.DNSNames) {
+			dnsNames = append(dnsNames, name)
+		}
+		certificate.Object["spec"] = map[string]interface{}{
+			"secretName": tlsSecretName(cdn),
+			"dnsNames":   dnsNames,
+			"issuerRef": map[string]interface{}{
+				"name":  issuer.Name,
+				"kind":  issuer.Kind,
+				"group": issuer.Group,
+			},
+		}
+		return r.apply(ctx, cdn, certificate)
+
+	case acmeOrdered:
+		return r.reconcileACME(ctx, cdn)
+	}
+	return nil
+}
+
+// addTLSVolumes mounts the TLS Secrets of cdn into the first container of
+// podSpec, each in its own directory under tlsMountPath. The edges pick
+// them by SNI and reload them when they change. The Secrets are optional,
+// as cert-manager may not have issued them yet.
+func addTLSVolumes(podSpec *corev1.PodSpec, cdn *cdnv3.ContentDeliveryNetwork) {
+	names := append([]string{tlsSecretName(cdn)}, cdn.Spec.SSLConfig.AdditionalSecretNames...)
+	seen := map[string]bool{}
+	optional := true
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		volume := "tls-" + strconv.Itoa(len(seen)-1)
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: name, Optional: &optional},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      volume,
+			MountPath: path.Join(tlsMountPath, name),
+			ReadOnly:  true,
+		})
+	}
+}
+
+// addACMEVolume mounts the pending ACME challenge responses of cdn into the
+// first container of podSpec at acmeMountPath. The ConfigMap is optional, as
+// it only exists once an order has been placed.
+func addACMEVolume(podSpec *corev1.PodSpec, cdn *cdnv3.ContentDeliveryNetwork) {
+	optional := true
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "acme",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: acmeChallengesMeta(cdn).Name},
+				Optional:             &optional,
+			},
+		},
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "acme",
+		MountPath: acmeMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// knownCipherSuite reports whether the edges know a cipher suite by name.
+func knownCipherSuite(name string) bool {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inlinePEM returns the PEM block of an inline certificate or key, which
+// may be base64 encoded.
+func inlinePEM(value string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err == nil && bytes.Contains(decoded, []byte("-----BEGIN")) {
+		return decoded
+	}
+	return []byte(value)
+}
+
+// certificateCondition reports whether the certificate of cdn is in its
+// Secret, valid, current and for its domain, and describes it. It holds when
+// TLS is off.
+func (r *ContentDeliveryNetworkReconciler) certificateCondition(ctx context.Context, cdn *cdnv3.ContentDeliveryNetwork) (metav1.Condition, *cdnv3.CDNCertificateStatus, error) {
+	condition := metav1.Condition{Type: CDNConditionCertificateReady, Status: metav1.ConditionFalse}
+	if !tlsEnabled(cdn) {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionTrue, "NotRequired", "TLS is not enabled"
+		return condition, nil, nil
+	}
+
+	name := tlsSecretName(cdn)
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cdn.Namespace}, secret)
+	switch {
+	case errors.IsNotFound(err):
+		condition.Reason, condition.Message = "SecretNotFound", "Secret "+name+" does not exist"
+		switch ssl := cdn.Spec.SSLConfig; {
+		case ssl.CertManager != nil:
+			condition.Reason, condition.Message = "Issuing", "Waiting for cert-manager to issue the certificate into Secret "+name
+		case ssl.ACME != nil:
+			condition.Reason, condition.Message = "Issuing", "Ordering the certificate into Secret "+name
+			if err := r.acmeOrderError(cdn); err != nil {
+				condition.Reason, condition.Message = "OrderFailed", err.Error()
+			}
+		}
+		return condition, nil, nil
+	case err != nil:
+		return condition, nil, err
+	}
+
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		condition.Reason, condition.Message = "InvalidCertificate", fmt.Sprintf("Secret %s: %v", name, err)
+		return condition, nil, nil
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		condition.Reason, condition.Message = "InvalidCertificate", fmt.Sprintf("Secret %s: %v", name, err)
+		return condition, nil, nil
+	}
+	status := &cdnv3.CDNCertificateStatus{
+		SecretName: name,
+		DNSNames:   leaf.DNSNames,
+		NotAfter:   metav1.NewTime(leaf.NotAfter),
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		condition.Reason, condition.Message = "Expired", "Certificate expired "+leaf.NotAfter.UTC().Format(time.RFC3339)
+	case now.Before(leaf.NotBefore):
+		condition.Reason, condition.Message = "NotYetValid", "Certificate is valid from "+leaf.NotBefore.UTC().Format(time.RFC3339)
+	case cdn.Spec.DomainName != "" && leaf.VerifyHostname(cdn.Spec.DomainName) != nil:
+		condition.Reason, condition.Message = "HostnameMismatch", "Certificate does not cover "+cdn.Spec.DomainName
+	default:
+		condition.Status, condition.Reason = metav1.ConditionTrue, "Valid"
+		condition.Message = "Certificate expires " + leaf.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return condition, status, nil
+}
@@ -18,19 +18,41 @@ package controller
 
 import (
 	"context"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/go-logr/logr"
 
 	cdnv3 "github.com/benauro/kube-cdn/api/v3"
 )
 
+const (
+	// Drains and deletes the edge of a node before it goes away
+	nodeFinalizer = "cdn.benauro.gg/node-drain"
+	// Finalizer set by earlier releases, which nothing removed
+	legacyNodeFinalizer = "cdnnode.finalizers.example.com"
+	// ContentDeliveryNetworkNode an edge pod belongs to
+	edgeNodeLabel = "cdn.benauro.gg/node"
+
+	// How often a draining node checks whether its pod is gone
+	nodeDrainPoll = 5 * time.Second
+)
+
 // ContentDeliveryNetworkNodeReconciler reconciles a ContentDeliveryNetworkNode object
 type ContentDeliveryNetworkNodeReconciler struct {
 	client.Client
@@ -41,18 +63,17 @@ type ContentDeliveryNetworkNodeReconciler struct {
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworknodes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworknodes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworknodes/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cdn.benauro.gg,resources=contentdeliverynetworks,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;update;patch;delete
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the ContentDeliveryNetworkNode object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.3/pkg/reconcile
+// Reconcile runs the edge of a node as a single replica StatefulSet with a
+// cache volume of CacheSize GiB, and reports it available once its pod is
+// ready and not ejected by the DNS health checks. A deleted node drains its
+// pod before the StatefulSet and the volume are removed.
 func (r *ContentDeliveryNetworkNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
 
 	// Fetch the CDN node instance
 	instance := &cdnv3.ContentDeliveryNetworkNode{}
@@ -67,20 +88,212 @@ func (r *ContentDeliveryNetworkNodeReconciler) Reconcile(ctx context.Context, re
 		return ctrl.Result{}, err
 	}
 
+	if !instance.DeletionTimestamp.IsZero() {
+		return r.drain(ctx, instance)
+	}
+
 	// Ensure the CDNNode has a finalizer for cleanup on deletion
-	if !controllerutil.ContainsFinalizer(instance, "cdnnode.finalizers.example.com") {
-		controllerutil.AddFinalizer(instance, "cdnnode.finalizers.example.com")
+	if !controllerutil.ContainsFinalizer(instance, nodeFinalizer) || controllerutil.ContainsFinalizer(instance, legacyNodeFinalizer) {
+		controllerutil.RemoveFinalizer(instance, legacyNodeFinalizer)
+		controllerutil.AddFinalizer(instance, nodeFinalizer)
 		if err := r.Update(ctx, instance); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	var cdn cdnv3.ContentDeliveryNetwork
+	if instance.Spec.CDNRef != "" {
+		err = r.Get(ctx, types.NamespacedName{Name: instance.Spec.CDNRef, Namespace: instance.Namespace}, &cdn)
+	}
+	if instance.Spec.CDNRef == "" || errors.IsNotFound(err) {
+		logger.Info("ContentDeliveryNetwork of the node not found", "cdn", instance.Spec.CDNRef)
+		return ctrl.Result{RequeueAfter: time.Minute}, r.setAvailable(ctx, instance, false)
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileStatefulSet(ctx, instance, &cdn); err != nil {
+		logger.Error(err, "Failed to reconcile statefulset")
+		return ctrl.Result{}, err
+	}
+	if err := r.expandCacheVolume(ctx, instance); err != nil {
+		// Not every storage class can grow volumes, the node keeps serving
+		// from the volume it has
+		logger.Info("Unable to expand cache volume", "error", err.Error())
+	}
+
+	available := false
+	pod := &corev1.Pod{}
+	err = r.Get(ctx, types.NamespacedName{Name: instance.Name + "-0", Namespace: instance.Namespace}, pod)
+	switch {
+	case err == nil:
+		available = pod.DeletionTimestamp == nil && podReady(pod) && pod.Annotations[edgeHealthAnnotation] != "unhealthy"
+	case !errors.IsNotFound(err):
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, r.setAvailable(ctx, instance, available)
+}
+
+// reconcileStatefulSet runs the cdn binary for node, with the pod spec of the
+// edges of cdn and its cache on a volume claimed per pod.
+func (r *ContentDeliveryNetworkNodeReconciler) reconcileStatefulSet(ctx context.Context, node *cdnv3.ContentDeliveryNetworkNode, cdn *cdnv3.ContentDeliveryNetwork) error {
+	labels := map[string]string{"app": cdn.Name, edgeRoleLabel: edgeRoleNode, edgeNodeLabel: node.Name}
+	size := cacheVolumeSize(node)
+	replicas := int32(1)
+
+	podSpec := edgePodSpec(cdn)
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, cacheEnv(size, 1)...)
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			Selector:    &metav1.LabelSelector{MatchLabels: labels},
+			ServiceName: cdn.Name + "-service",
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       podSpec,
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "content"},
+					// Same class and access mode as the other edges
+					Spec: cacheClaimSpec(storageSpec(cdn), size),
+				},
+			},
+		},
+	}
+	existing := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(statefulSet), existing)
+	switch {
+	case err == nil && len(existing.Spec.VolumeClaimTemplates) > 0:
+		// Claim templates are immutable, expandCacheVolume grows the claim
+		// once it exists
+		statefulSet.Spec.VolumeClaimTemplates[0].Spec.Resources = existing.Spec.VolumeClaimTemplates[0].Spec.Resources
+	case err != nil && !errors.IsNotFound(err):
+		return err
+	}
+	if err := replaceOnSelectorChange(ctx, r.Client, node, statefulSet); err != nil {
+		return err
+	}
+	_, err = apply(ctx, r.Client, node, statefulSet)
+	return err
+}
+
+// expandCacheVolume grows the cache volume of node to CacheSize when it was
+// raised since the volume was claimed.
+func (r *ContentDeliveryNetworkNodeReconciler) expandCacheVolume(ctx context.Context, node *cdnv3.ContentDeliveryNetworkNode) error {
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cacheClaimName(node), Namespace: node.Namespace}, claim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	size := cacheVolumeSize(node)
+	if claim.Spec.Resources.Requests.Storage().Cmp(size) >= 0 {
+		return nil
+	}
+	patch := client.MergeFrom(claim.DeepCopy())
+	claim.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	return r.Patch(ctx, claim, patch)
+}
+
+// drain scales the StatefulSet of node to zero, so that its pod fails its
+// health checks and finishes serving, then deletes it with the cache volume
+// and lets node go.
+func (r *ContentDeliveryNetworkNodeReconciler) drain(ctx context.Context, node *cdnv3.ContentDeliveryNetworkNode) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(node, nodeFinalizer) && !controllerutil.ContainsFinalizer(node, legacyNodeFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: node.Name, Namespace: node.Namespace}, statefulSet)
+	switch {
+	case err == nil && metav1.IsControlledBy(statefulSet, node):
+		if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas != 0 {
+			replicas := int32(0)
+			statefulSet.Spec.Replicas = &replicas
+			if err := r.Update(ctx, statefulSet); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: nodeDrainPoll}, nil
+		}
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(node.Namespace), client.MatchingLabels{edgeNodeLabel: node.Name}); err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(pods.Items) > 0 {
+			return ctrl.Result{RequeueAfter: nodeDrainPoll}, nil
+		}
+		if err := r.Delete(ctx, statefulSet); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+	case err != nil && !errors.IsNotFound(err):
+		return ctrl.Result{}, err
+	}
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: cacheClaimName(node), Namespace: node.Namespace},
+	}
+	if err := r.Delete(ctx, claim); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(node, nodeFinalizer)
+	controllerutil.RemoveFinalizer(node, legacyNodeFinalizer)
+	return ctrl.Result{}, r.Update(ctx, node)
+}
+
+func (r *ContentDeliveryNetworkNodeReconciler) setAvailable(ctx context.Context, node *cdnv3.ContentDeliveryNetworkNode, available bool) error {
+	if node.Status.Available == available {
+		return nil
+	}
+	node.Status.Available = available
+	return r.Status().Update(ctx, node)
+}
+
+func cacheVolumeSize(node *cdnv3.ContentDeliveryNetworkNode) resource.Quantity {
+	return *resource.NewQuantity(int64(max(node.Spec.CacheSize, 1))<<30, resource.BinarySI)
+}
+
+// cacheClaimName is the name the StatefulSet controller gives the cache
+// volume claim of the pod of node.
+func cacheClaimName(node *cdnv3.ContentDeliveryNetworkNode) string {
+	return "content-" + node.Name + "-0"
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ContentDeliveryNetworkNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cdnv3.ContentDeliveryNetworkNode{}).
+		Owns(&appsv1.StatefulSet{}).
+		// The pod spec of the edge follows the one of the CDN
+		Watches(&cdnv3.ContentDeliveryNetwork{}, handler.EnqueueRequestsFromMapFunc(r.cdnRequests),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// The health verdict of the DNS layer is recorded on the pods
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			node := obj.GetLabels()[edgeNodeLabel]
+			if node == "" {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: node, Namespace: obj.GetNamespace()}}}
+		})).
 		Complete(r)
 }
+
+// cdnRequests maps a ContentDeliveryNetwork to the nodes referring to it.
+func (r *ContentDeliveryNetworkNodeReconciler) cdnRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	var list cdnv3.ContentDeliveryNetworkNodeList
+	if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to list ContentDeliveryNetworkNodes")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range list.Items {
+		if list.Items[i].Spec.CDNRef == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+	}
+	return requests
+}
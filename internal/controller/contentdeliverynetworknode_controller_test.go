@@ -51,7 +51,9 @@ var _ = Describe("ContentDeliveryNetworkNode Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: cdnv3.ContentDeliveryNetworkNodeSpec{
+						CacheSize: 1,
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -77,8 +79,53 @@ var _ = Describe("ContentDeliveryNetworkNode Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			By("Checking the node drains before it is deleted")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, contentdeliverynetworknode)).To(Succeed())
+			Expect(contentdeliverynetworknode.Finalizers).To(ConsistOf(nodeFinalizer))
+			Expect(contentdeliverynetworknode.Status.Available).To(BeFalse())
+		})
+	})
+
+	Context("When a ContentDeliveryNetwork changes", func() {
+		ctx := context.Background()
+		refs := map[string]string{"node-a": "cdn-a", "node-b": "cdn-b", "node-c": "cdn-a"}
+
+		BeforeEach(func() {
+			for name, cdn := range refs {
+				Expect(k8sClient.Create(ctx, &cdnv3.ContentDeliveryNetworkNode{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+					Spec:       cdnv3.ContentDeliveryNetworkNodeSpec{CDNRef: cdn, CacheSize: 1},
+				})).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			for name := range refs {
+				Expect(k8sClient.Delete(ctx, &cdnv3.ContentDeliveryNetworkNode{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				})).To(Succeed())
+			}
+		})
+
+		It("should reconcile the nodes referring to it", func() {
+			controllerReconciler := &ContentDeliveryNetworkNodeReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			requests := controllerReconciler.cdnRequests(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "cdn-a", Namespace: "default"},
+			})
+			Expect(requests).To(ConsistOf(
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "node-a", Namespace: "default"}},
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "node-c", Namespace: "default"}},
+			))
+
+			By("Ignoring CDNs of other namespaces")
+			Expect(controllerReconciler.cdnRequests(ctx, &cdnv3.ContentDeliveryNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "cdn-a", Namespace: "other"},
+			})).To(BeEmpty())
 		})
 	})
 })
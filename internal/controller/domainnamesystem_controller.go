@@ -18,45 +18,1050 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	cdnv3 "github.com/benauro/kube-cdn/api/v3"
 )
 
+const (
+	// Zones rendered from the served CDNs, read by the dns binary
+	dnsZonesKey       = "zones.json"
+	dnsZonesMountPath = "/etc/kube-cdn/dns"
+	// Hash of the rendered zones without their serial, which only moves when
+	// the hash does
+	dnsZonesHashAnnotation   = "cdn.benauro.gg/zones-hash"
+	dnsZonesSerialAnnotation = "cdn.benauro.gg/zones-serial"
+
+	// Ports the dns binary listens on
+	dnsPort       = 5353
+	dnsHealthPort = 8081
+
+	DNSStatePending = "Pending"
+	DNSStateReady   = "Ready"
+
+	// Region of an edge pod, overriding the region of its node
+	edgeRegionLabel = "cdn.benauro.gg/region"
+	// Last health check verdict on an edge pod, healthy or unhealthy
+	edgeHealthAnnotation = "cdn.benauro.gg/edge-health"
+
+	// Health checks running at once per DomainNameSystem
+	maxConcurrentHealthChecks = 16
+)
+
 // DomainNameSystemReconciler reconciles a DomainNameSystem object
 type DomainNameSystemReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	mu     sync.Mutex
+	health map[edgeHealthKey]*edgeHealth
 }
 
+// edgeHealthKey identifies an edge pod as checked by a DomainNameSystem,
+// each applying its own thresholds
+type edgeHealthKey struct {
+	dns types.NamespacedName
+	pod types.UID
+}
+
+// edgeHealth is the health check state of an edge pod
+type edgeHealth struct {
+	healthy   bool
+	successes int
+	failures  int
+	ejected   time.Time
+	// Last probe, the pod is probed again once IntervalSeconds have passed
+	checked time.Time
+}
+
+// dnsConfig mirrors the zone config read by the dns binary
+type (
+	dnsConfig struct {
+		Zones     []dnsZone `json:"zones"`
+		GeoIPFile string    `json:"geoIPFile,omitempty"`
+	}
+
+	dnsZone struct {
+		Name                string            `json:"name"`
+		DNSSEC              *dnsZoneDNSSEC    `json:"dnssec,omitempty"`
+		Nameservers         []string          `json:"nameservers"`
+		NameserverAddresses []string          `json:"nameserverAddresses,omitempty"`
+		Hostmaster          string            `json:"hostmaster,omitempty"`
+		Serial              uint32            `json:"serial"`
+		TTL                 uint32            `json:"ttl"`
+		Hosts               []dnsHost         `json:"hosts,omitempty"`
+		Regions             []cdnv3.DNSRegion `json:"regions,omitempty"`
+	}
+
+	dnsHost struct {
+		Name      string            `json:"name"`
+		Endpoints []dnsEndpoint     `json:"endpoints"`
+		Regions   []cdnv3.DNSRegion `json:"regions,omitempty"`
+		Pools     []dnsPool         `json:"pools,omitempty"`
+	}
+
+	dnsPool struct {
+		Name      string        `json:"name"`
+		Weight    uint32        `json:"weight"`
+		Endpoints []dnsEndpoint `json:"endpoints"`
+	}
+
+	dnsEndpoint struct {
+		Address string `json:"address"`
+		Region  string `json:"region,omitempty"`
+	}
+)
+
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=domainnamesystems,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=domainnamesystems/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cdn.benauro.gg,resources=domainnamesystems/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the DomainNameSystem object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.3/pkg/reconcile
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch
+
+// Reconcile deploys the DNS server and renders the zones it serves from the
+// healthy edge pods of every CDN inside them.
 func (r *DomainNameSystemReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
+
+	var dns cdnv3.DomainNameSystem
+	if err := r.Get(ctx, req.NamespacedName, &dns); err != nil {
+		if errors.IsNotFound(err) {
+			r.forgetEdges(req.NamespacedName, nil)
+		}
+		logger.Error(err, "Unable to fetch DomainNameSystem")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	check := healthCheck(dns.Spec.HealthCheck)
+	modes := dnsModes(&dns)
+
+	// Handle service first, its addresses are the nameserver glue
+	var addresses []string
+	if modes[cdnv3.DNSModeServer] {
+		service, err := r.reconcileDNSService(ctx, &dns)
+		if err != nil {
+			logger.Error(err, "Failed to reconcile DNS service")
+			return ctrl.Result{}, err
+		}
+		addresses = serviceAddresses(service)
+	}
+
+	cdns, err := r.servedCDNs(ctx, &dns)
+	if err != nil {
+		logger.Error(err, "Failed to list served CDNs")
+		return ctrl.Result{}, err
+	}
+
+	pooled, err := r.pooledCDNs(ctx, &dns, cdns)
+	if err != nil {
+		logger.Error(err, "Failed to get pooled CDNs")
+		return ctrl.Result{}, err
+	}
+
+	// Health checks
+	healthy, err := r.checkEdges(ctx, &dns, check, append(pooled, cdns...))
+	if err != nil {
+		logger.Error(err, "Failed to check edge health")
+		return ctrl.Result{}, err
+	}
+
+	zones, err := r.renderZones(ctx, &dns, cdns, healthy, addresses)
+	if err != nil {
+		logger.Error(err, "Failed to render DNS zones")
+		return ctrl.Result{}, err
+	}
+
+	dns.Status.State = DNSStateReady
+	var answers map[string]map[string]int64
+	if modes[cdnv3.DNSModeServer] {
+		// Signing keys, the zones name them
+		dns.Status.DNSSEC, err = r.reconcileDNSSEC(ctx, &dns, zones)
+		if err != nil {
+			logger.Error(err, "Failed to reconcile DNSSEC keys")
+			return ctrl.Result{}, err
+		}
+
+		// Handle zones
+		if err := r.reconcileZones(ctx, &dns, zones); err != nil {
+			logger.Error(err, "Failed to reconcile DNS zones")
+			return ctrl.Result{}, err
+		}
+
+		// Handle DNS server
+		deployment, err := r.reconcileDNSDeployment(ctx, &dns)
+		if err != nil {
+			logger.Error(err, "Failed to reconcile DNS deployment")
+			return ctrl.Result{}, err
+		}
+
+		// Auto scaling
+		if err := r.reconcileDNSAutoscaler(ctx, &dns); err != nil {
+			logger.Error(err, "Failed to reconcile DNS autoscaler")
+			return ctrl.Result{}, err
+		}
 
-	// TODO(user): your logic here
+		if deployment.Status.AvailableReplicas == 0 {
+			dns.Status.State = DNSStatePending
+		}
 
-	return ctrl.Result{}, nil
+		answers, err = r.scrapeAnswers(ctx, &dns)
+		if err != nil {
+			logger.Error(err, "Failed to list DNS server pods")
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteDNSServer(ctx, &dns); err != nil {
+		logger.Error(err, "Failed to delete DNS server")
+		return ctrl.Result{}, err
+	}
+
+	// Handle exports
+	if err := r.reconcileZoneFiles(ctx, &dns, zones, modes[cdnv3.DNSModeZoneFile]); err != nil {
+		logger.Error(err, "Failed to reconcile zone files")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileDNSEndpoint(ctx, &dns, zones, modes[cdnv3.DNSModeDNSEndpoint]); err != nil {
+		logger.Error(err, "Failed to reconcile DNSEndpoint")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileExternalDNSAnnotations(ctx, &dns, cdns, modes[cdnv3.DNSModeAnnotations]); err != nil {
+		logger.Error(err, "Failed to reconcile ExternalDNS annotations")
+		return ctrl.Result{}, err
+	}
+
+	dns.Status.Addresses = addresses
+	dns.Status.WeightedRecords = weightedRecordStatus(&dns, zones, answers)
+	if err := r.Status().Update(ctx, &dns); err != nil {
+		logger.Error(err, "Unable to update DomainNameSystem status")
+		return ctrl.Result{}, err
+	}
+
+	// Requeue for the next health check
+	return ctrl.Result{RequeueAfter: time.Duration(check.IntervalSeconds) * time.Second}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DomainNameSystemReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cdnv3.DomainNameSystem{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Watches(&cdnv3.ContentDeliveryNetwork{}, handler.EnqueueRequestsFromMapFunc(r.namespaceRequests)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.namespaceRequests)).
 		Complete(r)
 }
+
+// namespaceRequests maps a CDN or edge pod to the DomainNameSystems of its
+// namespace, any of which may serve its records.
+func (r *DomainNameSystemReconciler) namespaceRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	if _, ok := obj.(*corev1.Pod); ok && obj.GetLabels()["app"] == "" {
+		return nil
+	}
+
+	var list cdnv3.DomainNameSystemList
+	if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to list DomainNameSystems")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, dns := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&dns)})
+	}
+	return requests
+}
+
+func dnsLabels(dns *cdnv3.DomainNameSystem) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     "kube-cdn-dns",
+		"app.kubernetes.io/instance": dns.Name,
+	}
+}
+
+func (r *DomainNameSystemReconciler) reconcileDNSService(ctx context.Context, dns *cdnv3.DomainNameSystem) (*corev1.Service, error) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dns.Name + "-dns",
+			Namespace: dns.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+		service.Spec.Selector = dnsLabels(dns)
+		service.Spec.Type = dns.Spec.ServiceType
+		if service.Spec.Type == "" {
+			service.Spec.Type = corev1.ServiceTypeLoadBalancer
+		}
+		switch service.Spec.Type {
+		case corev1.ServiceTypeLoadBalancer:
+			// Keeps the resolver address, which answers may depend on
+			service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyLocal
+		case corev1.ServiceTypeClusterIP:
+			service.Spec.ExternalTrafficPolicy = ""
+		}
+		ports := []corev1.ServicePort{
+			{Name: "dns-udp", Protocol: corev1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt32(dnsPort)},
+			{Name: "dns-tcp", Protocol: corev1.ProtocolTCP, Port: 53, TargetPort: intstr.FromInt32(dnsPort)},
+		}
+		// Keep allocated node ports
+		for i := range ports {
+			for _, existing := range service.Spec.Ports {
+				if existing.Name == ports[i].Name {
+					ports[i].NodePort = existing.NodePort
+				}
+			}
+		}
+		service.Spec.Ports = ports
+		return controllerutil.SetControllerReference(dns, service, r.Scheme)
+	})
+	return service, err
+}
+
+// serviceAddresses returns the addresses clients reach service at: its load
+// balancer ingress when it has one, its cluster IPs otherwise.
+func serviceAddresses(service *corev1.Service) []string {
+	var addresses []string
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				addresses = append(addresses, ingress.IP)
+			}
+		}
+		return addresses
+	}
+	for _, ip := range service.Spec.ClusterIPs {
+		if ip != "" && ip != corev1.ClusterIPNone {
+			addresses = append(addresses, ip)
+		}
+	}
+	return addresses
+}
+
+// dnsModes returns the modes dns publishes its zones in, the DNS server
+// alone by default.
+func dnsModes(dns *cdnv3.DomainNameSystem) map[cdnv3.DNSMode]bool {
+	modes := map[cdnv3.DNSMode]bool{}
+	for _, mode := range dns.Spec.Modes {
+		modes[mode] = true
+	}
+	if len(modes) == 0 {
+		modes[cdnv3.DNSModeServer] = true
+	}
+	return modes
+}
+
+// deleteDNSServer removes the DNS server of dns once the Server mode is
+// dropped.
+func (r *DomainNameSystemReconciler) deleteDNSServer(ctx context.Context, dns *cdnv3.DomainNameSystem) error {
+	objectMeta := func(suffix string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: dns.Name + suffix, Namespace: dns.Namespace}
+	}
+	for _, obj := range []client.Object{
+		&autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: objectMeta("-dns")},
+		&appsv1.Deployment{ObjectMeta: objectMeta("-dns")},
+		&corev1.Service{ObjectMeta: objectMeta("-dns")},
+		&corev1.ConfigMap{ObjectMeta: objectMeta("-dns-zones")},
+	} {
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileZones writes the zones served by dns into the ConfigMap the DNS
+// server loads them from.
+func (r *DomainNameSystemReconciler) reconcileZones(ctx context.Context, dns *cdnv3.DomainNameSystem, zones []dnsZone) error {
+	geoIPFile := ""
+	if db := dns.Spec.GeoIPDatabase; db != nil && db.CountryFile != "" {
+		geoIPFile = geoIPMountPath + "/" + db.CountryFile
+	}
+	return r.reconcileZoneConfigMap(ctx, dns, dns.Name+"-dns-zones", zones, func(zones []dnsZone) (map[string]string, error) {
+		data, err := json.Marshal(dnsConfig{Zones: zones, GeoIPFile: geoIPFile})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{dnsZonesKey: string(data)}, nil
+	})
+}
+
+// reconcileZoneConfigMap writes zones into the named ConfigMap as rendered
+// by render. Their serial is bumped whenever the rendering changes.
+func (r *DomainNameSystemReconciler) reconcileZoneConfigMap(ctx context.Context, dns *cdnv3.DomainNameSystem, name string, zones []dnsZone, render func([]dnsZone) (map[string]string, error)) error {
+	unsigned, err := render(zones)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(unsigned)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dns.Namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Annotations[dnsZonesHashAnnotation] != hash {
+			// Secondaries and resolvers compare serials, bump them on change
+			serial := max(uint32(time.Now().Unix()), previousSerial(configMap)+1)
+			signed := append([]dnsZone(nil), zones...)
+			for i := range signed {
+				signed[i].Serial = serial
+			}
+			data, err := render(signed)
+			if err != nil {
+				return err
+			}
+			configMap.Data = data
+			if configMap.Annotations == nil {
+				configMap.Annotations = map[string]string{}
+			}
+			configMap.Annotations[dnsZonesHashAnnotation] = hash
+			configMap.Annotations[dnsZonesSerialAnnotation] = strconv.FormatUint(uint64(serial), 10)
+		}
+		return controllerutil.SetControllerReference(dns, configMap, r.Scheme)
+	})
+	return err
+}
+
+func previousSerial(configMap *corev1.ConfigMap) uint32 {
+	if serial, err := strconv.ParseUint(configMap.Annotations[dnsZonesSerialAnnotation], 10, 32); err == nil {
+		return uint32(serial)
+	}
+	var previous dnsConfig
+	if err := json.Unmarshal([]byte(configMap.Data[dnsZonesKey]), &previous); err != nil || len(previous.Zones) == 0 {
+		return 0
+	}
+	return previous.Zones[0].Serial
+}
+
+// servedCDNs returns the CDNs of the namespace whose domain name falls in a
+// zone of dns.
+func (r *DomainNameSystemReconciler) servedCDNs(ctx context.Context, dns *cdnv3.DomainNameSystem) ([]*cdnv3.ContentDeliveryNetwork, error) {
+	zones := make([]dnsZone, 0, len(dns.Spec.Zones))
+	for _, name := range dns.Spec.Zones {
+		zones = append(zones, dnsZone{Name: fqdn(name)})
+	}
+
+	var list cdnv3.ContentDeliveryNetworkList
+	if err := r.List(ctx, &list, client.InNamespace(dns.Namespace)); err != nil {
+		return nil, err
+	}
+	var cdns []*cdnv3.ContentDeliveryNetwork
+	for i := range list.Items {
+		cdn := &list.Items[i]
+		if cdn.Spec.DomainName != "" && zoneFor(zones, fqdn(cdn.Spec.DomainName)) != nil {
+			cdns = append(cdns, cdn)
+		}
+	}
+	return cdns, nil
+}
+
+// pooledCDNs returns the CDNs of the namespace in weighted pools of dns and
+// not already in cdns, whose edges have to be checked as well.
+func (r *DomainNameSystemReconciler) pooledCDNs(ctx context.Context, dns *cdnv3.DomainNameSystem, cdns []*cdnv3.ContentDeliveryNetwork) ([]*cdnv3.ContentDeliveryNetwork, error) {
+	known := map[string]bool{}
+	for _, cdn := range cdns {
+		known[cdn.Name] = true
+	}
+	var pooled []*cdnv3.ContentDeliveryNetwork
+	for _, set := range dns.Spec.WeightedRecords {
+		for _, pool := range set.Pools {
+			if pool.ContentDeliveryNetwork == "" || known[pool.ContentDeliveryNetwork] {
+				continue
+			}
+			known[pool.ContentDeliveryNetwork] = true
+			cdn := &cdnv3.ContentDeliveryNetwork{}
+			if err := r.Get(ctx, types.NamespacedName{Name: pool.ContentDeliveryNetwork, Namespace: dns.Namespace}, cdn); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			pooled = append(pooled, cdn)
+		}
+	}
+	return pooled, nil
+}
+
+// renderZones builds the zones of dns with their serial left at 0. Each CDN
+// is served by the most specific zone containing its domain name, with the
+// addresses of its healthy edge pods.
+func (r *DomainNameSystemReconciler) renderZones(ctx context.Context, dns *cdnv3.DomainNameSystem, cdns []*cdnv3.ContentDeliveryNetwork, healthy map[string][]*corev1.Pod, nameserverAddresses []string) ([]dnsZone, error) {
+	ttl := uint32(30)
+	if dns.Spec.TTL > 0 {
+		ttl = uint32(dns.Spec.TTL)
+	}
+
+	zones := make([]dnsZone, 0, len(dns.Spec.Zones))
+	for _, name := range dns.Spec.Zones {
+		zone := dnsZone{
+			Name:        fqdn(name),
+			Nameservers: []string{},
+			Hostmaster:  dns.Spec.Hostmaster,
+			TTL:         ttl,
+		}
+		for _, ns := range dns.Spec.Nameservers {
+			zone.Nameservers = append(zone.Nameservers, fqdn(ns))
+		}
+		if len(zone.Nameservers) == 0 {
+			zone.Nameservers = append(zone.Nameservers, "ns1."+zone.Name)
+		}
+		zone.NameserverAddresses = nameserverAddresses
+		zone.Regions = dns.Spec.Regions
+		zones = append(zones, zone)
+	}
+
+	nodeRegions := map[string]string{}
+	for _, cdn := range cdns {
+		zone := zoneFor(zones, fqdn(cdn.Spec.DomainName))
+		endpoints, err := r.edgeEndpoints(ctx, healthy[cdn.Name], nodeRegions)
+		if err != nil {
+			return nil, err
+		}
+		zone.Hosts = append(zone.Hosts, dnsHost{
+			Name:      fqdn(cdn.Spec.DomainName),
+			Endpoints: endpoints,
+			Regions:   cdn.Spec.DNS.Spec.Regions,
+		})
+	}
+
+	for _, set := range dns.Spec.WeightedRecords {
+		name := fqdn(set.Hostname)
+		zone := zoneFor(zones, name)
+		if zone == nil {
+			continue
+		}
+		host := dnsHost{Name: name, Endpoints: []dnsEndpoint{}}
+		// Replace the records of the CDN with this domain, keeping its regions
+		for i := range zone.Hosts {
+			if zone.Hosts[i].Name == name {
+				host.Regions = zone.Hosts[i].Regions
+				zone.Hosts = append(zone.Hosts[:i], zone.Hosts[i+1:]...)
+				break
+			}
+		}
+		for _, p := range set.Pools {
+			pool := dnsPool{Name: p.Name, Weight: uint32(p.Weight)}
+			endpoints, err := r.edgeEndpoints(ctx, healthy[p.ContentDeliveryNetwork], nodeRegions)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range p.Addresses {
+				// A bad address would get the whole config rejected
+				if net.ParseIP(addr) != nil {
+					endpoints = append(endpoints, dnsEndpoint{Address: addr})
+				}
+			}
+			sortEndpoints(endpoints)
+			pool.Endpoints = endpoints
+			// Exports can't split by weight, they list every pool in use
+			if pool.Weight > 0 {
+				host.Endpoints = append(host.Endpoints, endpoints...)
+			}
+			host.Pools = append(host.Pools, pool)
+		}
+		sortEndpoints(host.Endpoints)
+		zone.Hosts = append(zone.Hosts, host)
+	}
+	for i := range zones {
+		sort.Slice(zones[i].Hosts, func(a, b int) bool { return zones[i].Hosts[a].Name < zones[i].Hosts[b].Name })
+	}
+	return zones, nil
+}
+
+// edgeEndpoints returns the addresses of the edge pods with their region.
+func (r *DomainNameSystemReconciler) edgeEndpoints(ctx context.Context, pods []*corev1.Pod, nodeRegions map[string]string) ([]dnsEndpoint, error) {
+	endpoints := []dnsEndpoint{}
+	for _, pod := range pods {
+		region, err := r.edgeRegion(ctx, pod, nodeRegions)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range pod.Status.PodIPs {
+			endpoints = append(endpoints, dnsEndpoint{Address: ip.IP, Region: region})
+		}
+	}
+	sortEndpoints(endpoints)
+	return endpoints, nil
+}
+
+// sortEndpoints gives a stable output, so the zone hash only moves on real
+// changes.
+func sortEndpoints(endpoints []dnsEndpoint) {
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+}
+
+// edgeRegion returns the region of an edge pod, from its own label or else
+// from the topology label of its node. Node regions are memoized in cache.
+func (r *DomainNameSystemReconciler) edgeRegion(ctx context.Context, pod *corev1.Pod, cache map[string]string) (string, error) {
+	if region := pod.Labels[edgeRegionLabel]; region != "" {
+		return region, nil
+	}
+	if pod.Spec.NodeName == "" {
+		return "", nil
+	}
+	if region, ok := cache[pod.Spec.NodeName]; ok {
+		return region, nil
+	}
+
+	var node corev1.Node
+	if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+	}
+	cache[pod.Spec.NodeName] = node.Labels[corev1.LabelTopologyRegion]
+	return cache[pod.Spec.NodeName], nil
+}
+
+// healthCheck returns check with defaults applied, for objects created
+// before the CRD defaults existed.
+func healthCheck(check cdnv3.DNSHealthCheck) cdnv3.DNSHealthCheck {
+	if check.Path == "" {
+		check.Path = "/healthz"
+	}
+	if check.IntervalSeconds <= 0 {
+		check.IntervalSeconds = 10
+	}
+	if check.TimeoutSeconds <= 0 {
+		check.TimeoutSeconds = 2
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = 2
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = 3
+	}
+	return check
+}
+
+// checkEdges probes the edge pods of cdns and returns the healthy ones by
+// CDN name. A pod is ejected after UnhealthyThreshold failed checks in a row
+// and comes back after HealthyThreshold successful ones, but not before
+// HoldDownSeconds have passed since its ejection. Pods are probed once per
+// IntervalSeconds however often the DomainNameSystem is reconciled, in
+// between their last verdict stands. The verdict is recorded on the pods,
+// from where the ContentDeliveryNetworkNode controller picks it up.
+func (r *DomainNameSystemReconciler) checkEdges(ctx context.Context, dns *cdnv3.DomainNameSystem, check cdnv3.DNSHealthCheck, cdns []*cdnv3.ContentDeliveryNetwork) (map[string][]*corev1.Pod, error) {
+	dnsKey := client.ObjectKeyFromObject(dns)
+	seen := map[edgeHealthKey]bool{}
+	healthy := map[string][]*corev1.Pod{}
+
+	for _, cdn := range cdns {
+		pods, err := edgePods(ctx, r, cdn)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		states := make([]*edgeHealth, len(pods))
+		var due []int
+		r.mu.Lock()
+		if r.health == nil {
+			r.health = map[edgeHealthKey]*edgeHealth{}
+		}
+		for i, pod := range pods {
+			key := edgeHealthKey{dns: dnsKey, pod: pod.UID}
+			seen[key] = true
+			h, ok := r.health[key]
+			if !ok {
+				// Trust the kubelet until checked, so a controller restart
+				// doesn't empty the zones
+				h = &edgeHealth{healthy: podReady(pod)}
+				r.health[key] = h
+			}
+			states[i] = h
+			if h.due(check, now) {
+				due = append(due, i)
+			}
+		}
+		r.mu.Unlock()
+
+		probed := make([]*corev1.Pod, len(due))
+		for j, i := range due {
+			probed[j] = pods[i]
+		}
+		results := probeEdges(ctx, check, cdn.Spec.DomainName, probed)
+
+		r.mu.Lock()
+		for j, i := range due {
+			states[i].observe(results[j], check, now)
+		}
+		for i, pod := range pods {
+			if states[i].healthy {
+				healthy[cdn.Name] = append(healthy[cdn.Name], pod)
+			}
+		}
+		r.mu.Unlock()
+
+		if err := r.recordEdgeHealth(ctx, pods, healthy[cdn.Name]); err != nil {
+			return nil, err
+		}
+	}
+	r.forgetEdges(dnsKey, seen)
+
+	return healthy, nil
+}
+
+// due reports whether the pod is to be probed at now. Reconciles are
+// triggered by every change to the pods and the CDNs, probing on each would
+// reach the thresholds within moments.
+func (h *edgeHealth) due(check cdnv3.DNSHealthCheck, now time.Time) bool {
+	return h.checked.IsZero() || now.Sub(h.checked) >= time.Duration(check.IntervalSeconds)*time.Second
+}
+
+// observe applies the result of a check made at now to the pod state.
+func (h *edgeHealth) observe(ok bool, check cdnv3.DNSHealthCheck, now time.Time) {
+	h.checked = now
+	if ok {
+		h.successes++
+		h.failures = 0
+	} else {
+		h.failures++
+		h.successes = 0
+	}
+
+	holdDown := time.Duration(check.HoldDownSeconds) * time.Second
+	switch {
+	case h.healthy && h.failures >= check.UnhealthyThreshold:
+		h.healthy = false
+		h.ejected = now
+	case !h.healthy && h.successes >= check.HealthyThreshold && now.Sub(h.ejected) >= holdDown:
+		h.healthy = true
+	}
+}
+
+// forgetEdges drops the state of the pods checked by dns but not in seen.
+func (r *DomainNameSystemReconciler) forgetEdges(dns types.NamespacedName, seen map[edgeHealthKey]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.health {
+		if key.dns == dns && !seen[key] {
+			delete(r.health, key)
+		}
+	}
+}
+
+// probeEdges requests the health check path from every pod, as sent to
+// host, and reports which ones answered with a success.
+func probeEdges(ctx context.Context, check cdnv3.DNSHealthCheck, host string, pods []*corev1.Pod) []bool {
+	httpClient := &http.Client{
+		Timeout: time.Duration(check.TimeoutSeconds) * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	results := make([]bool, len(pods))
+	slots := make(chan struct{}, maxConcurrentHealthChecks)
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, pod *corev1.Pod) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			target := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(edgePort)) + check.Path
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			if err != nil {
+				return
+			}
+			req.Host = host
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			results[i] = resp.StatusCode < http.StatusBadRequest
+		}(i, pod)
+	}
+	wg.Wait()
+	return results
+}
+
+// recordEdgeHealth annotates pods with their verdict when it changed.
+func (r *DomainNameSystemReconciler) recordEdgeHealth(ctx context.Context, pods, healthy []*corev1.Pod) error {
+	isHealthy := map[types.UID]bool{}
+	for _, pod := range healthy {
+		isHealthy[pod.UID] = true
+	}
+
+	for _, pod := range pods {
+		verdict := "unhealthy"
+		if isHealthy[pod.UID] {
+			verdict = "healthy"
+		}
+		if pod.Annotations[edgeHealthAnnotation] == verdict {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[edgeHealthAnnotation] = verdict
+		if err := r.Patch(ctx, pod, patch); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrapeAnswers sums the answers given per weighted host and pool by the
+// DNS server pods. Pods failing to report are skipped, their counts show up
+// again on the next round.
+func (r *DomainNameSystemReconciler) scrapeAnswers(ctx context.Context, dns *cdnv3.DomainNameSystem) (map[string]map[string]int64, error) {
+	if len(dns.Spec.WeightedRecords) == 0 {
+		return nil, nil
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(dns.Namespace), client.MatchingLabels(dnsLabels(dns))); err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	answers := map[string]map[string]int64{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		var stats struct {
+			Answers map[string]map[string]int64 `json:"answers"`
+		}
+		target := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(dnsHealthPort)) + "/stats"
+		if err := getJSON(ctx, httpClient, target, &stats); err != nil {
+			log.FromContext(ctx).Info("Unable to scrape DNS server answers", "pod", pod.Name, "error", err.Error())
+			continue
+		}
+		for host, pools := range stats.Answers {
+			if answers[host] == nil {
+				answers[host] = map[string]int64{}
+			}
+			for pool, n := range pools {
+				answers[host][pool] += n
+			}
+		}
+	}
+	return answers, nil
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// weightedRecordStatus reports the weighted record sets of dns as rendered
+// in zones, with the answers given from each pool.
+func weightedRecordStatus(dns *cdnv3.DomainNameSystem, zones []dnsZone, answers map[string]map[string]int64) []cdnv3.DNSWeightedRecordStatus {
+	var statuses []cdnv3.DNSWeightedRecordStatus
+	for _, set := range dns.Spec.WeightedRecords {
+		name := fqdn(set.Hostname)
+		status := cdnv3.DNSWeightedRecordStatus{Hostname: set.Hostname, Pools: []cdnv3.DNSPoolStatus{}}
+		var host *dnsHost
+		if zone := zoneFor(zones, name); zone != nil {
+			for i := range zone.Hosts {
+				if zone.Hosts[i].Name == name {
+					host = &zone.Hosts[i]
+				}
+			}
+		}
+		for i, pool := range set.Pools {
+			poolStatus := cdnv3.DNSPoolStatus{
+				Name:    pool.Name,
+				Weight:  pool.Weight,
+				Answers: answers[name][pool.Name],
+			}
+			if host != nil && i < len(host.Pools) {
+				poolStatus.Endpoints = len(host.Pools[i].Endpoints)
+			}
+			status.Pools = append(status.Pools, poolStatus)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func fqdn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// zoneFor returns the most specific zone containing name.
+func zoneFor(zones []dnsZone, name string) *dnsZone {
+	var best *dnsZone
+	for i := range zones {
+		z := &zones[i]
+		if (name == z.Name || strings.HasSuffix(name, "."+z.Name)) && (best == nil || len(z.Name) > len(best.Name)) {
+			best = z
+		}
+	}
+	return best
+}
+
+func (r *DomainNameSystemReconciler) reconcileDNSDeployment(ctx context.Context, dns *cdnv3.DomainNameSystem) (*appsv1.Deployment, error) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dns.Name + "-dns",
+			Namespace: dns.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		labels := dnsLabels(dns)
+		if deployment.CreationTimestamp.IsZero() {
+			// Replicas are left to the autoscaler afterwards
+			replicas := int32(max(dns.Spec.MinReplicas, 1))
+			deployment.Spec.Replicas = &replicas
+			deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deployment.Spec.Template.Labels = labels
+
+		podSpec := &deployment.Spec.Template.Spec
+		podSpec.Containers = []corev1.Container{
+			{
+				Name:  "dns-server",
+				Image: "benauro/kube-cdn-dns:latest",
+				Env: []corev1.EnvVar{
+					{Name: "DNS_CONFIG_PATH", Value: dnsZonesMountPath + "/" + dnsZonesKey},
+				},
+				Ports: []corev1.ContainerPort{
+					{Name: "dns-udp", ContainerPort: dnsPort, Protocol: corev1.ProtocolUDP},
+					{Name: "dns-tcp", ContainerPort: dnsPort, Protocol: corev1.ProtocolTCP},
+					{Name: "health", ContainerPort: dnsHealthPort, Protocol: corev1.ProtocolTCP},
+				},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("health")},
+					},
+					PeriodSeconds: 5,
+				},
+				// The autoscaler scales on CPU utilization of the request
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("50m"),
+						corev1.ResourceMemory: resource.MustParse("32Mi"),
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "zones", MountPath: dnsZonesMountPath, ReadOnly: true},
+				},
+			},
+		}
+		podSpec.Volumes = []corev1.Volume{
+			{
+				Name: "zones",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: dns.Name + "-dns-zones"},
+					},
+				},
+			},
+		}
+		if db := dns.Spec.GeoIPDatabase; db != nil {
+			addGeoIPVolume(podSpec, db)
+		}
+		if dns.Spec.DNSSEC != nil {
+			// Optional, the keys are created right after
+			optional := true
+			container := &podSpec.Containers[0]
+			container.Env = append(container.Env, corev1.EnvVar{Name: "DNSSEC_KEYS_PATH", Value: dnssecKeysMountPath})
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "dnssec", MountPath: dnssecKeysMountPath, ReadOnly: true})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "dnssec",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: dns.Name + "-dnssec", Optional: &optional},
+				},
+			})
+		}
+		return controllerutil.SetControllerReference(dns, deployment, r.Scheme)
+	})
+	return deployment, err
+}
+
+func (r *DomainNameSystemReconciler) reconcileDNSAutoscaler(ctx context.Context, dns *cdnv3.DomainNameSystem) error {
+	minReplicas := int32(max(dns.Spec.MinReplicas, 1))
+	maxReplicas := max(int32(dns.Spec.MaxReplicas), minReplicas)
+	utilization := int32(70)
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dns.Name + "-dns",
+			Namespace: dns.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, hpa, func() error {
+		hpa.Spec.ScaleTargetRef = autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       dns.Name + "-dns",
+		}
+		hpa.Spec.MinReplicas = &minReplicas
+		hpa.Spec.MaxReplicas = maxReplicas
+		hpa.Spec.Metrics = []autoscalingv2.MetricSpec{
+			{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name: corev1.ResourceCPU,
+					Target: autoscalingv2.MetricTarget{
+						Type:               autoscalingv2.UtilizationMetricType,
+						AverageUtilization: &utilization,
+					},
+				},
+			},
+		}
+		return controllerutil.SetControllerReference(dns, hpa, r.Scheme)
+	})
+	return err
+}
@@ -18,9 +18,12 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -51,7 +54,14 @@ var _ = Describe("DomainNameSystem Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: cdnv3.DomainNameSystemSpec{
+						Modes:       []cdnv3.DNSMode{cdnv3.DNSModeServer, cdnv3.DNSModeZoneFile},
+						MinReplicas: 1,
+						MaxReplicas: 3,
+						Zones:       []string{"example.com"},
+						Nameservers: []string{"ns1.example.com"},
+						DNSSEC:      &cdnv3.DNSSEC{},
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -77,8 +87,80 @@ var _ = Describe("DomainNameSystem Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			By("Rendering the zones for the DNS server")
+			configMap := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-dns-zones", Namespace: "default"}, configMap)).To(Succeed())
+			Expect(configMap.Data[dnsZonesKey]).To(ContainSubstring(`"name":"example.com."`))
+
+			By("Exporting the zone files")
+			zoneFiles := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-zonefile", Namespace: "default"}, zoneFiles)).To(Succeed())
+			Expect(zoneFiles.Data["example.com.zone"]).To(ContainSubstring("example.com.\tIN\tNS\tns1.example.com."))
+
+			By("Deploying the DNS server")
+			deployment := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-dns", Namespace: "default"}, deployment)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, domainnamesystem)).To(Succeed())
+			Expect(domainnamesystem.Status.State).To(Equal(DNSStatePending))
+
+			By("Publishing the DS record of the signed zone")
+			Expect(domainnamesystem.Status.DNSSEC).To(HaveLen(1))
+			Expect(domainnamesystem.Status.DNSSEC[0].DS).To(HavePrefix("example.com. IN DS "))
 		})
 	})
 })
+
+var _ = Describe("Edge health checks", func() {
+	check := healthCheck(cdnv3.DNSHealthCheck{
+		IntervalSeconds:    10,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+		HoldDownSeconds:    60,
+	})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// observeAll feeds results to h one interval apart, returning the
+	// verdicts after each of them
+	observeAll := func(h *edgeHealth, results ...bool) []bool {
+		var verdicts []bool
+		for i, ok := range results {
+			h.observe(ok, check, start.Add(time.Duration(i)*10*time.Second))
+			verdicts = append(verdicts, h.healthy)
+		}
+		return verdicts
+	}
+
+	It("should eject a pod after UnhealthyThreshold failures in a row", func() {
+		h := &edgeHealth{healthy: true}
+		Expect(observeAll(h, false, false, true, false, false, false)).To(Equal([]bool{true, true, true, true, true, false}))
+		Expect(h.ejected).To(Equal(start.Add(50 * time.Second)))
+	})
+
+	It("should bring a pod back after HealthyThreshold successes and the hold down", func() {
+		h := &edgeHealth{healthy: false, ejected: start}
+		// Healthy twice within the hold down, then once past it
+		Expect(observeAll(h, true, true, true, true, true, true, true)).To(Equal([]bool{false, false, false, false, false, false, true}))
+	})
+
+	It("should bring a pod ejected long ago back after HealthyThreshold successes", func() {
+		h := &edgeHealth{healthy: false, ejected: start.Add(-time.Hour)}
+		Expect(observeAll(h, true, false, true, true)).To(Equal([]bool{false, false, false, true}))
+	})
+
+	It("should probe a pod once per interval", func() {
+		h := &edgeHealth{healthy: true}
+		Expect(h.due(check, start)).To(BeTrue(), "a pod never probed is due")
+
+		h.observe(false, check, start)
+		for _, after := range []time.Duration{0, time.Millisecond, 9 * time.Second} {
+			Expect(h.due(check, start.Add(after))).To(BeFalse(), "probed %s ago", after)
+		}
+		Expect(h.due(check, start.Add(10*time.Second))).To(BeTrue())
+
+		// Reconciles in between don't count as checks, a single failure
+		// stays below the threshold
+		Expect(h.failures).To(Equal(1))
+		Expect(h.healthy).To(BeTrue())
+	})
+})
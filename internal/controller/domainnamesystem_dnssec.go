@@ -0,0 +1,239 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dnslib "github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// Keys mounted into the dns binary, as <zone>.<key>.key and
+	// <zone>.<key>.private in the BIND format
+	dnssecKeysMountPath = "/etc/kube-cdn/dnssec"
+
+	// Key signing key, and the zone signing keys in use, pre-published ahead
+	// of a rollover and retiring after one
+	dnssecKSK     = "ksk"
+	dnssecZSK     = "zsk"
+	dnssecNextZSK = "zsk-next"
+	dnssecOldZSK  = "zsk-old"
+	// When the zone signing key in use was activated
+	dnssecZSKActivated = "zsk.activated"
+)
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// dnsZoneDNSSEC mirrors the signing config of a zone read by the dns binary
+type dnsZoneDNSSEC struct {
+	Denial    string   `json:"denial"`
+	KSK       string   `json:"ksk"`
+	ZSK       string   `json:"zsk"`
+	Published []string `json:"published,omitempty"`
+}
+
+// reconcileDNSSEC keeps the keys of the zones in the <dns>-dnssec Secret,
+// rolls their zone signing keys over and points the zones to the keys.
+func (r *DomainNameSystemReconciler) reconcileDNSSEC(ctx context.Context, dns *cdnv3.DomainNameSystem, zones []dnsZone) ([]cdnv3.DNSSECZoneStatus, error) {
+	if dns.Spec.DNSSEC == nil {
+		return nil, nil
+	}
+	denial := dns.Spec.DNSSEC.Denial
+	if denial == "" {
+		denial = "NSEC3"
+	}
+	days := dns.Spec.DNSSEC.ZSKRolloverDays
+	if days <= 0 {
+		days = 30
+	}
+	period := time.Duration(days) * 24 * time.Hour
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dns.Name + "-dnssec",
+			Namespace: dns.Namespace,
+		},
+	}
+	now := time.Now()
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		known := map[string]bool{}
+		for _, zone := range zones {
+			if err := rollKeys(secret.Data, zone.Name, period, dnssecPropagation(zone.TTL), now); err != nil {
+				return err
+			}
+			for _, key := range []string{dnssecKSK, dnssecZSK, dnssecNextZSK, dnssecOldZSK} {
+				known[dnssecKeyName(zone.Name, key)+".key"] = true
+				known[dnssecKeyName(zone.Name, key)+".private"] = true
+			}
+			known[dnssecKeyName(zone.Name, dnssecZSKActivated)] = true
+		}
+		// Drop the keys of the zones no longer served
+		for name := range secret.Data {
+			if !known[name] {
+				delete(secret.Data, name)
+			}
+		}
+		return controllerutil.SetControllerReference(dns, secret, r.Scheme)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]cdnv3.DNSSECZoneStatus, 0, len(zones))
+	for i := range zones {
+		zone := &zones[i]
+		zone.DNSSEC = &dnsZoneDNSSEC{
+			Denial: denial,
+			KSK:    dnssecKeyName(zone.Name, dnssecKSK),
+			ZSK:    dnssecKeyName(zone.Name, dnssecZSK),
+		}
+		for _, key := range []string{dnssecNextZSK, dnssecOldZSK} {
+			if len(secret.Data[dnssecKeyName(zone.Name, key)+".key"]) > 0 {
+				zone.DNSSEC.Published = append(zone.DNSSEC.Published, dnssecKeyName(zone.Name, key))
+			}
+		}
+
+		ksk, err := parseDNSKEY(secret.Data[dnssecKeyName(zone.Name, dnssecKSK)+".key"])
+		if err != nil {
+			return nil, err
+		}
+		zsk, err := parseDNSKEY(secret.Data[dnssecKeyName(zone.Name, dnssecZSK)+".key"])
+		if err != nil {
+			return nil, err
+		}
+		ds := ksk.ToDS(dnslib.SHA256)
+		status := cdnv3.DNSSECZoneStatus{
+			Zone:      zone.Name,
+			DS:        fmt.Sprintf("%s IN DS %d %d %d %s", ds.Hdr.Name, ds.KeyTag, ds.Algorithm, ds.DigestType, strings.ToUpper(ds.Digest)),
+			KSKKeyTag: int(ksk.KeyTag()),
+			ZSKKeyTag: int(zsk.KeyTag()),
+		}
+		if activated, err := time.Parse(time.RFC3339, string(secret.Data[dnssecKeyName(zone.Name, dnssecZSKActivated)])); err == nil {
+			status.ZSKActivated = metav1.NewTime(activated)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// rollKeys creates the missing keys of zone and rolls its zone signing key
+// over by pre-publication: the next key is published window ahead of the
+// rollover, and the previous one stays published window after it, so that
+// resolvers caching the DNSKEY set always know the signing key.
+func rollKeys(data map[string][]byte, zone string, period, window time.Duration, now time.Time) error {
+	name := func(key string) string { return dnssecKeyName(zone, key) }
+	has := func(key string) bool { return len(data[name(key)+".key"]) > 0 }
+	drop := func(key string) {
+		delete(data, name(key)+".key")
+		delete(data, name(key)+".private")
+	}
+	move := func(from, to string) {
+		data[name(to)+".key"] = data[name(from)+".key"]
+		data[name(to)+".private"] = data[name(from)+".private"]
+		drop(from)
+	}
+	generate := func(key string, flags uint16) error {
+		public, private, err := generateDNSKEY(zone, flags)
+		if err != nil {
+			return err
+		}
+		data[name(key)+".key"] = []byte(public)
+		data[name(key)+".private"] = []byte(private)
+		return nil
+	}
+
+	if !has(dnssecKSK) {
+		if err := generate(dnssecKSK, dnslib.SEP|dnslib.ZONE); err != nil {
+			return err
+		}
+	}
+	activated, err := time.Parse(time.RFC3339, string(data[name(dnssecZSKActivated)]))
+	if !has(dnssecZSK) {
+		if err := generate(dnssecZSK, dnslib.ZONE); err != nil {
+			return err
+		}
+		activated = now
+	} else if err != nil {
+		activated = now
+	}
+
+	switch {
+	case has(dnssecNextZSK) && !now.Before(activated.Add(period)):
+		move(dnssecZSK, dnssecOldZSK)
+		move(dnssecNextZSK, dnssecZSK)
+		activated = now
+	case !has(dnssecNextZSK) && !now.Before(activated.Add(period-window)):
+		if err := generate(dnssecNextZSK, dnslib.ZONE); err != nil {
+			return err
+		}
+	}
+	if has(dnssecOldZSK) && !now.Before(activated.Add(window)) {
+		drop(dnssecOldZSK)
+	}
+	data[name(dnssecZSKActivated)] = []byte(activated.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// dnssecPropagation is how long a change of the DNSKEY set takes to reach
+// every resolver: twice the TTL, and at least an hour for the DNS server
+// pods to pick it up.
+func dnssecPropagation(ttl uint32) time.Duration {
+	return max(time.Hour, 2*time.Duration(ttl)*time.Second)
+}
+
+// dnssecKeyName names a key of zone in the Secret and for the dns binary.
+func dnssecKeyName(zone, key string) string {
+	return strings.TrimSuffix(zone, ".") + "." + key
+}
+
+func generateDNSKEY(zone string, flags uint16) (string, string, error) {
+	key := &dnslib.DNSKEY{
+		Hdr:       dnslib.RR_Header{Name: zone, Rrtype: dnslib.TypeDNSKEY, Class: dnslib.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dnslib.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		return "", "", err
+	}
+	return key.String(), key.PrivateKeyString(private), nil
+}
+
+func parseDNSKEY(data []byte) (*dnslib.DNSKEY, error) {
+	rr, err := dnslib.NewRR(string(data))
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dnslib.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("not a DNSKEY: %q", data)
+	}
+	return key, nil
+}
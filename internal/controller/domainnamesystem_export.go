@@ -0,0 +1,266 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	cdnv3 "github.com/benauro/kube-cdn/api/v3"
+)
+
+const (
+	// ExternalDNS annotations read from Services and Ingresses
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+	// DomainNameSystem that set the ExternalDNS annotations, so they are only
+	// removed by it
+	externalDNSOwnerAnnotation = "cdn.benauro.gg/external-dns"
+)
+
+// DNSEndpoint of ExternalDNS, handled unstructured so the CRD stays optional
+var dnsEndpointGVK = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileZoneFiles renders the zones of dns as RFC 1035 zone files into
+// the <dns>-zonefile ConfigMap, one key per zone, or removes it when the
+// ZoneFile mode is off. Regions can't be expressed in a zone file, every
+// healthy edge is listed.
+func (r *DomainNameSystemReconciler) reconcileZoneFiles(ctx context.Context, dns *cdnv3.DomainNameSystem, zones []dnsZone, enabled bool) error {
+	name := dns.Name + "-zonefile"
+	if !enabled {
+		configMap := &corev1.ConfigMap{}
+		configMap.Name, configMap.Namespace = name, dns.Namespace
+		return client.IgnoreNotFound(r.Delete(ctx, configMap))
+	}
+	return r.reconcileZoneConfigMap(ctx, dns, name, zones, func(zones []dnsZone) (map[string]string, error) {
+		data := make(map[string]string, len(zones))
+		for i := range zones {
+			data[strings.TrimSuffix(zones[i].Name, ".")+".zone"] = zoneFile(&zones[i])
+		}
+		return data, nil
+	})
+}
+
+// zoneFile renders zone in the master file format, with the SOA timers the
+// dns binary serves.
+func zoneFile(zone *dnsZone) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s\n$TTL %d\n", zone.Name, zone.TTL)
+
+	hostmaster := "hostmaster." + zone.Name
+	if zone.Hostmaster != "" {
+		hostmaster = fqdn(zone.Hostmaster)
+	}
+	fmt.Fprintf(&b, "%s\tIN\tSOA\t%s %s %d 3600 600 604800 %d\n",
+		zone.Name, zone.Nameservers[0], hostmaster, zone.Serial, zone.TTL)
+	for _, ns := range zone.Nameservers {
+		fmt.Fprintf(&b, "%s\tIN\tNS\t%s\n", zone.Name, ns)
+	}
+	for _, ns := range zone.Nameservers {
+		if ns != zone.Name && !strings.HasSuffix(ns, "."+zone.Name) {
+			continue
+		}
+		for _, addr := range zone.NameserverAddresses {
+			writeAddressRecord(&b, ns, addr)
+		}
+	}
+	for _, host := range zone.Hosts {
+		for _, endpoint := range host.Endpoints {
+			writeAddressRecord(&b, host.Name, endpoint.Address)
+		}
+	}
+	return b.String()
+}
+
+func writeAddressRecord(b *strings.Builder, name, addr string) {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		fmt.Fprintf(b, "%s\tIN\tA\t%s\n", name, ip.To4())
+	default:
+		fmt.Fprintf(b, "%s\tIN\tAAAA\t%s\n", name, ip)
+	}
+}
+
+// reconcileDNSEndpoint publishes the hosts of dns through an ExternalDNS
+// DNSEndpoint named after it, or removes it when the DNSEndpoint mode is
+// off. Hosts without a healthy edge are left out.
+func (r *DomainNameSystemReconciler) reconcileDNSEndpoint(ctx context.Context, dns *cdnv3.DomainNameSystem, zones []dnsZone, enabled bool) error {
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(dnsEndpointGVK)
+	endpoint.SetName(dns.Name)
+	endpoint.SetNamespace(dns.Namespace)
+
+	if !enabled {
+		err := r.Delete(ctx, endpoint)
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return client.IgnoreNotFound(err)
+	}
+
+	var records []interface{}
+	for _, zone := range zones {
+		for _, host := range zone.Hosts {
+			targets := map[string][]interface{}{}
+			for _, e := range host.Endpoints {
+				if ip := net.ParseIP(e.Address); ip != nil && ip.To4() != nil {
+					targets["A"] = append(targets["A"], e.Address)
+				} else if ip != nil {
+					targets["AAAA"] = append(targets["AAAA"], e.Address)
+				}
+			}
+			for _, recordType := range []string{"A", "AAAA"} {
+				if len(targets[recordType]) == 0 {
+					continue
+				}
+				records = append(records, map[string]interface{}{
+					"dnsName":    strings.TrimSuffix(host.Name, "."),
+					"recordType": recordType,
+					"recordTTL":  int64(zone.TTL),
+					"targets":    targets[recordType],
+				})
+			}
+		}
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, endpoint, func() error {
+		if err := unstructured.SetNestedSlice(endpoint.Object, records, "spec", "endpoints"); err != nil {
+			return err
+		}
+		return controllerutil.SetControllerReference(dns, endpoint, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("ExternalDNS DNSEndpoint CRD is not installed: %w", err)
+	}
+	return err
+}
+
+// reconcileExternalDNSAnnotations sets the ExternalDNS hostname and TTL
+// annotations on the edge Service of every CDN served by dns, and the TTL on
+// its Ingress or HTTPRoute whose rules already carry the hostname. Annotations it set
+// earlier are removed from the edges it no longer serves, or from all of
+// them when the Annotations mode is off.
+func (r *DomainNameSystemReconciler) reconcileExternalDNSAnnotations(ctx context.Context, dns *cdnv3.DomainNameSystem, cdns []*cdnv3.ContentDeliveryNetwork, enabled bool) error {
+	ttl := "30"
+	if dns.Spec.TTL > 0 {
+		ttl = strconv.Itoa(dns.Spec.TTL)
+	}
+	services := map[string]map[string]string{}
+	ingresses := map[string]map[string]string{}
+	routes := map[string]map[string]string{}
+	if enabled {
+		for _, cdn := range cdns {
+			services[cdn.Name+"-service"] = map[string]string{
+				externalDNSHostnameAnnotation: cdn.Spec.DomainName,
+				externalDNSTTLAnnotation:      ttl,
+				externalDNSOwnerAnnotation:    dns.Name,
+			}
+			ingresses[cdn.Name+"-ingress"] = map[string]string{
+				externalDNSTTLAnnotation:   ttl,
+				externalDNSOwnerAnnotation: dns.Name,
+			}
+			routes[cdn.Name+"-route"] = ingresses[cdn.Name+"-ingress"]
+		}
+	}
+
+	var serviceList corev1.ServiceList
+	if err := r.List(ctx, &serviceList, client.InNamespace(dns.Namespace)); err != nil {
+		return err
+	}
+	keys := []string{externalDNSHostnameAnnotation, externalDNSTTLAnnotation, externalDNSOwnerAnnotation}
+	for i := range serviceList.Items {
+		if err := r.annotateForExternalDNS(ctx, dns, &serviceList.Items[i], services, keys); err != nil {
+			return err
+		}
+	}
+
+	var ingressList networkingv1.IngressList
+	if err := r.List(ctx, &ingressList, client.InNamespace(dns.Namespace)); err != nil {
+		return err
+	}
+	keys = []string{externalDNSTTLAnnotation, externalDNSOwnerAnnotation}
+	for i := range ingressList.Items {
+		if err := r.annotateForExternalDNS(ctx, dns, &ingressList.Items[i], ingresses, keys); err != nil {
+			return err
+		}
+	}
+
+	installed, err := kindInstalled(r.RESTMapper(), httpRouteGVK)
+	if err != nil || !installed {
+		return err
+	}
+	routeList := &unstructured.UnstructuredList{}
+	routeList.SetGroupVersionKind(httpRouteGVK.GroupVersion().WithKind("HTTPRouteList"))
+	if err := r.List(ctx, routeList, client.InNamespace(dns.Namespace)); err != nil {
+		return err
+	}
+	for i := range routeList.Items {
+		if err := r.annotateForExternalDNS(ctx, dns, &routeList.Items[i], routes, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotateForExternalDNS patches the keys annotations of obj to the ones
+// desired for it, or strips them when dns set them but no longer wants them.
+func (r *DomainNameSystemReconciler) annotateForExternalDNS(ctx context.Context, dns *cdnv3.DomainNameSystem, obj client.Object, desired map[string]map[string]string, keys []string) error {
+	annotations := obj.GetAnnotations()
+	want, ok := desired[obj.GetName()]
+	if !ok && annotations[externalDNSOwnerAnnotation] != dns.Name {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	updated := make(map[string]string, len(annotations)+len(keys))
+	for k, v := range annotations {
+		updated[k] = v
+	}
+	changed := false
+	for _, k := range keys {
+		if v, set := want[k]; set && updated[k] != v {
+			updated[k] = v
+			changed = true
+		} else if _, has := updated[k]; has && !set {
+			delete(updated, k)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	obj.SetAnnotations(updated)
+	return client.IgnoreNotFound(r.Patch(ctx, obj, patch))
+}